@@ -1,30 +1,74 @@
 package api
 
 import (
+	errors2 "errors"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/render"
 	"net/http"
+	"test/auth"
+	"test/errors"
 	"test/user"
-	"test/utils"
 	"time"
 )
 
+var (
+	ErrErrorFormat = errors2.New("unknown error format, " + errors.FormatProblem + " or " + errors.FormatLegacy + " expected")
+)
+
+// Config gathers the backends and services the API is built on.
+type Config struct {
+	UsersStore   user.UserRepository
+	WebhookStore user.WebhookStore //user.WebhookMemoryStore when nil
+	Events       *user.EventStream //streamed at /users/events, a new one when nil
+	Tokens       *auth.TokenManager
+	Revocations  auth.RevocationStore
+	Policy       user.Policy //user.RolePolicy when nil
+	ErrorFormat  string      //of the requests not negotiating one, errors.FormatProblem when empty, or errors.FormatLegacy
+}
+
 // API provides application resources and handlers.
 type API struct {
-	Resource *user.UsersResource
+	Resource    *user.UsersResource
+	Audit       *user.AuditResource
+	Webhooks    *user.WebhookResource
+	Events      *user.EventStreamResource
+	Auth        *auth.AuthResource
+	ErrorFormat string
 }
 
-// NewAPI configures and returns application API.
-func NewAPI(dbConnection utils.DbConnection) (*API, error) {
-	usersStore, err := user.NewUsersStore(dbConnection.Database, dbConnection.Ctx)
-	if err != nil {
-		return nil, err
+// NewAPI configures and returns application API on top of the given backends.
+func NewAPI(config Config) (*API, error) {
+	policy := config.Policy
+	if policy == nil {
+		policy = user.RolePolicy{}
+	}
+	webhookStore := config.WebhookStore
+	if webhookStore == nil {
+		webhookStore = user.NewWebhookMemoryStore()
+	}
+	events := config.Events
+	if events == nil {
+		events = user.NewEventStream(user.DefaultEventReplay)
+	}
+	resource := user.NewUsersResource(config.UsersStore, policy)
+	authResource := auth.NewAuthResource(config.UsersStore, config.Tokens, config.Revocations)
+
+	errorFormat := config.ErrorFormat
+	if errorFormat == "" {
+		errorFormat = errors.FormatProblem
+	}
+	if errorFormat != errors.FormatProblem && errorFormat != errors.FormatLegacy {
+		return nil, ErrErrorFormat
 	}
-	resource := user.NewUsersResource(*usersStore)
 
 	Api := &API{
-		Resource: resource,
+		Resource:    resource,
+		Audit:       user.NewAuditResource(config.UsersStore.Audit(), policy),
+		Webhooks:    user.NewWebhookResource(webhookStore, policy),
+		Events:      user.NewEventStreamResource(events, policy),
+		Auth:        authResource,
+		ErrorFormat: errorFormat,
 	}
 	return Api, nil
 }
@@ -33,29 +77,44 @@ func NewAPI(dbConnection utils.DbConnection) (*API, error) {
 func (a *API) Router() *chi.Mux {
 	r := chi.NewRouter()
 
-	r.Mount("/users", a.Resource.Router())
+	r.Mount("/auth", a.Auth.Router())
 
 	return r
 }
 
 // New configures application resources and routes.
-func NewApp(dbConnection utils.DbConnection) (*chi.Mux, error) {
+func NewApp(config Config) (*chi.Mux, error) {
 
-	api, err := NewAPI(dbConnection)
+	api, err := NewAPI(config)
 	if err != nil {
 		return nil, err
 	}
 
 	//Init the routers
 	r := chi.NewRouter()
+	//the access token of a URL is removed before it is logged
+	r.Use(auth.StripQueryToken)
 	r.Use(middleware.Logger)
-	r.Use(middleware.Timeout(15 * time.Second))
 	r.Use(render.SetContentType(render.ContentTypeJSON))
+	r.Use(errors.FormatHandler(api.ErrorFormat))
+
+	//the stream of the events lasts until the client leaves, without the timeout of the other requests,
+	//a browser EventSource can't set a header so the access token can be the access_token parameter
+	r.With(api.Auth.QueryAuthenticator).Mount("/users/events", api.Events.Router())
+
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.Timeout(15 * time.Second))
+
+		r.Mount("/", api.Router())
 
-	r.Mount("/", api.Router())
+		//the Users are only reachable with an access token
+		r.With(api.Auth.Authenticator).Mount("/users", api.Resource.Router())
+		r.With(api.Auth.Authenticator).Mount("/audit", api.Audit.Router())
+		r.With(api.Auth.Authenticator).Mount("/webhooks", api.Webhooks.Router())
 
-	r.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("pong"))
+		r.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("pong"))
+		})
 	})
 
 	return r, nil
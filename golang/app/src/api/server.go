@@ -7,7 +7,7 @@ import (
 	"os"
 	"os/signal"
 	"strings"
-	"test/utils"
+	"test/user"
 )
 
 // Server provides an http.Server.
@@ -16,9 +16,13 @@ type Server struct {
 }
 
 // NewServer creates and configures an APIServer serving all application routes.
-func NewServer(dbConnection utils.DbConnection, test bool) (*Server, error) {
+func NewServer(config Config, test bool) (*Server, error) {
 	log.Println("configuring server...")
-	api, err := NewApp(dbConnection)
+	//the streams of the events are closed by the shutdown, which waits for the open connections
+	if config.Events == nil {
+		config.Events = user.NewEventStream(user.DefaultEventReplay)
+	}
+	api, err := NewApp(config)
 	if err != nil {
 		return nil, err
 	}
@@ -40,6 +44,7 @@ func NewServer(dbConnection utils.DbConnection, test bool) (*Server, error) {
 		Addr:    addr,
 		Handler: api,
 	}
+	srv.RegisterOnShutdown(config.Events.Close)
 
 	return &Server{&srv}, nil
 }
@@ -54,7 +59,7 @@ func (srv *Server) Start() {
 	}()
 	log.Printf("Listening on %s\n", srv.Addr)
 
-	quit := make(chan os.Signal)
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt)
 	sig := <-quit
 	log.Println("Shutting down server... Reason:", sig)
@@ -0,0 +1,326 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+	"strings"
+	errors2 "test/errors"
+	"test/user"
+	"test/utils"
+)
+
+//Implements the authentication handler
+
+var (
+	ErrMissingCredentials = errors2.Validation(errors.New("login and password required"))
+	ErrMissingToken       = errors.New("token required")
+	ErrTokenRevoked       = errors.New("token revoked")
+	ErrUnknownUser        = errors.New("user no longer exists")
+	ErrRoleChanged        = errors.New("role changed since the token was issued")
+)
+
+type contextKey struct {
+	name string
+}
+
+var (
+	claimsCtxKey     = &contextKey{"Claims"}
+	queryTokenCtxKey = &contextKey{"QueryToken"}
+)
+
+// QueryTokenParam is the parameter of the access token for the clients which can't set a header.
+const QueryTokenParam = "access_token"
+
+// AuthResource implements the authentication handler.
+type AuthResource struct {
+	Users       user.UserRepository
+	Tokens      *TokenManager
+	Revocations RevocationStore
+}
+
+// NewAuthResource creates and returns an authentication resource.
+func NewAuthResource(users user.UserRepository, tokens *TokenManager, revocations RevocationStore) *AuthResource {
+	return &AuthResource{
+		Users:       users,
+		Tokens:      tokens,
+		Revocations: revocations,
+	}
+}
+
+// Router for the authentication
+func (rs *AuthResource) Router() *chi.Mux {
+	r := chi.NewRouter()
+	r.Post("/login", rs.login)
+	r.Post("/refresh", rs.refresh)
+	r.Post("/logout", rs.logout)
+	return r
+}
+
+// Authenticator lets through only the requests with a valid access token,
+// its Claims are then available with ClaimsFromContext, and the user.Principal with user.PrincipalFromContext.
+func (rs *AuthResource) Authenticator(next http.Handler) http.Handler {
+	return rs.authenticate(next, tokenFromHeader)
+}
+
+// QueryAuthenticator is the Authenticator also accepting the access token as the access_token parameter,
+// for the clients which can't set a header such as a browser EventSource. The header is used when both are set.
+// The parameter is only read once removed from the URL by StripQueryToken.
+func (rs *AuthResource) QueryAuthenticator(next http.Handler) http.Handler {
+	return rs.authenticate(next, func(r *http.Request) string {
+		if token := tokenFromHeader(r); token != "" {
+			return token
+		}
+		token, _ := r.Context().Value(queryTokenCtxKey).(string)
+		return token
+	})
+}
+
+// StripQueryToken removes the access_token parameter from the URL of every request, so it is neither logged nor
+// repeated in an error, and keeps it for the QueryAuthenticator. It goes before any middleware reading the URL.
+func StripQueryToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if _, ok := query[QueryTokenParam]; !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token := query.Get(QueryTokenParam)
+		query.Del(QueryTokenParam)
+		stripped := r.WithContext(context.WithValue(r.Context(), queryTokenCtxKey, token))
+		url := *r.URL
+		url.RawQuery = query.Encode()
+		stripped.URL = &url
+		stripped.RequestURI = url.RequestURI()
+		next.ServeHTTP(w, stripped)
+	})
+}
+
+//authenticate lets through the requests with a valid access token, read by tokenFrom
+func (rs *AuthResource) authenticate(next http.Handler, tokenFrom func(r *http.Request) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := tokenFrom(r)
+		if token == "" {
+			utils.RenderUnauthorized(w, r, ErrMissingToken)
+			return
+		}
+		claims, err := rs.verify(token, TokenAccess)
+		if err != nil {
+			utils.RenderUnauthorized(w, r, err)
+			return
+		}
+		ctx := context.WithValue(r.Context(), claimsCtxKey, claims)
+		ctx = user.NewPrincipalContext(ctx, &user.Principal{
+			ID:      claims.Subject,
+			Role:    claims.Role,
+			Expires: claims.Expiration(),
+			Check:   func() error { return rs.check(token, claims) },
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ClaimsFromContext returns the Claims of the authenticated request.
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsCtxKey).(*Claims)
+	return claims, ok
+}
+
+// Request expected for the login
+type loginRequest struct {
+	Login    string `json:"login"` //nickname or email
+	Password string `json:"password"`
+}
+
+//Binding of the http request to the loginRequest
+func (lr *loginRequest) Bind(r *http.Request) error {
+	if lr.Login == "" || lr.Password == "" {
+		return ErrMissingCredentials
+	}
+	return nil
+}
+
+// Request expected for the refresh and the logout
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+//Binding of the http request to the refreshRequest
+func (rr *refreshRequest) Bind(r *http.Request) error {
+	return nil
+}
+
+//Response model for the tokens
+type tokensResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"` //lifetime of the access token in seconds
+}
+
+// Checks the credentials then returns new tokens
+func (rs *AuthResource) login(w http.ResponseWriter, r *http.Request) {
+	lR := &loginRequest{}
+	if err := render.Bind(r, lR); err != nil {
+		utils.Render(w, r, err)
+		return
+	}
+
+	//the nickname and the email are saved normalized
+	login := user.NormalizeString(lR.Login)
+	u, err := rs.Users.VerifyPassword(login, lR.Password)
+	if err == user.ErrInvalidCredentials {
+		utils.RenderUnauthorized(w, r, err)
+		return
+	}
+	if err != nil {
+		utils.Render(w, r, err)
+		return
+	}
+
+	rs.respondTokens(w, r, u.ID, u.Role)
+}
+
+// Exchanges a refresh token for new tokens, the refresh token can be used only once
+func (rs *AuthResource) refresh(w http.ResponseWriter, r *http.Request) {
+	rR := &refreshRequest{}
+	if err := render.Bind(r, rR); err != nil {
+		utils.Render(w, r, err)
+		return
+	}
+	if rR.RefreshToken == "" {
+		utils.RenderUnauthorized(w, r, ErrMissingToken)
+		return
+	}
+	claims, err := rs.verify(rR.RefreshToken, TokenRefresh)
+	if err != nil {
+		utils.RenderUnauthorized(w, r, err)
+		return
+	}
+	//only the first of concurrent refreshes claims the token
+	claimed, err := rs.Revocations.Revoke(claims.ID, claims.Expiration())
+	if err != nil {
+		utils.Render(w, r, err)
+		return
+	}
+	if !claimed {
+		utils.RenderUnauthorized(w, r, ErrTokenRevoked)
+		return
+	}
+	//the role may have changed, and the User been deleted, since the login
+	u, err := rs.Users.Get(claims.Subject)
+	if err == mongo.ErrNoDocuments {
+		utils.RenderUnauthorized(w, r, ErrUnknownUser)
+		return
+	}
+	if err != nil {
+		utils.Render(w, r, err)
+		return
+	}
+
+	rs.respondTokens(w, r, u.ID, u.Role)
+}
+
+// Revokes the refresh token of the body and the access token of the header
+func (rs *AuthResource) logout(w http.ResponseWriter, r *http.Request) {
+	rR := &refreshRequest{}
+	if err := render.Bind(r, rR); err != nil {
+		utils.Render(w, r, err)
+		return
+	}
+
+	var revoked []*Claims
+	if rR.RefreshToken != "" {
+		claims, err := rs.Tokens.Parse(rR.RefreshToken, TokenRefresh)
+		if err != nil {
+			utils.RenderUnauthorized(w, r, err)
+			return
+		}
+		revoked = append(revoked, claims)
+	}
+	if token := tokenFromHeader(r); token != "" {
+		claims, err := rs.Tokens.Parse(token, TokenAccess)
+		if err != nil {
+			utils.RenderUnauthorized(w, r, err)
+			return
+		}
+		revoked = append(revoked, claims)
+	}
+	if len(revoked) == 0 {
+		utils.RenderUnauthorized(w, r, ErrMissingToken)
+		return
+	}
+
+	for _, claims := range revoked {
+		if _, err := rs.Revocations.Revoke(claims.ID, claims.Expiration()); err != nil {
+			utils.Render(w, r, err)
+			return
+		}
+	}
+	render.NoContent(w, r)
+}
+
+func (rs *AuthResource) respondTokens(w http.ResponseWriter, r *http.Request, subject, role string) {
+	accessToken, accessClaims, err := rs.Tokens.Issue(subject, role, TokenAccess)
+	if err != nil {
+		utils.Render(w, r, err)
+		return
+	}
+	refreshToken, _, err := rs.Tokens.Issue(subject, role, TokenRefresh)
+	if err != nil {
+		utils.Render(w, r, err)
+		return
+	}
+
+	render.Respond(w, r, &tokensResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    accessClaims.ExpiresAt - accessClaims.IssuedAt,
+	})
+}
+
+//verify parses the token and checks it was not revoked
+func (rs *AuthResource) verify(token, tokenType string) (*Claims, error) {
+	claims, err := rs.Tokens.Parse(token, tokenType)
+	if err != nil {
+		return nil, err
+	}
+	revoked, err := rs.Revocations.IsRevoked(claims.ID)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrTokenRevoked
+	}
+	return claims, nil
+}
+
+//check verifies again the access token of a long request, and that its User still exists with the same role
+func (rs *AuthResource) check(token string, claims *Claims) error {
+	if _, err := rs.verify(token, TokenAccess); err != nil {
+		return err
+	}
+	u, err := rs.Users.Get(claims.Subject)
+	if err == mongo.ErrNoDocuments {
+		return ErrUnknownUser
+	}
+	if err != nil {
+		return err
+	}
+	if u.Role != claims.Role {
+		return ErrRoleChanged
+	}
+	return nil
+}
+
+func tokenFromHeader(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return header[7:]
+	}
+	return ""
+}
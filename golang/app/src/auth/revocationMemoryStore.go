@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// RevocationMemoryStore implements the RevocationStore in memory.
+// It is safe for concurrent use.
+type RevocationMemoryStore struct {
+	mu      sync.Mutex
+	revoked map[string]time.Time
+}
+
+// NewRevocationMemoryStore returns an empty RevocationMemoryStore
+func NewRevocationMemoryStore() *RevocationMemoryStore {
+	return &RevocationMemoryStore{
+		revoked: make(map[string]time.Time),
+	}
+}
+
+// Revoke adds the token id to the revocation list, and forgets the expired ones.
+// It reports false when the token id was already in the list.
+func (s *RevocationMemoryStore) Revoke(id string, expiresAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for revokedId, revokedExpiresAt := range s.revoked {
+		if revokedExpiresAt.Before(now) {
+			delete(s.revoked, revokedId)
+		}
+	}
+	if _, ok := s.revoked[id]; ok {
+		return false, nil
+	}
+	s.revoked[id] = expiresAt
+	return true, nil
+}
+
+// IsRevoked reports if the token id is in the revocation list.
+func (s *RevocationMemoryStore) IsRevoked(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.revoked[id]
+	return ok, nil
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// RevocationStore keeps the ids of the revoked tokens until they expire.
+// Revoke is an atomic claim: it reports false when the token was already revoked, so a token revoked once is used once.
+type RevocationStore interface {
+	Revoke(id string, expiresAt time.Time) (bool, error)
+	IsRevoked(id string) (bool, error)
+}
+
+// RevocationMongoStore implements the RevocationStore on MongoDB
+type RevocationMongoStore struct {
+	collection *mongo.Collection
+	ctx        context.Context
+}
+
+// NewRevocationMongoStore returns a RevocationMongoStore, the entries are removed by mongo once expired
+func NewRevocationMongoStore(db *mongo.Database, ctx context.Context) (*RevocationMongoStore, error) {
+	revokedCollection := db.Collection("revoked_tokens")
+	_, err := revokedCollection.Indexes().CreateOne(
+		context.Background(),
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RevocationMongoStore{
+		collection: revokedCollection,
+		ctx:        ctx,
+	}, nil
+}
+
+// Revoke adds the token id to the revocation list, it reports false when it was already in it.
+func (s *RevocationMongoStore) Revoke(id string, expiresAt time.Time) (bool, error) {
+	//the unique _id lets only one of concurrent revocations insert it
+	_, err := s.collection.InsertOne(s.ctx, bson.M{"_id": id, "expires_at": expiresAt})
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// IsRevoked reports if the token id is in the revocation list.
+func (s *RevocationMongoStore) IsRevoked(id string) (bool, error) {
+	count, err := s.collection.CountDocuments(s.ctx, bson.M{"_id": id})
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,230 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"time"
+)
+
+//Implements the signed JWT used as access and refresh tokens
+
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+
+	TokenAccess  = "access"
+	TokenRefresh = "refresh"
+)
+
+var (
+	ErrTokenInvalid   = errors.New("invalid token")
+	ErrTokenExpired   = errors.New("token expired")
+	ErrTokenType      = errors.New("wrong token type")
+	ErrTokenAlgorithm = errors.New("unknown token signing algorithm")
+	ErrTokenSecret    = errors.New("token signing secret required")
+)
+
+// TokenConfig configures the signature and the lifetimes of the tokens.
+// HS256 uses the Secret, RS256 the PrivateKey.
+type TokenConfig struct {
+	Algorithm  string
+	Secret     []byte
+	PrivateKey *rsa.PrivateKey
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
+// Claims represents the payload of a token
+type Claims struct {
+	ID        string `json:"jti"`
+	Subject   string `json:"sub"` //the User id
+	Role      string `json:"role"`
+	Type      string `json:"typ"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Expiration returns the expiration date of the token
+func (c *Claims) Expiration() time.Time {
+	return time.Unix(c.ExpiresAt, 0)
+}
+
+type tokenHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+}
+
+// TokenManager issues and verifies the tokens.
+type TokenManager struct {
+	config TokenConfig
+}
+
+// NewTokenManager returns a TokenManager, 15 minutes access and 30 days refresh tokens by default.
+func NewTokenManager(config TokenConfig) (*TokenManager, error) {
+	switch config.Algorithm {
+	case "":
+		config.Algorithm = HS256
+		fallthrough
+	case HS256:
+		if len(config.Secret) == 0 {
+			return nil, ErrTokenSecret
+		}
+	case RS256:
+		if config.PrivateKey == nil {
+			return nil, ErrTokenSecret
+		}
+	default:
+		return nil, ErrTokenAlgorithm
+	}
+	if config.AccessTTL == 0 {
+		config.AccessTTL = 15 * time.Minute
+	}
+	if config.RefreshTTL == 0 {
+		config.RefreshTTL = 30 * 24 * time.Hour
+	}
+	return &TokenManager{config: config}, nil
+}
+
+// ParseRSAPrivateKey reads a PEM encoded (PKCS#1 or PKCS#8) RSA private key.
+func ParseRSAPrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM private key found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not a RSA key")
+	}
+	return rsaKey, nil
+}
+
+// Issue returns a signed token of the tokenType for the User id and role, with its claims.
+func (m *TokenManager) Issue(subject, role, tokenType string) (string, *Claims, error) {
+	ttl := m.config.AccessTTL
+	if tokenType == TokenRefresh {
+		ttl = m.config.RefreshTTL
+	}
+	id, err := newTokenID()
+	if err != nil {
+		return "", nil, err
+	}
+	now := time.Now()
+	claims := &Claims{
+		ID:        id,
+		Subject:   subject,
+		Role:      role,
+		Type:      tokenType,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	}
+
+	header, err := encodeSegment(tokenHeader{Algorithm: m.config.Algorithm, Type: "JWT"})
+	if err != nil {
+		return "", nil, err
+	}
+	payload, err := encodeSegment(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	signingInput := header + "." + payload
+	signature, err := m.sign([]byte(signingInput))
+	if err != nil {
+		return "", nil, err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), claims, nil
+}
+
+// Parse verifies the signature, the expiration and the type of the token then returns its claims.
+func (m *TokenManager) Parse(token, tokenType string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenInvalid
+	}
+	var header tokenHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrTokenInvalid
+	}
+	//only the configured algorithm is accepted
+	if header.Algorithm != m.config.Algorithm {
+		return nil, ErrTokenInvalid
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+	if !m.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrTokenInvalid
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrTokenInvalid
+	}
+	if !time.Now().Before(claims.Expiration()) {
+		return nil, ErrTokenExpired
+	}
+	if claims.Type != tokenType {
+		return nil, ErrTokenType
+	}
+	return &claims, nil
+}
+
+func (m *TokenManager) sign(input []byte) ([]byte, error) {
+	if m.config.Algorithm == RS256 {
+		digest := sha256.Sum256(input)
+		return rsa.SignPKCS1v15(rand.Reader, m.config.PrivateKey, crypto.SHA256, digest[:])
+	}
+	mac := hmac.New(sha256.New, m.config.Secret)
+	mac.Write(input)
+	return mac.Sum(nil), nil
+}
+
+func (m *TokenManager) verify(input, signature []byte) bool {
+	if m.config.Algorithm == RS256 {
+		digest := sha256.Sum256(input)
+		return rsa.VerifyPKCS1v15(&m.config.PrivateKey.PublicKey, crypto.SHA256, digest[:], signature) == nil
+	}
+	expected, _ := m.sign(input)
+	return hmac.Equal(expected, signature)
+}
+
+func encodeSegment(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"test/user"
+	"testing"
+	"time"
+)
+
+type tokenManagerTest struct {
+	config      TokenConfig
+	expectedErr bool
+}
+
+func TestNewTokenManager(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenManagerTests := []tokenManagerTest{
+		//test normal behaviors
+		{config: TokenConfig{Secret: []byte("secret")}, expectedErr: false},
+		{config: TokenConfig{Algorithm: HS256, Secret: []byte("secret")}, expectedErr: false},
+		{config: TokenConfig{Algorithm: RS256, PrivateKey: privateKey}, expectedErr: false},
+		//test missing secret
+		{config: TokenConfig{Algorithm: HS256}, expectedErr: true},
+		{config: TokenConfig{Algorithm: RS256, Secret: []byte("secret")}, expectedErr: true},
+		//test unknown algorithm
+		{config: TokenConfig{Algorithm: "none", Secret: []byte("secret")}, expectedErr: true},
+	}
+
+	for _, item := range tokenManagerTests {
+		_, resultErr := NewTokenManager(item.config)
+		if !item.expectedErr && resultErr != nil {
+			t.Errorf("NewTokenManager for %v output err %v not expected", item.config.Algorithm, resultErr.Error())
+		}
+		if item.expectedErr && resultErr == nil {
+			t.Errorf("NewTokenManager for %v output err expected but not found", item.config.Algorithm)
+		}
+	}
+}
+
+type tokenParseTest struct {
+	token       string
+	tokenType   string
+	manager     *TokenManager
+	expectedErr error
+}
+
+func TestTokenParse(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hsManager, _ := NewTokenManager(TokenConfig{Secret: []byte("secret")})
+	otherHsManager, _ := NewTokenManager(TokenConfig{Secret: []byte("other secret")})
+	rsManager, _ := NewTokenManager(TokenConfig{Algorithm: RS256, PrivateKey: privateKey})
+	expiredManager, _ := NewTokenManager(TokenConfig{Secret: []byte("secret"), AccessTTL: -time.Minute})
+
+	hsToken, hsClaims, err := hsManager.Issue("61e41ed578752c5997718aff", user.RoleSelf, TokenAccess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsToken, _, err := rsManager.Issue("61e41ed578752c5997718aff", user.RoleSelf, TokenRefresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiredToken, _, _ := expiredManager.Issue("61e41ed578752c5997718aff", user.RoleSelf, TokenAccess)
+	//same signature with another subject
+	parts := strings.Split(hsToken, ".")
+	tamperedClaims := *hsClaims
+	tamperedClaims.Subject = "61e41ed578752c5997718aee"
+	tamperedPayload, _ := encodeSegment(tamperedClaims)
+	tamperedToken := parts[0] + "." + tamperedPayload + "." + parts[2]
+
+	tokenParseTests := []tokenParseTest{
+		//test normal behaviors
+		{token: hsToken, tokenType: TokenAccess, manager: hsManager, expectedErr: nil},
+		{token: rsToken, tokenType: TokenRefresh, manager: rsManager, expectedErr: nil},
+		//test wrong type
+		{token: hsToken, tokenType: TokenRefresh, manager: hsManager, expectedErr: ErrTokenType},
+		//test wrong signature
+		{token: hsToken, tokenType: TokenAccess, manager: otherHsManager, expectedErr: ErrTokenInvalid},
+		{token: tamperedToken, tokenType: TokenAccess, manager: hsManager, expectedErr: ErrTokenInvalid},
+		{token: hsToken, tokenType: TokenAccess, manager: rsManager, expectedErr: ErrTokenInvalid},
+		{token: "foo", tokenType: TokenAccess, manager: hsManager, expectedErr: ErrTokenInvalid},
+		//test expired
+		{token: expiredToken, tokenType: TokenAccess, manager: hsManager, expectedErr: ErrTokenExpired},
+	}
+
+	for index, item := range tokenParseTests {
+		resultClaims, resultErr := item.manager.Parse(item.token, item.tokenType)
+		if resultErr != item.expectedErr {
+			t.Errorf("TokenManager.Parse of index %v output err %v but expected %v", index, resultErr, item.expectedErr)
+		}
+		if resultErr == nil && resultClaims.Subject != "61e41ed578752c5997718aff" {
+			t.Errorf("TokenManager.Parse of index %v output subject %v", index, resultClaims.Subject)
+		}
+	}
+
+	//the claims are the ones issued
+	resultClaims, _ := hsManager.Parse(hsToken, TokenAccess)
+	if *resultClaims != *hsClaims {
+		t.Errorf("TokenManager.Parse output %v but expected %v", resultClaims, hsClaims)
+	}
+}
+
+func TestRevocationMemoryStore(t *testing.T) {
+	store := NewRevocationMemoryStore()
+	_, _ = store.Revoke("expired", time.Now().Add(-time.Minute))
+	_, _ = store.Revoke("revoked", time.Now().Add(time.Minute))
+
+	if revoked, _ := store.IsRevoked("revoked"); !revoked {
+		t.Errorf("RevocationMemoryStore.IsRevoked output false for a revoked token")
+	}
+	if revoked, _ := store.IsRevoked("valid"); revoked {
+		t.Errorf("RevocationMemoryStore.IsRevoked output true for a non revoked token")
+	}
+	//expired entries are forgotten at the next revocation
+	_, _ = store.Revoke("other", time.Now().Add(time.Minute))
+	if revoked, _ := store.IsRevoked("expired"); revoked {
+		t.Errorf("RevocationMemoryStore.IsRevoked output true for an expired token")
+	}
+
+	//only one of concurrent revocations claims the token
+	var claimed int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _ := store.Revoke("concurrent", time.Now().Add(time.Minute)); ok {
+				atomic.AddInt32(&claimed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if claimed != 1 {
+		t.Errorf("RevocationMemoryStore.Revoke claimed a token %v times", claimed)
+	}
+}
+
+func TestStripQueryToken(t *testing.T) {
+	stripQueryTokenTests := []struct {
+		uri           string
+		expectedURI   string
+		expectedToken string
+	}{
+		{uri: "/users/events?country=US&access_token=secret", expectedURI: "/users/events?country=US", expectedToken: "secret"},
+		{uri: "/users/events?access_token=secret", expectedURI: "/users/events", expectedToken: "secret"},
+		{uri: "/users/events?country=US", expectedURI: "/users/events?country=US", expectedToken: ""},
+	}
+	for _, item := range stripQueryTokenTests {
+		var resultURI, resultRequestURI, resultToken string
+		StripQueryToken(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			resultURI, resultRequestURI = r.URL.RequestURI(), r.RequestURI
+			resultToken, _ = r.Context().Value(queryTokenCtxKey).(string)
+		})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", item.uri, nil))
+		if resultURI != item.expectedURI || resultRequestURI != item.expectedURI || resultToken != item.expectedToken {
+			t.Errorf("StripQueryToken for %v output %v %v and token %q but expected %v and token %q", item.uri, resultURI, resultRequestURI, resultToken, item.expectedURI, item.expectedToken)
+		}
+	}
+}
@@ -1,10 +1,169 @@
 package errors
 
 import (
+	"context"
+	stderrors "errors"
 	"github.com/go-chi/render"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
 	"net/http"
+	"sort"
+	"strings"
 )
 
+// Application error codes, sent as the "code" of the error responses.
+// They are stable: a code is never reused for another case, so the clients can branch on them.
+const (
+	CodeUnprocessable        int64 = 1000 //422 unclassified error, no longer returned since CodeInternal
+	CodeValidation           int64 = 1001 //422 a field is missing or invalid
+	CodeBadRequest           int64 = 1100 //400 malformed body or query parameter
+	CodeInvalidID            int64 = 1101 //400 the id is not a valid ObjectID
+	CodeUnauthorized         int64 = 1200 //401 missing, invalid or revoked credentials
+	CodeForbidden            int64 = 1300 //403 action not allowed for the caller role
+	CodeNotFound             int64 = 1400 //404 no User with this id
+	CodeConflict             int64 = 1500 //409 email or nickname already used
+	CodeUnsupportedMediaType int64 = 1600 //415 unsupported Content-Type
+	CodeUnavailable          int64 = 1700 //503 the database can't be reached
+	CodeQueryTimeout         int64 = 1701 //503 the query took longer than its time limit
+	CodePreconditionFailed   int64 = 1800 //412 the If-Match version is not the current one
+	CodeInternal             int64 = 1900 //500 unexpected server error, the cause is only logged
+)
+
+var (
+	ErrDatabaseUnavailable = stderrors.New("database unavailable")
+	ErrInternal            = stderrors.New("internal error")
+)
+
+// AppError is an error classified with its http status and its application code.
+type AppError struct {
+	Status int
+	Code   int64
+	Err    error
+}
+
+func (e *AppError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *AppError) Unwrap() error {
+	return e.Err
+}
+
+func newAppError(err error, status int, code int64) *AppError {
+	return &AppError{Status: status, Code: code, Err: err}
+}
+
+// Unauthorized returns err classified as missing or invalid credentials (401).
+func Unauthorized(err error) error {
+	return newAppError(err, http.StatusUnauthorized, CodeUnauthorized)
+}
+
+// Forbidden returns err classified as a denied action (403).
+func Forbidden(err error) error {
+	return newAppError(err, http.StatusForbidden, CodeForbidden)
+}
+
+// UnsupportedMediaType returns err classified as an unsupported Content-Type (415).
+func UnsupportedMediaType(err error) error {
+	return newAppError(err, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType)
+}
+
+// PreconditionFailed returns err classified as a failed request precondition (412).
+func PreconditionFailed(err error) error {
+	return newAppError(err, http.StatusPreconditionFailed, CodePreconditionFailed)
+}
+
+// FieldErrors holds the validation errors by json field name.
+type FieldErrors map[string]string
+
+func (fe FieldErrors) Error() string {
+	fields := make([]string, 0, len(fe))
+	for field := range fe {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	messages := make([]string, 0, len(fe))
+	for _, field := range fields {
+		messages = append(messages, field+": "+fe[field])
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Validation returns err classified as a missing or invalid field (422).
+// With FieldErrors as err, each field error is returned in the response.
+func Validation(err error) error {
+	return newAppError(err, http.StatusUnprocessableEntity, CodeValidation)
+}
+
+// BadRequest returns err classified as a malformed request (400).
+func BadRequest(err error) error {
+	return newAppError(err, http.StatusBadRequest, CodeBadRequest)
+}
+
+// InvalidID returns err classified as a malformed id (400).
+func InvalidID(err error) error {
+	return newAppError(err, http.StatusBadRequest, CodeInvalidID)
+}
+
+// NotFound returns err classified as a missing resource (404).
+func NotFound(err error) error {
+	return newAppError(err, http.StatusNotFound, CodeNotFound)
+}
+
+// Conflict returns err classified as a duplicate value (409).
+func Conflict(err error) error {
+	return newAppError(err, http.StatusConflict, CodeConflict)
+}
+
+// Unavailable returns err classified as an unreachable database (503).
+func Unavailable(err error) error {
+	return newAppError(err, http.StatusServiceUnavailable, CodeUnavailable)
+}
+
+// QueryTimeout returns err classified as a query stopped by its time limit (503).
+func QueryTimeout(err error) error {
+	return newAppError(err, http.StatusServiceUnavailable, CodeQueryTimeout)
+}
+
+// Classify returns the AppError of err, the errors of the mongo driver are classified according to their cause.
+// An unknown error is a server fault (500) whose cause is hidden, utils.Render logs it.
+func Classify(err error) *AppError {
+	var appErr *AppError
+	if stderrors.As(err, &appErr) {
+		return appErr
+	}
+	switch {
+	case stderrors.Is(err, mongo.ErrNoDocuments):
+		return newAppError(err, http.StatusNotFound, CodeNotFound)
+	case stderrors.Is(err, primitive.ErrInvalidHex):
+		return newAppError(err, http.StatusBadRequest, CodeInvalidID)
+	case mongo.IsDuplicateKeyError(err):
+		return newAppError(err, http.StatusConflict, CodeConflict)
+	case isMaxTimeExpired(err):
+		return newAppError(err, http.StatusServiceUnavailable, CodeQueryTimeout)
+	case isUnavailable(err):
+		//the cause is only logged, it describes the database topology
+		return newAppError(ErrDatabaseUnavailable, http.StatusServiceUnavailable, CodeUnavailable)
+	}
+	return newAppError(ErrInternal, http.StatusInternalServerError, CodeInternal)
+}
+
+//isMaxTimeExpired reports if the server stopped the query at its max time (MaxTimeMSExpired)
+func isMaxTimeExpired(err error) bool {
+	var commandErr mongo.CommandError
+	return stderrors.As(err, &commandErr) && commandErr.Code == 50
+}
+
+func isUnavailable(err error) bool {
+	var selectionErr topology.ServerSelectionError
+	return stderrors.As(err, &selectionErr) ||
+		stderrors.Is(err, mongo.ErrClientDisconnected) ||
+		stderrors.Is(err, context.DeadlineExceeded) ||
+		mongo.IsNetworkError(err) ||
+		mongo.IsTimeout(err)
+}
+
 // ErrResponse renderer type for handling all sorts of errors.
 type ErrResponse struct {
 	Err            error `json:"-"` // low-level runtime error
@@ -21,12 +180,18 @@ func (e *ErrResponse) Render(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
-// ErrRender returns status 422 Unprocessable Entity rendering response error.
-func ErrRender(err error) render.Renderer {
+func newErrResponse(err error, status int, code int64) *ErrResponse {
 	return &ErrResponse{
 		Err:            err,
-		HTTPStatusCode: http.StatusUnprocessableEntity,
-		StatusText:     http.StatusText(http.StatusUnprocessableEntity),
+		HTTPStatusCode: status,
+		StatusText:     http.StatusText(status),
+		AppCode:        code,
 		ErrorText:      err.Error(),
 	}
 }
+
+// ErrRender returns the legacy rendering response error of the status and code classifying err, 500 Internal Server Error by default.
+func ErrRender(err error) render.Renderer {
+	appErr := Classify(err)
+	return newErrResponse(appErr.Err, appErr.Status, appErr.Code)
+}
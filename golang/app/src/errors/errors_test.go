@@ -0,0 +1,124 @@
+package errors
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type classifyTest struct {
+	err            error
+	expectedStatus int
+	expectedCode   int64
+}
+
+func TestClassify(t *testing.T) {
+	_, invalidHexErr := primitive.ObjectIDFromHex("61e41ed5787")
+	duplicateErr := mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000, Message: "E11000 duplicate key error"}}}
+
+	classifyTests := []classifyTest{
+		//test the typed errors
+		{err: Validation(stderrors.New("first_name value required")), expectedStatus: http.StatusUnprocessableEntity, expectedCode: CodeValidation},
+		{err: BadRequest(stderrors.New("Date format error")), expectedStatus: http.StatusBadRequest, expectedCode: CodeBadRequest},
+		{err: Conflict(stderrors.New("email value already used")), expectedStatus: http.StatusConflict, expectedCode: CodeConflict},
+		{err: fmt.Errorf("wrapped: %w", NotFound(stderrors.New("missing"))), expectedStatus: http.StatusNotFound, expectedCode: CodeNotFound},
+		//test the mongo errors
+		{err: mongo.ErrNoDocuments, expectedStatus: http.StatusNotFound, expectedCode: CodeNotFound},
+		{err: invalidHexErr, expectedStatus: http.StatusBadRequest, expectedCode: CodeInvalidID},
+		{err: duplicateErr, expectedStatus: http.StatusConflict, expectedCode: CodeConflict},
+		{err: mongo.ErrClientDisconnected, expectedStatus: http.StatusServiceUnavailable, expectedCode: CodeUnavailable},
+		{err: context.DeadlineExceeded, expectedStatus: http.StatusServiceUnavailable, expectedCode: CodeUnavailable},
+		{err: mongo.CommandError{Code: 50, Name: "MaxTimeMSExpired"}, expectedStatus: http.StatusServiceUnavailable, expectedCode: CodeQueryTimeout},
+		//test an unknown error
+		{err: stderrors.New("unknown"), expectedStatus: http.StatusInternalServerError, expectedCode: CodeInternal},
+	}
+
+	for _, item := range classifyTests {
+		result := Classify(item.err)
+		if result.Status != item.expectedStatus || result.Code != item.expectedCode {
+			t.Errorf("Classify for %v output %v %v but expected %v %v", item.err, result.Status, result.Code, item.expectedStatus, item.expectedCode)
+		}
+	}
+	//the cause of an unknown error is not returned
+	if result := Classify(stderrors.New("bcrypt: internal detail")); result.Err != ErrInternal {
+		t.Errorf("Classify for an unknown error output the message %v", result.Err)
+	}
+}
+
+type respondTest struct {
+	format         string
+	accept         string
+	err            error
+	expectedStatus int
+	expectedType   string
+	expectedBody   string
+}
+
+func TestRespond(t *testing.T) {
+	respondTests := []respondTest{
+		{
+			format:         FormatProblem,
+			err:            Validation(FieldErrors{"email": "must be a valid email address", "country": "value required"}),
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedType:   ContentTypeProblem,
+			expectedBody:   `{"type":"/problems/validation","title":"Unprocessable Entity","status":422,"detail":"country: value required; email: must be a valid email address","instance":"/users?page=1","code":1001,"errors":{"country":"value required","email":"must be a valid email address"}}`,
+		},
+		{
+			format:         FormatProblem,
+			err:            mongo.ErrNoDocuments,
+			expectedStatus: http.StatusNotFound,
+			expectedType:   ContentTypeProblem,
+			expectedBody:   `{"type":"/problems/not-found","title":"Not Found","status":404,"detail":"mongo: no documents in result","instance":"/users?page=1","code":1400}`,
+		},
+		{
+			format:         FormatLegacy,
+			err:            mongo.ErrNoDocuments,
+			expectedStatus: http.StatusNotFound,
+			expectedType:   "application/json",
+			expectedBody:   `{"status":"Not Found","code":1400,"error":"mongo: no documents in result"}`,
+		},
+		//the format accepted by the request wins over the default one
+		{
+			format:         FormatLegacy,
+			accept:         "application/json, application/problem+json;q=0.9",
+			err:            mongo.ErrNoDocuments,
+			expectedStatus: http.StatusNotFound,
+			expectedType:   ContentTypeProblem,
+			expectedBody:   `{"type":"/problems/not-found","title":"Not Found","status":404,"detail":"mongo: no documents in result","instance":"/users?page=1","code":1400}`,
+		},
+		{
+			format:         FormatProblem,
+			accept:         "application/json, application/problem+json;q=0",
+			err:            mongo.ErrNoDocuments,
+			expectedStatus: http.StatusNotFound,
+			expectedType:   "application/json",
+			expectedBody:   `{"status":"Not Found","code":1400,"error":"mongo: no documents in result"}`,
+		},
+		{
+			format:         FormatLegacy,
+			accept:         "text/html, */*",
+			err:            mongo.ErrNoDocuments,
+			expectedStatus: http.StatusNotFound,
+			expectedType:   "application/json",
+			expectedBody:   `{"status":"Not Found","code":1400,"error":"mongo: no documents in result"}`,
+		},
+	}
+
+	for _, item := range respondTests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/users?page=1", nil)
+		r.Header.Set("Accept", item.accept)
+		FormatHandler(item.format)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Respond(w, r, item.err)
+		})).ServeHTTP(w, r)
+		if w.Code != item.expectedStatus || !strings.HasPrefix(w.Header().Get("Content-Type"), item.expectedType) || strings.TrimSpace(w.Body.String()) != item.expectedBody {
+			t.Errorf("Respond in %v accepting %q for %v output %v %v %v but expected %v %v %v", item.format, item.accept, item.err, w.Code, w.Header().Get("Content-Type"), w.Body.String(), item.expectedStatus, item.expectedType, item.expectedBody)
+		}
+	}
+}
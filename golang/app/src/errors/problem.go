@@ -0,0 +1,135 @@
+package errors
+
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"github.com/go-chi/render"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+//Implements the RFC 7807 problem details responses
+
+const (
+	ContentTypeProblem = "application/problem+json"
+
+	//FormatProblem renders the errors as RFC 7807 problem details, the default
+	FormatProblem = "problem"
+	//FormatLegacy renders the errors as ErrResponse
+	FormatLegacy = "legacy"
+
+	problemTypeBase = "/problems/"
+)
+
+//problem type of each application code
+var problemTypes = map[int64]string{
+	CodeUnprocessable:        "unprocessable",
+	CodeValidation:           "validation",
+	CodeBadRequest:           "bad-request",
+	CodeInvalidID:            "invalid-id",
+	CodeUnauthorized:         "unauthorized",
+	CodeForbidden:            "forbidden",
+	CodeNotFound:             "not-found",
+	CodeConflict:             "conflict",
+	CodeUnsupportedMediaType: "unsupported-media-type",
+	CodeUnavailable:          "unavailable",
+	CodeQueryTimeout:         "query-timeout",
+	CodePreconditionFailed:   "precondition-failed",
+	CodeInternal:             "internal",
+}
+
+// Problem is a RFC 7807 problem details, extended with the application code and the field errors.
+type Problem struct {
+	Type     string      `json:"type"`
+	Title    string      `json:"title"`
+	Status   int         `json:"status"`
+	Detail   string      `json:"detail,omitempty"`
+	Instance string      `json:"instance,omitempty"`
+	Code     int64       `json:"code"`
+	Errors   FieldErrors `json:"errors,omitempty"` //by json field name
+}
+
+// NewProblem returns the Problem classifying err, which happened on the instance URI.
+func NewProblem(err error, instance string) *Problem {
+	appErr := Classify(err)
+	problem := &Problem{
+		Type:     problemTypeBase + problemTypes[appErr.Code],
+		Title:    http.StatusText(appErr.Status),
+		Status:   appErr.Status,
+		Detail:   appErr.Err.Error(),
+		Instance: instance,
+		Code:     appErr.Code,
+	}
+	var fieldErrors FieldErrors
+	if stderrors.As(appErr.Err, &fieldErrors) {
+		problem.Errors = fieldErrors
+	}
+	return problem
+}
+
+type contextKey struct {
+	name string
+}
+
+var formatCtxKey = &contextKey{"ErrorFormat"}
+
+// FormatHandler sets the format of the errors rendered by Respond for each request from its Accept header:
+// FormatProblem when it accepts application/problem+json, FormatLegacy when it accepts application/json but not
+// application/problem+json, and the default format, FormatProblem unless FormatLegacy, for any other or none.
+func FormatHandler(defaultFormat string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), formatCtxKey, negotiateFormat(r.Header.Get("Accept"), defaultFormat))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+//negotiateFormat returns the error format of the media types accepted, the default one when none is named.
+//The media types refused with q=0 are ignored
+func negotiateFormat(accept string, defaultFormat string) string {
+	jsonAccepted := false
+	for _, mediaRange := range strings.Split(accept, ",") {
+		params := strings.Split(mediaRange, ";")
+		if refused(params[1:]) {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(params[0])) {
+		case ContentTypeProblem:
+			return FormatProblem
+		case "application/json":
+			jsonAccepted = true
+		}
+	}
+	if jsonAccepted {
+		return FormatLegacy
+	}
+	return defaultFormat
+}
+
+//refused reports if the parameters of a media range have a quality of 0
+func refused(params []string) bool {
+	for _, param := range params {
+		param = strings.TrimSpace(param)
+		if strings.HasPrefix(param, "q=") {
+			quality, err := strconv.ParseFloat(param[2:], 64)
+			return err == nil && quality == 0
+		}
+	}
+	return false
+}
+
+// Respond writes err as a problem details, or as an ErrResponse with FormatLegacy.
+func Respond(w http.ResponseWriter, r *http.Request, err error) {
+	if format, _ := r.Context().Value(formatCtxKey).(string); format == FormatLegacy {
+		_ = render.Render(w, r, ErrRender(err))
+		return
+	}
+
+	problem := NewProblem(err, r.URL.RequestURI())
+	w.Header().Set("Content-Type", ContentTypeProblem)
+	w.WriteHeader(problem.Status)
+	_ = json.NewEncoder(w).Encode(problem)
+}
@@ -5,10 +5,15 @@ import (
 	"fmt"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"io/ioutil"
 	"log"
 	"os"
+	"strconv"
 	"test/api"
+	"test/auth"
+	"test/user"
 	"test/utils"
+	"time"
 )
 
 func main() {
@@ -30,11 +35,224 @@ func main() {
 		Ctx:      ctx,
 	}
 
+	//passwords are hashed with PASSWORD_HASH_ALGORITHM (bcrypt or argon2id) and PASSWORD_HASH_COST
+	hashCost := 0
+	if os.Getenv("PASSWORD_HASH_COST") != "" {
+		hashCost, err = strconv.Atoi(os.Getenv("PASSWORD_HASH_COST"))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	hasher, err := user.NewPasswordHasher(os.Getenv("PASSWORD_HASH_ALGORITHM"), hashCost)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	usersStore, err := user.NewUsersStore(dbConnection.Database, dbConnection.Ctx, hasher)
+	if err != nil {
+		log.Fatal(err)
+	}
+	//the fuzzy lookup returns the FUZZY_LIMIT Users most similar, at least FUZZY_THRESHOLD (between 0 and 1)
+	if os.Getenv("FUZZY_THRESHOLD") != "" {
+		usersStore.Fuzzy.Threshold, err = strconv.ParseFloat(os.Getenv("FUZZY_THRESHOLD"), 64)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if os.Getenv("FUZZY_LIMIT") != "" {
+		usersStore.Fuzzy.Limit, err = strconv.Atoi(os.Getenv("FUZZY_LIMIT"))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if os.Getenv("FUZZY_CANDIDATES") != "" {
+		usersStore.Fuzzy.Candidates, err = strconv.Atoi(os.Getenv("FUZZY_CANDIDATES"))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	//the Users saved URL-escaped by the previous versions are unescaped, once
+	migrated, err := user.MigrateUnescape(dbConnection.Ctx, dbConnection.Database)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if migrated > 0 {
+		log.Println(migrated, "users unescaped")
+	}
+	//the passwords saved in plain text by the first versions are hashed
+	migrated, err = user.MigrateHashPasswords(dbConnection.Ctx, dbConnection.Database, hasher)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if migrated > 0 {
+		log.Println(migrated, "passwords hashed")
+	}
+	//the Users saved by the previous versions get the trigrams selecting the candidates of a fuzzy lookup
+	migrated, err = user.MigrateFuzzyGrams(dbConnection.Ctx, dbConnection.Database)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if migrated > 0 {
+		log.Println(migrated, "users indexed for the fuzzy lookup")
+	}
+
+	if os.Getenv("ADMIN_PASSWORD") != "" {
+		seedAdmin(usersStore)
+	}
+
+	//the deleted Users are purged after PURGE_RETENTION, checked every PURGE_INTERVAL (ex: 720h, 1h)
+	purger := user.NewPurger(usersStore)
+	if os.Getenv("PURGE_RETENTION") != "" {
+		purger.Retention, err = time.ParseDuration(os.Getenv("PURGE_RETENTION"))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if os.Getenv("PURGE_INTERVAL") != "" {
+		purger.Interval, err = time.ParseDuration(os.Getenv("PURGE_INTERVAL"))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	go purger.Run(ctx)
+
+	tokens, err := newTokenManager()
+	if err != nil {
+		log.Fatal(err)
+	}
+	revocations, err := auth.NewRevocationMongoStore(dbConnection.Database, dbConnection.Ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//the events of the changes are relayed from the outbox, written to the standard output with EVENTS_LOG=true
+	var publisher user.Publisher = user.NewInProcessPublisher()
+	if os.Getenv("EVENTS_LOG") != "" {
+		logEvents, err := strconv.ParseBool(os.Getenv("EVENTS_LOG"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if logEvents {
+			publisher = user.NewLogPublisher(os.Stdout)
+		}
+	}
+	//the events are POSTed to the webhooks subscribed, a delivery is retried WEBHOOK_MAX_ATTEMPTS times
+	//and a request times out after WEBHOOK_TIMEOUT (ex: 10s), WEBHOOK_WORKERS subscriptions are delivered at once
+	webhookStore, err := user.NewWebhookMongoStore(dbConnection.Database, dbConnection.Ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dispatcher := user.NewWebhookDispatcher(webhookStore)
+	if os.Getenv("WEBHOOK_MAX_ATTEMPTS") != "" {
+		dispatcher.MaxAttempts, err = strconv.Atoi(os.Getenv("WEBHOOK_MAX_ATTEMPTS"))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if os.Getenv("WEBHOOK_TIMEOUT") != "" {
+		dispatcher.Client.Timeout, err = time.ParseDuration(os.Getenv("WEBHOOK_TIMEOUT"))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if os.Getenv("WEBHOOK_WORKERS") != "" {
+		dispatcher.Workers, err = strconv.Atoi(os.Getenv("WEBHOOK_WORKERS"))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	go dispatcher.Run(ctx)
+
+	//the events are streamed at /users/events, the last EVENTS_REPLAY of them are kept to resume a stream
+	replay := user.DefaultEventReplay
+	if os.Getenv("EVENTS_REPLAY") != "" {
+		replay, err = strconv.Atoi(os.Getenv("EVENTS_REPLAY"))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	events := user.NewEventStream(replay)
+
+	//an event is retried RELAY_MAX_ATTEMPTS times before it is dead, the outbox is checked every RELAY_INTERVAL (ex: 1s)
+	relay := user.NewRelay(usersStore.Outbox(), user.MultiPublisher{publisher, dispatcher, events})
+	if os.Getenv("RELAY_INTERVAL") != "" {
+		relay.Interval, err = time.ParseDuration(os.Getenv("RELAY_INTERVAL"))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if os.Getenv("RELAY_MAX_ATTEMPTS") != "" {
+		relay.MaxAttempts, err = strconv.Atoi(os.Getenv("RELAY_MAX_ATTEMPTS"))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	go relay.Run(ctx)
+
 	//init the server
-	server, err := api.NewServer(dbConnection, false)
+	server, err := api.NewServer(api.Config{
+		UsersStore:   usersStore,
+		WebhookStore: webhookStore,
+		Events:       events,
+		Tokens:       tokens,
+		Revocations:  revocations,
+		ErrorFormat:  os.Getenv("ERROR_FORMAT"),
+	}, false)
 	if err != nil {
 		log.Fatal(err)
 	}
 	//start the server
 	server.Start()
 }
+
+//newTokenManager configures the tokens with JWT_ALGORITHM (HS256 or RS256), JWT_SECRET for HS256,
+//JWT_PRIVATE_KEY_FILE (PEM) for RS256, and the lifetimes JWT_ACCESS_TTL and JWT_REFRESH_TTL (ex: 15m, 720h)
+func newTokenManager() (*auth.TokenManager, error) {
+	config := auth.TokenConfig{
+		Algorithm: os.Getenv("JWT_ALGORITHM"),
+		Secret:    []byte(os.Getenv("JWT_SECRET")),
+	}
+	var err error
+	if os.Getenv("JWT_PRIVATE_KEY_FILE") != "" {
+		pemKey, err := ioutil.ReadFile(os.Getenv("JWT_PRIVATE_KEY_FILE"))
+		if err != nil {
+			return nil, err
+		}
+		config.PrivateKey, err = auth.ParseRSAPrivateKey(pemKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if os.Getenv("JWT_ACCESS_TTL") != "" {
+		config.AccessTTL, err = time.ParseDuration(os.Getenv("JWT_ACCESS_TTL"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if os.Getenv("JWT_REFRESH_TTL") != "" {
+		config.RefreshTTL, err = time.ParseDuration(os.Getenv("JWT_REFRESH_TTL"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return auth.NewTokenManager(config)
+}
+
+//seedAdmin creates the first User from ADMIN_NICKNAME, ADMIN_EMAIL and ADMIN_PASSWORD, so the API can be logged in
+func seedAdmin(usersStore user.UserRepository) {
+	admin := user.User{
+		FirstName: "Admin",
+		LastName:  "Admin",
+		Nickname:  os.Getenv("ADMIN_NICKNAME"),
+		Password:  os.Getenv("ADMIN_PASSWORD"),
+		Email:     os.Getenv("ADMIN_EMAIL"),
+		Country:   "-",
+		Role:      user.RoleAdmin,
+	}
+	admin.Normalize()
+	if err := usersStore.Create("", &admin); err != nil {
+		//most likely already created by a previous start
+		log.Println("admin not created:", err)
+	}
+}
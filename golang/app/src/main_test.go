@@ -1,69 +1,114 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"test/api"
-	"test/utils"
+	"test/auth"
+	"test/errors"
+	"test/user"
 	"testing"
+	"time"
 )
 
-var dbConnection utils.DbConnection
-var server *api.Server
+var server *httptest.Server
+var usersStore user.UserRepository
+var webhookStore user.WebhookStore
+var eventStream *user.EventStream
 
 func TestMain(m *testing.M) {
 	fmt.Println("Starting API for TEST")
-	//connect to the database
-	client, err := mongo.NewClient(options.Client().ApplyURI(os.Getenv("MONGODB_URI")))
+	hasher, err := user.NewPasswordHasher(user.HashBcrypt, 4)
 	if err != nil {
 		log.Fatal(err)
 	}
-	ctx := context.TODO()
-	err = client.Connect(ctx)
+	usersStore = user.NewUsersMemoryStore(hasher)
+	var revocations auth.RevocationStore = auth.NewRevocationMemoryStore()
+	webhookStore = user.NewWebhookMemoryStore()
+	//short heartbeats, to be tested
+	eventStream = user.NewEventStream(user.DefaultEventReplay)
+	eventStream.Heartbeat = 100 * time.Millisecond
 
-	defer client.Disconnect(ctx)
-	testDb := client.Database("testDb")
+	//use the test db when there is one
+	var testDb *mongo.Database
+	if os.Getenv("MONGODB_URI") != "" {
+		//connect to the database
+		client, err := mongo.NewClient(options.Client().ApplyURI(os.Getenv("MONGODB_URI")))
+		if err != nil {
+			log.Fatal(err)
+		}
+		ctx := context.TODO()
+		err = client.Connect(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
 
-	dbConnection = utils.DbConnection{
-		Client:   client,
-		Database: testDb,
-		Ctx:      ctx,
+		defer client.Disconnect(ctx)
+		testDb = client.Database("testDb")
+
+		usersStore, err = user.NewUsersStore(testDb, ctx, hasher)
+		if err != nil {
+			log.Fatal(err)
+		}
+		revocations, err = auth.NewRevocationMongoStore(testDb, ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		webhookStore, err = user.NewWebhookMongoStore(testDb, ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	tokens, err := auth.NewTokenManager(auth.TokenConfig{Secret: []byte("test secret")})
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	//init the server
-	server, err = api.NewServer(dbConnection, true)
+	//init and start the server
+	app, err := api.NewApp(api.Config{
+		UsersStore:   usersStore,
+		WebhookStore: webhookStore,
+		Events:       eventStream,
+		Tokens:       tokens,
+		Revocations:  revocations,
+	})
 	if err != nil {
 		log.Fatal(err)
 	}
-	//start the server
-	server.Start()
+	server = httptest.NewServer(app)
 
 	//run the tests
 	exitVal := m.Run()
 
 	//DROP the db and stop the server to clean
-	err = testDb.Drop(ctx)
-	if err != nil {
-		log.Fatal(err)
-	}
-	err = server.Close()
-	if err != nil {
-		log.Fatal(err)
+	server.Close()
+	if testDb != nil {
+		err = testDb.Drop(context.TODO())
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
 	os.Exit(exitVal)
 }
 
 func TestPingTrueDb(t *testing.T) {
-	resp, err := http.Get("http://localhost:8080/ping")
+	resp, err := http.Get(server.URL + "/ping")
 	if err != nil {
-		t.Errorf("Ping test for main db get an err %v", err.Error())
+		t.Fatalf("Ping test for main db get an err %v", err.Error())
 	}
 	if resp.StatusCode != 200 {
 		t.Errorf("Ping test for main db get a status code %v", resp.StatusCode)
@@ -77,3 +122,1466 @@ func TestPingTrueDb(t *testing.T) {
 	}
 }
 
+type tokens struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func TestAuth(t *testing.T) {
+	u := user.User{
+		FirstName: "FirstName",
+		LastName:  "LastName",
+		Nickname:  "AuthNickname",
+		Password:  "Password",
+		Email:     "AuthEmail@email.com",
+		Country:   "Country",
+	}
+	if err := usersStore.Create("", &u); err != nil {
+		t.Fatalf("Create user failled for auth test with err %v", err)
+	}
+	defer deleteForGood(u.ID)
+
+	//the users can not be reached without token
+	resp := doRequest(t, "GET", "/users", "", "")
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Auth test without token get a status code %v", resp.StatusCode)
+	}
+
+	//wrong password
+	resp = doRequest(t, "POST", "/auth/login", "", `{"login":"AuthNickname","password":"password"}`)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Auth test login with wrong password get a status code %v", resp.StatusCode)
+	}
+
+	//login by nickname then by email
+	doLogin(t, "AuthNickname")
+	loginTokens := doLogin(t, "AuthEmail@email.com")
+
+	resp = doRequest(t, "GET", "/users", loginTokens.AccessToken, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Auth test with access token get a status code %v", resp.StatusCode)
+	}
+	//a refresh token is not an access token
+	resp = doRequest(t, "GET", "/users", loginTokens.RefreshToken, "")
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Auth test with refresh token as access token get a status code %v", resp.StatusCode)
+	}
+
+	//refresh, the refresh token can be used only once
+	resp = doRequest(t, "POST", "/auth/refresh", "", `{"refresh_token":"`+loginTokens.RefreshToken+`"}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Auth test refresh get a status code %v", resp.StatusCode)
+	}
+	var refreshedTokens tokens
+	if err := json.NewDecoder(resp.Body).Decode(&refreshedTokens); err != nil {
+		t.Fatalf("Auth test refresh get an err %v trying to parse the body", err.Error())
+	}
+	resp = doRequest(t, "POST", "/auth/refresh", "", `{"refresh_token":"`+loginTokens.RefreshToken+`"}`)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Auth test second refresh get a status code %v", resp.StatusCode)
+	}
+
+	//concurrent refreshes with the same token, only one gets new tokens
+	concurrentTokens := doLogin(t, "AuthNickname")
+	statuses := make(chan int, 10)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(statuses); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp := doRequest(t, "POST", "/auth/refresh", "", `{"refresh_token":"`+concurrentTokens.RefreshToken+`"}`)
+			statuses <- resp.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+	refreshed := 0
+	for status := range statuses {
+		if status == http.StatusOK {
+			refreshed++
+		} else if status != http.StatusUnauthorized {
+			t.Errorf("Auth test concurrent refresh get a status code %v", status)
+		}
+	}
+	if refreshed != 1 {
+		t.Errorf("Auth test concurrent refresh got new tokens %v times", refreshed)
+	}
+
+	//logout revokes both tokens
+	resp = doRequest(t, "POST", "/auth/logout", refreshedTokens.AccessToken, `{"refresh_token":"`+refreshedTokens.RefreshToken+`"}`)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Auth test logout get a status code %v", resp.StatusCode)
+	}
+	resp = doRequest(t, "GET", "/users", refreshedTokens.AccessToken, "")
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Auth test after logout get a status code %v", resp.StatusCode)
+	}
+	resp = doRequest(t, "POST", "/auth/refresh", "", `{"refresh_token":"`+refreshedTokens.RefreshToken+`"}`)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Auth test refresh after logout get a status code %v", resp.StatusCode)
+	}
+}
+
+//deleteForGood deletes then purges the User, so its nickname and email can be used again
+func deleteForGood(id string) error {
+	if err := usersStore.Delete("", id, user.AnyVersion); err != nil {
+		return err
+	}
+	_, err := usersStore.Purge(time.Now())
+	return err
+}
+
+func doLogin(t *testing.T, login string) tokens {
+	resp := doRequest(t, "POST", "/auth/login", "", `{"login":"`+login+`","password":"Password"}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Login of %v get a status code %v", login, resp.StatusCode)
+	}
+	var loginTokens tokens
+	if err := json.NewDecoder(resp.Body).Decode(&loginTokens); err != nil {
+		t.Fatalf("Login of %v get an err %v trying to parse the body", login, err.Error())
+	}
+	return loginTokens
+}
+
+func doRequest(t *testing.T, method, path, token, body string) *http.Response {
+	return doRequestWithHeader(t, method, path, token, body, nil)
+}
+
+//doRequestWithHeader sends the request with the header added to the default ones
+func doRequestWithHeader(t *testing.T, method, path, token, body string, header http.Header) *http.Response {
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("%v %v get an err %v", method, path, err.Error())
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%v %v get an err %v", method, path, err.Error())
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+type usersList struct {
+	Users      []user.User `json:"users"`
+	Count      int         `json:"count"`
+	Total      int         `json:"total"`
+	Page       int64       `json:"page"`
+	PageSize   int64       `json:"page_size"`
+	HasNext    bool        `json:"has_next"`
+	NextCursor string      `json:"next_cursor"`
+	Links      struct {
+		Next string `json:"next"`
+		Prev string `json:"prev"`
+	} `json:"links"`
+}
+
+func TestRoles(t *testing.T) {
+	users := map[string]*user.User{}
+	for _, role := range []string{user.RoleAdmin, user.RoleSupport, user.RoleSelf} {
+		u := &user.User{
+			FirstName: "FirstName",
+			LastName:  "LastName",
+			Nickname:  role + "Nickname",
+			Password:  "Password",
+			Email:     role + "Email@email.com",
+			Country:   "Country",
+			Role:      role,
+		}
+		if err := usersStore.Create("", u); err != nil {
+			t.Fatalf("Create user failled for roles test with err %v", err)
+		}
+		defer deleteForGood(u.ID)
+		users[role] = u
+	}
+	adminToken := doLogin(t, "adminNickname").AccessToken
+	supportToken := doLogin(t, "supportNickname").AccessToken
+	selfToken := doLogin(t, "selfNickname").AccessToken
+	newUser := `{"first_name":"FirstName","last_name":"LastName","password":"Password","nickname":"RoleNickname","email":"RoleEmail@email.com","country":"Country"}`
+
+	//self only reads and updates its own User, without changing its role
+	list := doList(t, "/users", selfToken)
+	if list.Count != 1 || list.Users[0].ID != users[user.RoleSelf].ID {
+		t.Errorf("Roles test self list get %v", list)
+	}
+	resp := doRequest(t, "GET", "/users?id="+users[user.RoleAdmin].ID, selfToken, "")
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Roles test self list of another User get a status code %v", resp.StatusCode)
+	}
+	resp = doRequest(t, "GET", "/users/"+users[user.RoleSelf].ID, selfToken, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Roles test self get get a status code %v", resp.StatusCode)
+	}
+	resp = doRequest(t, "GET", "/users/"+users[user.RoleAdmin].ID, selfToken, "")
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Roles test self get of another User get a status code %v", resp.StatusCode)
+	}
+	resp = doRequest(t, "POST", "/users", selfToken, newUser)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Roles test self create get a status code %v", resp.StatusCode)
+	}
+	resp = doRequest(t, "PUT", "/users/"+users[user.RoleAdmin].ID, selfToken, newUser)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Roles test self update of another User get a status code %v", resp.StatusCode)
+	}
+	resp = doRequest(t, "PUT", "/users/"+users[user.RoleSelf].ID, selfToken,
+		`{"first_name":"Updated","last_name":"LastName","password":"Password","nickname":"selfNickname","email":"selfEmail@email.com","country":"Country","role":"admin"}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Roles test self update get a status code %v", resp.StatusCode)
+	}
+	list = doList(t, "/users", selfToken)
+	if list.Count != 1 || list.Users[0].FirstName != "Updated" || list.Users[0].Role != user.RoleSelf {
+		t.Errorf("Roles test self list after update get %v", list)
+	}
+	resp = doRequest(t, "DELETE", "/users/"+users[user.RoleSelf].ID, selfToken, "")
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Roles test self delete get a status code %v", resp.StatusCode)
+	}
+
+	//support reads every User without their email
+	list = doList(t, "/users", supportToken)
+	if list.Count < 3 {
+		t.Errorf("Roles test support list get %v", list)
+	}
+	for _, u := range list.Users {
+		if u.Email != "" {
+			t.Errorf("Roles test support list get the email of %v", u)
+		}
+	}
+	//nor finds them back through the filters, the sort or the search
+	for _, path := range []string{"/users?email[prefix]=a", "/users?email[regex]=^s", "/users?email=supportEmail",
+		"/users?text=Email", "/users?text[prefix]=support", "/users?sort=email", "/users?sort=-email", "/users?q=supportEmail"} {
+		resp = doRequest(t, "GET", path, supportToken, "")
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("Roles test support list %v get a status code %v", path, resp.StatusCode)
+		}
+	}
+	for _, path := range []string{"/users?nickname[prefix]=support", "/users?sort=nickname", "/users?text=support&mode=fuzzy"} {
+		resp = doRequest(t, "GET", path, supportToken, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Roles test support list %v get a status code %v", path, resp.StatusCode)
+		}
+	}
+	resp = doRequest(t, "DELETE", "/users/"+users[user.RoleSelf].ID, supportToken, "")
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Roles test support delete get a status code %v", resp.StatusCode)
+	}
+
+	//admin does everything
+	resp = doRequest(t, "POST", "/users", adminToken, newUser)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Roles test admin create get a status code %v", resp.StatusCode)
+	}
+	var created user.User
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("Roles test admin create get an err %v trying to parse the body", err.Error())
+	}
+	resp = doRequest(t, "DELETE", "/users/"+created.ID, adminToken, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Roles test admin delete get a status code %v", resp.StatusCode)
+	}
+}
+
+func doList(t *testing.T, path, token string) usersList {
+	resp := doRequest(t, "GET", path, token, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %v get a status code %v", path, resp.StatusCode)
+	}
+	var list usersList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatalf("GET %v get an err %v trying to parse the body", path, err.Error())
+	}
+	return list
+}
+
+func TestPatch(t *testing.T) {
+	u := &user.User{
+		FirstName: "FirstName",
+		LastName:  "LastName",
+		Nickname:  "PatchNickname",
+		Password:  "Password",
+		Email:     "PatchEmail@email.com",
+		Country:   "Country",
+	}
+	if err := usersStore.Create("", u); err != nil {
+		t.Fatalf("Create user failled for patch test with err %v", err)
+	}
+	defer deleteForGood(u.ID)
+	token := doLogin(t, "PatchNickname").AccessToken
+
+	//merge patch only modifies the sent fields
+	resp := doPatch(t, "/users/"+u.ID, token, user.ContentTypeMergePatch, `{"last_name":"Longbow"}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Patch test merge patch get a status code %v", resp.StatusCode)
+	}
+	var patched user.User
+	if err := json.NewDecoder(resp.Body).Decode(&patched); err != nil {
+		t.Fatalf("Patch test get an err %v trying to parse the body", err.Error())
+	}
+	if patched.LastName != "Longbow" || patched.FirstName != "FirstName" || patched.Email != u.Email {
+		t.Errorf("Patch test merge patch get %v", patched)
+	}
+
+	//json patch
+	resp = doPatch(t, "/users/"+u.ID, token, user.ContentTypeJSONPatch, `[{"op":"replace","path":"/country","value":"UK"}]`)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Patch test json patch get a status code %v", resp.StatusCode)
+	}
+
+	//wrong requests
+	resp = doPatch(t, "/users/"+u.ID, token, "text/plain", `{"last_name":"Longbow"}`)
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("Patch test unknown content type get a status code %v", resp.StatusCode)
+	}
+	resp = doPatch(t, "/users/"+u.ID, token, user.ContentTypeMergePatch, `{"email":null}`)
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("Patch test removed field get a status code %v", resp.StatusCode)
+	}
+	resp = doPatch(t, "/users/"+u.ID, token, user.ContentTypeMergePatch, `{"role":"admin"}`)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Patch test role by self get a status code %v", resp.StatusCode)
+	}
+}
+
+func doPatch(t *testing.T, path, token, contentType, body string) *http.Response {
+	req, err := http.NewRequest("PATCH", server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("PATCH %v get an err %v", path, err.Error())
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("PATCH %v get an err %v", path, err.Error())
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestGet(t *testing.T) {
+	u := &user.User{
+		FirstName: "FirstName",
+		LastName:  "LastName",
+		Nickname:  "GetNickname",
+		Password:  "Password",
+		Email:     "GetEmail@email.com",
+		Country:   "Country",
+		Role:      user.RoleAdmin,
+	}
+	if err := usersStore.Create("", u); err != nil {
+		t.Fatalf("Create user failled for get test with err %v", err)
+	}
+	defer deleteForGood(u.ID)
+	token := doLogin(t, "GetNickname").AccessToken
+
+	resp := doRequest(t, "GET", "/users/"+u.ID, token, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Get test get a status code %v", resp.StatusCode)
+	}
+	var got user.User
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("Get test get an err %v trying to parse the body", err.Error())
+	}
+	if got.ID != u.ID || !got.IsSoftEqual(u) {
+		t.Errorf("Get test get %v but expected %v", got, u)
+	}
+
+	//missing and malformed ids
+	resp = doRequest(t, "GET", "/users/61e41ed578752c5997718a00", token, "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Get test missing id get a status code %v", resp.StatusCode)
+	}
+	resp = doRequest(t, "GET", "/users/61e41ed5787", token, "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Get test malformed id get a status code %v", resp.StatusCode)
+	}
+}
+
+type errorCodeTest struct {
+	method, path, body string
+	expectedStatus     int
+	expectedCode       int64
+	expectedFields     []string
+}
+
+func TestErrorCodes(t *testing.T) {
+	u := &user.User{
+		FirstName: "FirstName",
+		LastName:  "LastName",
+		Nickname:  "ErrorsNickname",
+		Password:  "Password",
+		Email:     "ErrorsEmail@email.com",
+		Country:   "Country",
+		Role:      user.RoleAdmin,
+	}
+	if err := usersStore.Create("", u); err != nil {
+		t.Fatalf("Create user failled for error codes test with err %v", err)
+	}
+	defer deleteForGood(u.ID)
+	token := doLogin(t, "ErrorsNickname").AccessToken
+
+	errorCodeTests := []errorCodeTest{
+		{method: "GET", path: "/users/61e41ed578752c5997718a00", expectedStatus: http.StatusNotFound, expectedCode: errors.CodeNotFound},
+		{method: "DELETE", path: "/users/61e41ed578752c5997718a00", expectedStatus: http.StatusNotFound, expectedCode: errors.CodeNotFound},
+		{method: "GET", path: "/users/61e41ed5787", expectedStatus: http.StatusBadRequest, expectedCode: errors.CodeInvalidID},
+		{method: "GET", path: "/users/zzzzzzzzzzzzzzzzzzzzzzzz", expectedStatus: http.StatusBadRequest, expectedCode: errors.CodeInvalidID},
+		{method: "GET", path: "/users?id=zzzzzzzzzzzzzzzzzzzzzzzz", expectedStatus: http.StatusBadRequest, expectedCode: errors.CodeInvalidID},
+		{method: "GET", path: "/audit?user_id=zzzzzzzzzzzzzzzzzzzzzzzz", expectedStatus: http.StatusBadRequest, expectedCode: errors.CodeInvalidID},
+		{method: "GET", path: "/users?page=one", expectedStatus: http.StatusBadRequest, expectedCode: errors.CodeBadRequest},
+		{method: "POST", path: "/users", body: `{"first_name":`, expectedStatus: http.StatusBadRequest, expectedCode: errors.CodeBadRequest},
+		{
+			method:         "POST",
+			path:           "/users",
+			body:           `{"first_name":"FirstName","last_name":"LastName","password":"Password","nickname":"ErrorsNickname","email":"Errors2Email@email.com","country":"Country"}`,
+			expectedStatus: http.StatusConflict,
+			expectedCode:   errors.CodeConflict,
+			expectedFields: []string{"nickname"},
+		},
+		{
+			method:         "POST",
+			path:           "/users",
+			body:           `{"first_name":"FirstName","last_name":"LastName","password":"Password","nickname":"Errors2Nickname","email":"Errors2Emailemailcom","country":"Country"}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   errors.CodeValidation,
+		},
+		{
+			method:         "POST",
+			path:           "/users",
+			body:           `{"last_name":"LastName","password":"Password","nickname":"Errors2Nickname","email":"Errors2Emailemailcom"}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   errors.CodeValidation,
+			expectedFields: []string{"first_name", "email", "country"},
+		},
+	}
+
+	for _, item := range errorCodeTests {
+		resp := doRequest(t, item.method, item.path, token, item.body)
+		if resp.Header.Get("Content-Type") != errors.ContentTypeProblem {
+			t.Errorf("%v %v get a Content-Type %v", item.method, item.path, resp.Header.Get("Content-Type"))
+		}
+		var result errors.Problem
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatalf("%v %v get an err %v trying to parse the body", item.method, item.path, err.Error())
+		}
+		if resp.StatusCode != item.expectedStatus || result.Status != item.expectedStatus || result.Code != item.expectedCode {
+			t.Errorf("%v %v get %v %v but expected %v %v", item.method, item.path, resp.StatusCode, result, item.expectedStatus, item.expectedCode)
+		}
+		if result.Instance != item.path || result.Type == "" || result.Title != http.StatusText(item.expectedStatus) {
+			t.Errorf("%v %v get the problem %v", item.method, item.path, result)
+		}
+		for _, field := range item.expectedFields {
+			if result.Errors[field] == "" {
+				t.Errorf("%v %v get the field errors %v without %v", item.method, item.path, result.Errors, field)
+			}
+		}
+	}
+}
+
+type legacyErrorResponse struct {
+	Status string `json:"status"`
+	Code   int64  `json:"code"`
+	Error  string `json:"error"`
+}
+
+func TestLegacyErrorFormat(t *testing.T) {
+	tokens, err := auth.NewTokenManager(auth.TokenConfig{Secret: []byte("test secret")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, err := api.NewApp(api.Config{
+		UsersStore:  usersStore,
+		Tokens:      tokens,
+		Revocations: auth.NewRevocationMemoryStore(),
+		ErrorFormat: errors.FormatLegacy,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyServer := httptest.NewServer(app)
+	defer legacyServer.Close()
+
+	resp, err := http.Get(legacyServer.URL + "/users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var result legacyErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("Legacy error get an err %v trying to parse the body", err.Error())
+	}
+	if resp.StatusCode != http.StatusUnauthorized || result.Status != http.StatusText(http.StatusUnauthorized) || result.Code != errors.CodeUnauthorized || result.Error == "" {
+		t.Errorf("Legacy error get %v %v", resp.StatusCode, result)
+	}
+
+	//a request accepting problem details gets them whatever the default format
+	req, _ := http.NewRequest("GET", legacyServer.URL+"/users", nil)
+	req.Header.Set("Accept", errors.ContentTypeProblem)
+	problemResp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer problemResp.Body.Close()
+	var problem errors.Problem
+	if err := json.NewDecoder(problemResp.Body).Decode(&problem); err != nil || problemResp.Header.Get("Content-Type") != errors.ContentTypeProblem || problem.Code != errors.CodeUnauthorized {
+		t.Errorf("Problem error on the legacy server get %v %v %v", problemResp.Header.Get("Content-Type"), problem, err)
+	}
+	//and a request accepting only application/json the legacy shape
+	req, _ = http.NewRequest("GET", server.URL+"/users", nil)
+	req.Header.Set("Accept", "application/json")
+	jsonResp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jsonResp.Body.Close()
+	result = legacyErrorResponse{}
+	if err := json.NewDecoder(jsonResp.Body).Decode(&result); err != nil || result.Code != errors.CodeUnauthorized || result.Error == "" {
+		t.Errorf("Legacy error on the default server get %v %v", result, err)
+	}
+
+	_, err = api.NewApp(api.Config{UsersStore: usersStore, Tokens: tokens, Revocations: auth.NewRevocationMemoryStore(), ErrorFormat: "xml"})
+	if err != api.ErrErrorFormat {
+		t.Errorf("api.NewApp with an unknown error format output err %v", err)
+	}
+}
+
+func TestPagination(t *testing.T) {
+	admin := &user.User{
+		FirstName: "FirstName",
+		LastName:  "LastName",
+		Nickname:  "PageNickname0",
+		Password:  "Password",
+		Email:     "PageEmail0@email.com",
+		Country:   "PageCountry",
+		Role:      user.RoleAdmin,
+	}
+	if err := usersStore.Create("", admin); err != nil {
+		t.Fatalf("Create user failled for pagination test with err %v", err)
+	}
+	defer deleteForGood(admin.ID)
+	for _, suffix := range []string{"1", "2"} {
+		u := &user.User{
+			FirstName: "FirstName",
+			LastName:  "LastName",
+			Nickname:  "PageNickname" + suffix,
+			Password:  "Password",
+			Email:     "PageEmail" + suffix + "@email.com",
+			Country:   "PageCountry",
+		}
+		if err := usersStore.Create("", u); err != nil {
+			t.Fatalf("Create user failled for pagination test with err %v", err)
+		}
+		defer deleteForGood(u.ID)
+	}
+	token := doLogin(t, "PageNickname0").AccessToken
+
+	list := doList(t, "/users?country=PageCountry&page=0&page_size=2", token)
+	if list.Count != 2 || list.Total != 3 || !list.HasNext || list.Links.Next != "/users?country=PageCountry&page=1&page_size=2" || list.Links.Prev != "" {
+		t.Errorf("Pagination test first page get %+v", list)
+	}
+	list = doList(t, list.Links.Next, token)
+	if list.Count != 1 || list.Total != 3 || list.HasNext || list.Page != 1 || list.PageSize != 2 || list.Links.Prev != "/users?country=PageCountry&page=0&page_size=2" {
+		t.Errorf("Pagination test second page get %+v", list)
+	}
+
+	//the cursor goes through the same Users
+	list = doList(t, "/users?country=PageCountry&page_size=2", token)
+	cursorList := doList(t, "/users?country=PageCountry&page_size=2&cursor="+list.NextCursor, token)
+	if list.NextCursor == "" || cursorList.Count != 1 || cursorList.Total != 3 || cursorList.HasNext || cursorList.NextCursor != "" || cursorList.Links.Prev != "" {
+		t.Errorf("Pagination test cursor page get %+v after %+v", cursorList, list)
+	}
+	resp := doRequest(t, "GET", "/users?page_size=2&cursor=foo", token, "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Pagination test invalid cursor get a status code %v", resp.StatusCode)
+	}
+
+	//an empty page is an empty array
+	resp = doRequest(t, "GET", "/users?country=PageCountry&page=5&page_size=2", token, "")
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Pagination test get an err %v trying to read the body", err.Error())
+	}
+	if !strings.Contains(string(body), `"users":[]`) || !strings.Contains(string(body), `"total":3`) {
+		t.Errorf("Pagination test empty page get %v", string(body))
+	}
+
+	//sorted by nickname, the cursor keeps the sort
+	list = doList(t, "/users?country=PageCountry&sort=-nickname&page_size=2", token)
+	cursorList = doList(t, list.Links.Next, token)
+	if list.Count != 2 || list.Users[0].Nickname != "PageNickname2" || list.Users[1].Nickname != "PageNickname1" || cursorList.Count != 1 || cursorList.Users[0].Nickname != "PageNickname0" {
+		t.Errorf("Pagination test sorted pages get %+v then %+v", list, cursorList)
+	}
+	resp = doRequest(t, "GET", "/users?page_size=2&sort=nickname&cursor="+list.NextCursor, token, "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Pagination test cursor of another sort get a status code %v", resp.StatusCode)
+	}
+	resp = doRequest(t, "GET", "/users?sort=password", token, "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Pagination test invalid sort get a status code %v", resp.StatusCode)
+	}
+
+	resp = doRequest(t, "GET", "/users?page=-1&page_size=2", token, "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Pagination test negative page get a status code %v", resp.StatusCode)
+	}
+}
+
+func TestFields(t *testing.T) {
+	u := &user.User{
+		FirstName: "FirstName",
+		LastName:  "LastName",
+		Nickname:  "FieldsNickname",
+		Password:  "Password",
+		Email:     "FieldsEmail@email.com",
+		Country:   "FieldsCountry",
+		Role:      user.RoleAdmin,
+	}
+	if err := usersStore.Create("", u); err != nil {
+		t.Fatalf("Create user failled for fields test with err %v", err)
+	}
+	defer deleteForGood(u.ID)
+	token := doLogin(t, "FieldsNickname").AccessToken
+
+	//only the selected fields are returned, by the list and by the get
+	for _, path := range []string{"/users?country=FieldsCountry&sort=last_name&fields=id,nickname", "/users/" + u.ID + "?fields=id,nickname"} {
+		resp := doRequest(t, "GET", path, token, "")
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("Fields test get an err %v trying to read the body", err.Error())
+		}
+		expected := `{"id":"` + u.ID + `","nickname":"FieldsNickname"}`
+		if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), expected) || strings.Contains(string(body), "last_name") {
+			t.Errorf("Fields test %v get %v %v but expected %v", path, resp.StatusCode, string(body), expected)
+		}
+	}
+
+	resp := doRequest(t, "GET", "/users?fields=password", token, "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Fields test unknown field get a status code %v", resp.StatusCode)
+	}
+	resp = doRequest(t, "GET", "/users/"+u.ID+"?fields=password", token, "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Fields test unknown field on get get a status code %v", resp.StatusCode)
+	}
+}
+
+func TestFilters(t *testing.T) {
+	for _, suffix := range []string{"0", "1", "2"} {
+		u := &user.User{
+			FirstName: "FirstName",
+			LastName:  "LastName",
+			Nickname:  "FilterNickname" + suffix,
+			Password:  "Password",
+			Email:     "FilterEmail" + suffix + "@email.com",
+			Country:   "FilterCountry" + suffix,
+			Role:      user.RoleAdmin,
+		}
+		if err := usersStore.Create("", u); err != nil {
+			t.Fatalf("Create user failled for filters test with err %v", err)
+		}
+		defer deleteForGood(u.ID)
+	}
+	token := doLogin(t, "FilterNickname0").AccessToken
+
+	//the filters are combined
+	list := doList(t, "/users?country[in]=FilterCountry0,FilterCountry2&nickname[not]=FilterNickname0", token)
+	if list.Total != 1 || list.Users[0].Nickname != "FilterNickname2" {
+		t.Errorf("Filters test in and not get %+v", list)
+	}
+	list = doList(t, "/users?nickname[prefix]=FilterNick&email[eq]=FilterEmail1@email.com", token)
+	if list.Total != 1 || list.Users[0].Nickname != "FilterNickname1" {
+		t.Errorf("Filters test prefix and eq get %+v", list)
+	}
+
+	//the values are literal unless the regex mode is selected, the options i ignores the case
+	list = doList(t, "/users?nickname=Filter.*", token)
+	if list.Total != 0 {
+		t.Errorf("Filters test literal value get %+v", list)
+	}
+	list = doList(t, "/users?nickname=^FilterNickname[12]$&mode=regex", token)
+	if list.Total != 2 {
+		t.Errorf("Filters test regex mode get %+v", list)
+	}
+	list = doList(t, "/users?nickname=filternickname1&mode=exact&options=i", token)
+	if list.Total != 1 || list.Users[0].Nickname != "FilterNickname1" {
+		t.Errorf("Filters test case insensitive get %+v", list)
+	}
+
+	for _, path := range []string{"/users?id[prefix]=61", "/users?password[eq]=Password", "/users?country[range]=FilterCountry0", "/users?nickname=(&mode=regex", "/users?nickname=a&mode=like", "/users?nickname=a&options=x"} {
+		resp := doRequest(t, "GET", path, token, "")
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Filters test %v get a status code %v", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	for _, names := range [][]string{{"Kilo", "Lima", "SearchNickname0"}, {"Mike", "Kilo", "kilo"}, {"November", "Oscar", "SearchNickname2"}} {
+		u := &user.User{
+			FirstName: names[0],
+			LastName:  names[1],
+			Nickname:  names[2],
+			Password:  "Password",
+			Email:     names[2] + "@search.com",
+			Country:   "SearchCountry",
+			Role:      user.RoleAdmin,
+		}
+		if err := usersStore.Create("", u); err != nil {
+			t.Fatalf("Create user failled for search test with err %v", err)
+		}
+		defer deleteForGood(u.ID)
+	}
+	token := doLogin(t, "SearchNickname0").AccessToken
+
+	//the nickname weights more than the names, the most relevant first
+	list := doList(t, "/users?country=SearchCountry&q=kilo&with_score=true", token)
+	if list.Total != 2 || list.Users[0].Nickname != "kilo" || list.Users[1].Nickname != "SearchNickname0" || list.Users[1].Score <= 0 {
+		t.Errorf("Search test get %+v", list)
+	}
+	list = doList(t, "/users?country=SearchCountry&q=kilo", token)
+	if list.Total != 2 || list.Users[0].Score != 0 {
+		t.Errorf("Search test without score get %+v", list)
+	}
+
+	for _, path := range []string{"/users?sort=-score", "/users?q=kilo&sort=score"} {
+		resp := doRequest(t, "GET", path, token, "")
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Search test %v get a status code %v", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestFuzzy(t *testing.T) {
+	for _, names := range [][]string{{"Papa", "Quebec", "FuzzyNickname0"}, {"Romeo", "Sierra", "FuzzyNickname1"}, {"Romea", "Sierra", "FuzzyNickname2"}} {
+		u := &user.User{
+			FirstName: names[0],
+			LastName:  names[1],
+			Nickname:  names[2],
+			Password:  "Password",
+			Email:     names[2] + "@fuzzy.com",
+			Country:   "FuzzyCountry",
+			Role:      user.RoleAdmin,
+		}
+		if err := usersStore.Create("", u); err != nil {
+			t.Fatalf("Create user failled for fuzzy test with err %v", err)
+		}
+		defer deleteForGood(u.ID)
+	}
+	token := doLogin(t, "FuzzyNickname0").AccessToken
+
+	//the typos are tolerated, the most similar first
+	list := doList(t, "/users?country=FuzzyCountry&text=romeo%20sierr&mode=fuzzy&with_score=true", token)
+	if list.Total != 2 || list.Users[0].FirstName != "Romeo" || list.Users[1].FirstName != "Romea" || list.Users[0].Score <= list.Users[1].Score {
+		t.Errorf("Fuzzy test get %+v", list)
+	}
+	list = doList(t, "/users?country=FuzzyCountry&text=romeo%20sierr", token)
+	if list.Total != 0 {
+		t.Errorf("Fuzzy test without the mode get %+v", list)
+	}
+
+	resp := doRequest(t, "GET", "/users?text=romeo&mode=fuzzy&q=romeo", token, "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Fuzzy test with a search get a status code %v", resp.StatusCode)
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	users := map[string]*user.User{}
+	for _, role := range []string{user.RoleAdmin, user.RoleSupport, user.RoleSelf} {
+		u := &user.User{
+			FirstName: "FirstName",
+			LastName:  "LastName",
+			Nickname:  "Deleted" + role,
+			Password:  "Password",
+			Email:     "Deleted" + role + "@email.com",
+			Country:   "DeletedCountry",
+			Role:      role,
+		}
+		if err := usersStore.Create("", u); err != nil {
+			t.Fatalf("Create user failled for soft delete test with err %v", err)
+		}
+		users[role] = u
+	}
+	defer deleteForGood(users[user.RoleAdmin].ID)
+	defer deleteForGood(users[user.RoleSupport].ID)
+	adminToken := doLogin(t, "Deletedadmin").AccessToken
+	supportToken := doLogin(t, "Deletedsupport").AccessToken
+	deletedID := users[user.RoleSelf].ID
+
+	resp := doRequest(t, "DELETE", "/users/"+deletedID, adminToken, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Soft delete test delete get a status code %v", resp.StatusCode)
+	}
+
+	//the deleted User is hidden, but an admin can list it
+	list := doList(t, "/users?country=DeletedCountry", adminToken)
+	if list.Total != 2 {
+		t.Errorf("Soft delete test list get %+v", list)
+	}
+	list = doList(t, "/users?country=DeletedCountry&include_deleted=true&fields=id,deleted_at", adminToken)
+	if list.Total != 3 {
+		t.Errorf("Soft delete test list including the deleted users get %+v", list)
+	}
+	for _, u := range list.Users {
+		if (u.ID == deletedID) != (u.DeletedAt != nil) {
+			t.Errorf("Soft delete test list including the deleted users get %+v", u)
+		}
+	}
+	for path, status := range map[string]int{
+		"/users/" + deletedID:                                http.StatusNotFound,
+		"/users?country=DeletedCountry&include_deleted=true": http.StatusForbidden,
+	} {
+		resp = doRequest(t, "GET", path, supportToken, "")
+		if resp.StatusCode != status {
+			t.Errorf("Soft delete test support %v get a status code %v", path, resp.StatusCode)
+		}
+	}
+	resp = doRequest(t, "POST", "/auth/login", "", `{"login":"Deletedself","password":"Password"}`)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Soft delete test login of the deleted user get a status code %v", resp.StatusCode)
+	}
+
+	//only an admin restores it, once
+	resp = doRequest(t, "POST", "/users/"+deletedID+"/restore", supportToken, "")
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Soft delete test support restore get a status code %v", resp.StatusCode)
+	}
+	resp = doRequest(t, "POST", "/users/"+deletedID+"/restore", adminToken, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Soft delete test restore get a status code %v", resp.StatusCode)
+	}
+	defer deleteForGood(deletedID)
+	resp = doRequest(t, "POST", "/users/"+deletedID+"/restore", adminToken, "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Soft delete test restore again get a status code %v", resp.StatusCode)
+	}
+	resp = doRequest(t, "GET", "/users/"+deletedID, supportToken, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Soft delete test get the restored user get a status code %v", resp.StatusCode)
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	admin := &user.User{
+		FirstName: "FirstName",
+		LastName:  "LastName",
+		Nickname:  "Concurrent",
+		Password:  "Password",
+		Email:     "Concurrent@email.com",
+		Country:   "Country",
+		Role:      user.RoleAdmin,
+	}
+	if err := usersStore.Create("", admin); err != nil {
+		t.Fatalf("Create user failled for concurrency test with err %v", err)
+	}
+	defer deleteForGood(admin.ID)
+	token := doLogin(t, "Concurrent").AccessToken
+	path := "/users/" + admin.ID
+	body := `{"first_name":"Updated","last_name":"LastName","nickname":"Concurrent","password":"Password","email":"Concurrent@email.com","country":"Country"}`
+
+	resp := doRequest(t, "GET", path, token, "")
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag != `"1"` {
+		t.Fatalf("Concurrency test get get a status code %v with the ETag %v", resp.StatusCode, etag)
+	}
+
+	//the first update made on the version wins, the second one is refused
+	resp = doRequestWithHeader(t, "PUT", path, token, body, http.Header{"If-Match": {etag}})
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") != `"2"` {
+		t.Errorf("Concurrency test update get a status code %v with the ETag %v", resp.StatusCode, resp.Header.Get("ETag"))
+	}
+	resp = doRequestWithHeader(t, "PUT", path, token, body, http.Header{"If-Match": {etag}})
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("Concurrency test stale update get a status code %v", resp.StatusCode)
+	}
+	resp = doRequestWithHeader(t, "DELETE", path, token, "", http.Header{"If-Match": {etag}})
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("Concurrency test stale delete get a status code %v", resp.StatusCode)
+	}
+	resp = doPatch(t, path, token, "application/json", `{"first_name":"Patched"}`)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") != `"3"` {
+		t.Errorf("Concurrency test patch without If-Match get a status code %v with the ETag %v", resp.StatusCode, resp.Header.Get("ETag"))
+	}
+}
+
+func TestConditionalGet(t *testing.T) {
+	admin := &user.User{
+		FirstName: "FirstName",
+		LastName:  "LastName",
+		Nickname:  "Conditional",
+		Password:  "Password",
+		Email:     "Conditional@email.com",
+		Country:   "ConditionalCountry",
+		Role:      user.RoleAdmin,
+	}
+	if err := usersStore.Create("", admin); err != nil {
+		t.Fatalf("Create user failled for conditional get test with err %v", err)
+	}
+	defer deleteForGood(admin.ID)
+	token := doLogin(t, "Conditional").AccessToken
+	path := "/users/" + admin.ID
+
+	resp := doRequest(t, "GET", path, token, "")
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	if resp.StatusCode != http.StatusOK || etag == "" || lastModified == "" {
+		t.Fatalf("Conditional get test get get a status code %v with the ETag %v and Last-Modified %v", resp.StatusCode, etag, lastModified)
+	}
+	if etag != `"1"` || resp.Header.Get("Vary") != "Authorization" {
+		t.Errorf("Conditional get test get the ETag %v and Vary %v", etag, resp.Header.Get("Vary"))
+	}
+	//a projection is another representation, with its own weak tag
+	resp = doRequestWithHeader(t, "GET", path+"?fields=id", token, "", http.Header{"If-None-Match": {etag}})
+	fieldsETag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || fieldsETag == etag || !strings.HasPrefix(fieldsETag, `W/"1-`) {
+		t.Errorf("Conditional get test get with fields get a status code %v with the ETag %v", resp.StatusCode, fieldsETag)
+	}
+	resp = doRequestWithHeader(t, "GET", path+"?fields=id", token, "", http.Header{"If-None-Match": {fieldsETag}})
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("Conditional get test get with fields and its ETag get a status code %v", resp.StatusCode)
+	}
+	listPath := "/users?country=ConditionalCountry"
+	resp = doRequest(t, "GET", listPath, token, "")
+	listETag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || listETag == "" {
+		t.Fatalf("Conditional get test list get a status code %v with the ETag %v", resp.StatusCode, listETag)
+	}
+
+	//unchanged, the client keeps its copy
+	for _, item := range []struct {
+		path   string
+		header http.Header
+	}{
+		{path, http.Header{"If-None-Match": {etag}}},
+		{path, http.Header{"If-Modified-Since": {lastModified}}},
+		{listPath, http.Header{"If-None-Match": {listETag}}},
+	} {
+		resp = doRequestWithHeader(t, "GET", item.path, token, "", item.header)
+		if resp.StatusCode != http.StatusNotModified {
+			t.Errorf("Conditional get test %v with %v get a status code %v", item.path, item.header, resp.StatusCode)
+		}
+	}
+
+	//changed, the client gets the new one
+	resp = doPatch(t, path, token, "application/json", `{"first_name":"Changed"}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Conditional get test patch get a status code %v", resp.StatusCode)
+	}
+	for _, item := range []struct {
+		path   string
+		header http.Header
+	}{
+		{path, http.Header{"If-None-Match": {etag}}},
+		{listPath, http.Header{"If-None-Match": {listETag}}},
+	} {
+		resp = doRequestWithHeader(t, "GET", item.path, token, "", item.header)
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Conditional get test %v with %v after a change get a status code %v", item.path, item.header, resp.StatusCode)
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	admin := &user.User{
+		FirstName: "FirstName",
+		LastName:  "LastName",
+		Nickname:  "Publisher",
+		Password:  "Password",
+		Email:     "Publisher@email.com",
+		Country:   "Country",
+		Role:      user.RoleAdmin,
+	}
+	if err := usersStore.Create("", admin); err != nil {
+		t.Fatalf("Create user failled for events test with err %v", err)
+	}
+	defer deleteForGood(admin.ID)
+	token := doLogin(t, "Publisher").AccessToken
+
+	//the events of the previous changes are relayed before the subscription
+	publisher := user.NewInProcessPublisher()
+	relay := user.NewRelay(usersStore.Outbox(), publisher)
+	if _, err := relay.RelayOnce(time.Now()); err != nil {
+		t.Fatalf("Events test relay get an err %v", err)
+	}
+	var events []user.Event
+	publisher.Subscribe(func(e user.Event) error {
+		events = append(events, e)
+		return nil
+	})
+
+	resp := doRequest(t, "POST", "/users", token, `{"first_name":"FirstName","last_name":"LastName","nickname":"Published","password":"Password","email":"Published@email.com","country":"Country"}`)
+	var u user.User
+	if err := json.NewDecoder(resp.Body).Decode(&u); err != nil {
+		t.Fatalf("Events test create get an err %v trying to parse the body", err.Error())
+	}
+	created := u.ID
+	resp = doPatch(t, "/users/"+created, token, "application/json", `{"first_name":"Patched","password":"Secret"}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Events test patch get a status code %v", resp.StatusCode)
+	}
+	resp = doRequest(t, "DELETE", "/users/"+created, token, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Events test delete get a status code %v", resp.StatusCode)
+	}
+	usersStore.Purge(time.Now())
+
+	//they are published once relayed from the outbox, in their order
+	if _, err := relay.RelayOnce(time.Now()); err != nil {
+		t.Fatalf("Events test relay get an err %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("Events test get the events %+v", events)
+	}
+	for i, eventType := range []user.EventType{user.EventUserCreated, user.EventUserUpdated, user.EventUserDeleted} {
+		meta := events[i].Meta()
+		if meta.Type != eventType || meta.ID == "" || meta.Timestamp.IsZero() || meta.SchemaVersion != user.EventSchemaVersion {
+			t.Errorf("Events test event %v get %+v but expected a %v", i, meta, eventType)
+		}
+		if events[i].Subject().ID != created || events[i].Subject().Password != "" {
+			t.Errorf("Events test event %v get the user %+v", i, events[i].Subject())
+		}
+	}
+	updated, ok := events[1].(user.UserUpdated)
+	expected := []user.FieldChange{{Field: "first_name", From: "FirstName", To: "Patched"}, {Field: "password", From: user.RedactedValue, To: user.RedactedValue}}
+	if !ok || !reflect.DeepEqual(updated.Changes, expected) {
+		t.Errorf("Events test update get the changes %+v but expected %+v", events[1], expected)
+	}
+	if deleted, ok := events[2].(user.UserDeleted); !ok || deleted.User.DeletedAt == nil {
+		t.Errorf("Events test delete get %+v", events[2])
+	}
+}
+
+type webhookDeliveries struct {
+	Deliveries []user.WebhookDelivery `json:"deliveries"`
+	Total      int                    `json:"total"`
+}
+
+func TestWebhooks(t *testing.T) {
+	users := map[string]*user.User{}
+	for _, role := range []string{user.RoleAdmin, user.RoleSupport} {
+		u := &user.User{
+			FirstName: "FirstName",
+			LastName:  "LastName",
+			Nickname:  role + "Webhook",
+			Password:  "Password",
+			Email:     role + "Webhook@email.com",
+			Country:   "Country",
+			Role:      role,
+		}
+		if err := usersStore.Create("", u); err != nil {
+			t.Fatalf("Create user failled for webhooks test with err %v", err)
+		}
+		defer deleteForGood(u.ID)
+		users[role] = u
+	}
+	token := doLogin(t, "adminWebhook").AccessToken
+
+	//a local receiver checks the signature with the secret returned at the creation
+	var secret string
+	var received []string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(user.HeaderWebhookTimestamp), 10, 64)
+		if !user.VerifyWebhook(secret, timestamp, body, r.Header.Get(user.HeaderWebhookSignature)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		received = append(received, r.Header.Get(user.HeaderWebhookEventID))
+	}))
+	defer receiver.Close()
+
+	//the events of the previous changes are relayed before the subscription
+	dispatcher := user.NewWebhookDispatcher(webhookStore)
+	dispatcher.Client = receiver.Client()
+	relay := user.NewRelay(usersStore.Outbox(), dispatcher)
+	if _, err := relay.RelayOnce(time.Now()); err != nil {
+		t.Fatalf("Webhooks test relay get an err %v", err)
+	}
+
+	//only an admin manages the webhooks
+	resp := doRequest(t, "POST", "/webhooks", doLogin(t, "supportWebhook").AccessToken, `{"url":"`+receiver.URL+`","event_types":["user.created"]}`)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Webhooks test create by support get a status code %v", resp.StatusCode)
+	}
+	resp = doRequest(t, "POST", "/webhooks", token, `{"url":"ftp://example.com","event_types":["user.renamed"]}`)
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("Webhooks test create invalid get a status code %v", resp.StatusCode)
+	}
+	resp = doRequest(t, "POST", "/webhooks", token, `{"url":"`+receiver.URL+`","event_types":["user.created"]}`)
+	var subscription user.WebhookSubscription
+	if err := json.NewDecoder(resp.Body).Decode(&subscription); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Webhooks test create get a status code %v and an err %v", resp.StatusCode, err)
+	}
+	defer webhookStore.DeleteSubscription(subscription.ID)
+	if subscription.ID == "" || subscription.Secret == "" {
+		t.Fatalf("Webhooks test create get %+v", subscription)
+	}
+	secret = subscription.Secret
+
+	//the secret is never returned again
+	resp = doRequest(t, "GET", "/webhooks/"+subscription.ID, token, "")
+	var got user.WebhookSubscription
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil || got.URL != receiver.URL || got.Secret != "" {
+		t.Errorf("Webhooks test get %+v with err %v", got, err)
+	}
+
+	resp = doRequest(t, "POST", "/users", token, `{"first_name":"FirstName","last_name":"LastName","nickname":"Hooked","password":"Password","email":"Hooked@email.com","country":"Country"}`)
+	var u user.User
+	if err := json.NewDecoder(resp.Body).Decode(&u); err != nil {
+		t.Fatalf("Webhooks test create user get an err %v trying to parse the body", err.Error())
+	}
+	defer deleteForGood(u.ID)
+	if _, err := relay.RelayOnce(time.Now()); err != nil {
+		t.Fatalf("Webhooks test relay get an err %v", err)
+	}
+	if _, err := dispatcher.DeliverOnce(time.Now()); err != nil {
+		t.Fatalf("Webhooks test deliver get an err %v", err)
+	}
+	deliveries := doWebhookDeliveries(t, "/webhooks/"+subscription.ID+"/deliveries?page_size=1", token)
+	if deliveries.Total != 1 || len(deliveries.Deliveries) != 1 || len(received) != 1 {
+		t.Fatalf("Webhooks test deliveries get %+v and the receiver %v", deliveries, received)
+	}
+	delivery := deliveries.Deliveries[0]
+	if delivery.Status != user.DeliverySucceeded || delivery.EventID != received[0] || delivery.EventType != user.EventUserCreated {
+		t.Errorf("Webhooks test delivery get %+v", delivery)
+	}
+
+	//a redelivery is queued then sent again
+	resp = doRequest(t, "POST", "/webhooks/"+subscription.ID+"/deliveries/"+delivery.ID+"/redeliver", token, "")
+	var redelivery user.WebhookDelivery
+	if err := json.NewDecoder(resp.Body).Decode(&redelivery); err != nil || resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Webhooks test redeliver get a status code %v and an err %v", resp.StatusCode, err)
+	}
+	if redelivery.RedeliveryOf != delivery.ID || redelivery.Status != user.DeliveryPending {
+		t.Errorf("Webhooks test redeliver get %+v", redelivery)
+	}
+	if _, err := dispatcher.DeliverOnce(time.Now()); err != nil {
+		t.Fatalf("Webhooks test deliver get an err %v", err)
+	}
+	if len(received) != 2 || received[1] != received[0] {
+		t.Errorf("Webhooks test redeliver get the receiver %v", received)
+	}
+
+	//an update keeps the secret, a deleted subscription is not found
+	resp = doRequest(t, "PUT", "/webhooks/"+subscription.ID, token, `{"url":"`+receiver.URL+`/other","event_types":["user.deleted"]}`)
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil || got.URL != receiver.URL+"/other" || got.Secret != "" {
+		t.Errorf("Webhooks test update get %+v with err %v", got, err)
+	}
+	if saved, err := webhookStore.GetSubscription(subscription.ID); err != nil || saved.Secret != secret {
+		t.Errorf("Webhooks test update get the secret changed with err %v", err)
+	}
+	resp = doRequest(t, "DELETE", "/webhooks/"+subscription.ID, token, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Webhooks test delete get a status code %v", resp.StatusCode)
+	}
+	for _, path := range []string{"/webhooks/" + subscription.ID, "/webhooks/" + subscription.ID + "/deliveries"} {
+		resp = doRequest(t, "GET", path, token, "")
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Webhooks test GET %v after the delete get a status code %v", path, resp.StatusCode)
+		}
+	}
+}
+
+func doWebhookDeliveries(t *testing.T, path, token string) webhookDeliveries {
+	resp := doRequest(t, "GET", path, token, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %v get a status code %v", path, resp.StatusCode)
+	}
+	var list webhookDeliveries
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatalf("GET %v get an err %v trying to parse the body", path, err.Error())
+	}
+	return list
+}
+
+//serverSentEvent is a block of a stream, a comment alone for a heartbeat
+type serverSentEvent struct {
+	id, event, data, comment string
+}
+
+//openEventStream requests the stream, the reads fail after a few seconds rather than blocking the tests
+func openEventStream(t *testing.T, path, token, lastEventID string) (*http.Response, *bufio.Reader) {
+	req, err := http.NewRequest("GET", server.URL+path, nil)
+	if err != nil {
+		t.Fatalf("GET %v get an err %v", path, err.Error())
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+	if err != nil {
+		t.Fatalf("GET %v get an err %v", path, err.Error())
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp, bufio.NewReader(resp.Body)
+}
+
+//readEvent reads the next event of the stream, after the heartbeats
+func readEvent(t *testing.T, reader *bufio.Reader) serverSentEvent {
+	for {
+		if sse := readServerSentEvent(t, reader); sse.event != "" {
+			return sse
+		}
+	}
+}
+
+//readServerSentEvent reads the next block of the stream
+func readServerSentEvent(t *testing.T, reader *bufio.Reader) serverSentEvent {
+	var sse serverSentEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Read of the event stream get an err %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return sse
+		}
+		if strings.HasPrefix(line, ":") {
+			sse.comment = strings.TrimSpace(line[1:])
+			continue
+		}
+		field := strings.SplitN(line, ": ", 2)
+		if len(field) != 2 {
+			t.Fatalf("Read of the event stream get the line %v", line)
+		}
+		switch field[0] {
+		case "id":
+			sse.id = field[1]
+		case "event":
+			sse.event = field[1]
+		case "data":
+			sse.data = field[1]
+		}
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	users := map[string]*user.User{}
+	for _, role := range []string{user.RoleAdmin, user.RoleSupport} {
+		u := &user.User{
+			FirstName: "FirstName",
+			LastName:  "LastName",
+			Nickname:  role + "Stream",
+			Password:  "Password",
+			Email:     role + "Stream@email.com",
+			Country:   "Country",
+			Role:      role,
+		}
+		if err := usersStore.Create("", u); err != nil {
+			t.Fatalf("Create user failled for event stream test with err %v", err)
+		}
+		defer deleteForGood(u.ID)
+		users[role] = u
+	}
+	token := doLogin(t, "adminStream").AccessToken
+
+	//the events of the previous changes are relayed before the stream
+	relay := user.NewRelay(usersStore.Outbox(), eventStream)
+	if _, err := relay.RelayOnce(time.Now()); err != nil {
+		t.Fatalf("Event stream test relay get an err %v", err)
+	}
+	resp := doRequest(t, "GET", "/users/events?type=user.renamed", token, "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Event stream test unknown type get a status code %v", resp.StatusCode)
+	}
+
+	//only the events of the country are streamed
+	resp, stream := openEventStream(t, "/users/events?country=Streamland", token, "")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Event stream test get a status code %v and a Content-Type %v", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	created := map[string]string{}
+	for _, country := range []string{"Elsewhere", "Streamland"} {
+		resp := doRequest(t, "POST", "/users", token, `{"first_name":"FirstName","last_name":"LastName","nickname":"`+country+`","password":"Password","email":"`+country+`@email.com","country":"`+country+`"}`)
+		var u user.User
+		if err := json.NewDecoder(resp.Body).Decode(&u); err != nil {
+			t.Fatalf("Event stream test create get an err %v trying to parse the body", err.Error())
+		}
+		defer deleteForGood(u.ID)
+		created[country] = u.ID
+	}
+	if _, err := relay.RelayOnce(time.Now()); err != nil {
+		t.Fatalf("Event stream test relay get an err %v", err)
+	}
+	sse := readEvent(t, stream)
+	var createdEvent user.UserCreated
+	if err := json.Unmarshal([]byte(sse.data), &createdEvent); err != nil || sse.event != string(user.EventUserCreated) ||
+		sse.id != createdEvent.ID || createdEvent.User.ID != created["Streamland"] || createdEvent.User.Email == "" {
+		t.Fatalf("Event stream test get the event %+v with err %v", sse, err)
+	}
+	resp.Body.Close()
+
+	//a support resumes from the last event received, without the emails
+	resp = doPatch(t, "/users/"+created["Streamland"], token, "application/json", `{"email":"Moved@email.com"}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Event stream test patch get a status code %v", resp.StatusCode)
+	}
+	if _, err := relay.RelayOnce(time.Now()); err != nil {
+		t.Fatalf("Event stream test relay get an err %v", err)
+	}
+	_, stream = openEventStream(t, "/users/events?country=Streamland", doLogin(t, "supportStream").AccessToken, sse.id)
+	sse = readEvent(t, stream)
+	var updatedEvent user.UserUpdated
+	if err := json.Unmarshal([]byte(sse.data), &updatedEvent); err != nil || sse.event != string(user.EventUserUpdated) ||
+		updatedEvent.User.ID != created["Streamland"] || updatedEvent.User.Email != "" {
+		t.Fatalf("Event stream test resumed get the event %+v with err %v", sse, err)
+	}
+	expected := []user.FieldChange{{Field: "email", From: user.RedactedValue, To: user.RedactedValue}}
+	if !reflect.DeepEqual(updatedEvent.Changes, expected) {
+		t.Errorf("Event stream test resumed get the changes %+v but expected %+v", updatedEvent.Changes, expected)
+	}
+
+	//a browser EventSource authenticates with the access_token parameter, checked as the header
+	resp, _ = openEventStream(t, "/users/events?access_token="+token, "", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Event stream test with the access_token parameter get a status code %v", resp.StatusCode)
+	}
+	resp, _ = openEventStream(t, "/users/events?access_token="+token+"x", "", "")
+	var problem errors.Problem
+	if err := json.NewDecoder(resp.Body).Decode(&problem); err != nil || resp.StatusCode != http.StatusUnauthorized || problem.Instance != "/users/events" {
+		t.Errorf("Event stream test with an invalid access_token parameter get a status code %v and %+v", resp.StatusCode, problem)
+	}
+	resp = doRequest(t, "GET", "/users?access_token="+token, "", "")
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("List with the access_token parameter get a status code %v", resp.StatusCode)
+	}
+
+	//an idle stream sends heartbeats
+	_, stream = openEventStream(t, "/users/events", token, "")
+	if sse := readServerSentEvent(t, stream); sse.comment != "heartbeat" || sse.id != "" {
+		t.Errorf("Event stream test idle get %+v", sse)
+	}
+
+	//a stream ends at the heartbeat following a logout, or the change of the role of its User
+	logoutTokens := doLogin(t, "adminStream")
+	_, stream = openEventStream(t, "/users/events", logoutTokens.AccessToken, "")
+	if resp := doRequest(t, "POST", "/auth/logout", logoutTokens.AccessToken, "{}"); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Event stream test logout get a status code %v", resp.StatusCode)
+	}
+	if _, err := ioutil.ReadAll(stream); err != nil {
+		t.Errorf("Event stream test after a logout get an err %v", err)
+	}
+	_, stream = openEventStream(t, "/users/events", doLogin(t, "supportStream").AccessToken, "")
+	resp = doPatch(t, "/users/"+users[user.RoleSupport].ID, token, "application/json", `{"role":"self"}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Event stream test role change get a status code %v", resp.StatusCode)
+	}
+	if _, err := ioutil.ReadAll(stream); err != nil {
+		t.Errorf("Event stream test after a role change get an err %v", err)
+	}
+}
+
+type auditList struct {
+	Entries []user.AuditEntry `json:"entries"`
+	Total   int               `json:"total"`
+	HasNext bool              `json:"has_next"`
+}
+
+func doAuditList(t *testing.T, path, token string) auditList {
+	resp := doRequest(t, "GET", path, token, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %v get a status code %v", path, resp.StatusCode)
+	}
+	var list auditList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatalf("GET %v get an err %v trying to parse the body", path, err.Error())
+	}
+	return list
+}
+
+func TestHistory(t *testing.T) {
+	users := map[string]*user.User{}
+	for _, role := range []string{user.RoleAdmin, user.RoleSupport, user.RoleSelf} {
+		u := &user.User{
+			FirstName: "FirstName",
+			LastName:  "LastName",
+			Nickname:  "History" + role,
+			Password:  "Password",
+			Email:     "History" + role + "@email.com",
+			Country:   "Country",
+			Role:      role,
+		}
+		if err := usersStore.Create("", u); err != nil {
+			t.Fatalf("Create user failled for history test with err %v", err)
+		}
+		defer deleteForGood(u.ID)
+		users[role] = u
+	}
+	adminToken := doLogin(t, "Historyadmin").AccessToken
+	supportToken := doLogin(t, "Historysupport").AccessToken
+	selfToken := doLogin(t, "Historyself").AccessToken
+	admin := users[user.RoleAdmin]
+
+	//every change is recorded
+	resp := doRequest(t, "POST", "/users", adminToken, `{"first_name":"FirstName","last_name":"LastName","password":"Password","nickname":"HistoryNickname","email":"HistoryEmail@email.com","country":"Country"}`)
+	var created user.User
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("History test create get an err %v trying to parse the body", err.Error())
+	}
+	defer deleteForGood(created.ID)
+	resp = doPatch(t, "/users/"+created.ID, adminToken, user.ContentTypeMergePatch, `{"email":"HistoryEmail2@email.com","password":"NewPassword"}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("History test patch get a status code %v", resp.StatusCode)
+	}
+	for _, request := range []struct{ method, path string }{{"DELETE", "/users/" + created.ID}, {"POST", "/users/" + created.ID + "/restore"}} {
+		resp = doRequest(t, request.method, request.path, adminToken, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("History test %v get a status code %v", request.method, resp.StatusCode)
+		}
+	}
+
+	history := doAuditList(t, "/users/"+created.ID+"/history", adminToken)
+	actions := []user.AuditAction{user.AuditRestore, user.AuditDelete, user.AuditUpdate, user.AuditCreate}
+	if history.Total != len(actions) {
+		t.Fatalf("History test get %+v", history)
+	}
+	for i, entry := range history.Entries {
+		if entry.Action != actions[i] || entry.Actor != admin.ID || entry.UserID != created.ID {
+			t.Errorf("History test get the entry %+v at %v but expected the action %v", entry, i, actions[i])
+		}
+	}
+	expectedChanges := []user.FieldChange{
+		{Field: "email", From: "HistoryEmail@email.com", To: "HistoryEmail2@email.com"},
+		{Field: "password", From: user.RedactedValue, To: user.RedactedValue},
+	}
+	if !reflect.DeepEqual(history.Entries[2].Changes, expectedChanges) {
+		t.Errorf("History test get the changes %+v but expected %+v", history.Entries[2].Changes, expectedChanges)
+	}
+	history = doAuditList(t, "/users/"+created.ID+"/history?page=1&page_size=3", adminToken)
+	if history.Total != 4 || len(history.Entries) != 1 || history.Entries[0].Action != user.AuditCreate {
+		t.Errorf("History test by page get %+v", history)
+	}
+
+	//the support reads it without the emails
+	history = doAuditList(t, "/users/"+created.ID+"/history?action=update", supportToken)
+	if history.Total != 1 || history.Entries[0].Changes[0].To != user.RedactedValue {
+		t.Errorf("History test support get %+v", history)
+	}
+
+	//only the admin queries every User
+	list := doAuditList(t, "/audit?actor="+admin.ID+"&action=create", adminToken)
+	if list.Total != 1 || list.Entries[0].UserID != created.ID {
+		t.Errorf("History test audit get %+v", list)
+	}
+	for path, token := range map[string]string{
+		"/users/" + created.ID + "/history": selfToken,
+		"/audit":                            supportToken,
+	} {
+		resp = doRequest(t, "GET", path, token, "")
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("History test %v get a status code %v", path, resp.StatusCode)
+		}
+	}
+	resp = doRequest(t, "GET", "/audit?action=read", adminToken, "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("History test audit with an unknown action get a status code %v", resp.StatusCode)
+	}
+}
@@ -0,0 +1,210 @@
+package user
+
+import (
+	"errors"
+	"net/url"
+	errors2 "test/errors"
+	"test/utils"
+	"time"
+)
+
+//Implements the audit trail of the changes on the Users
+
+// AuditAction is the change recorded by an AuditEntry.
+type AuditAction string
+
+const (
+	AuditCreate  AuditAction = "create"
+	AuditUpdate  AuditAction = "update"
+	AuditDelete  AuditAction = "delete"
+	AuditRestore AuditAction = "restore"
+)
+
+// RedactedValue replaces the secret values in the audit trail.
+const RedactedValue = "[REDACTED]"
+
+var ErrParamAuditAction = errors2.BadRequest(errors.New("action can only be create, update, delete or restore"))
+
+//auditedFields are the fields compared by an AuditEntry, in the order of its Changes
+var auditedFields = []string{"first_name", "last_name", "nickname", "email", "country", "role", "password", "deleted_at"}
+
+//secretFields are only recorded as changed, never with their values
+var secretFields = map[string]bool{"password": true}
+
+// FieldChange is the value of a User field before and after a change, "" when unset.
+type FieldChange struct {
+	Field string `bson:"field" json:"field"`
+	From  string `bson:"from" json:"from"`
+	To    string `bson:"to" json:"to"`
+}
+
+// AuditEntry records who changed which fields of a User, and when. Once appended it is never modified.
+type AuditEntry struct {
+	ID        string        `bson:"_id,omitempty" json:"id"`
+	UserID    string        `bson:"user_id" json:"user_id"`
+	Action    AuditAction   `bson:"action" json:"action"`
+	Actor     string        `bson:"actor" json:"actor"` //id of the User who made the change
+	Timestamp time.Time     `bson:"timestamp" json:"timestamp"`
+	Changes   []FieldChange `bson:"changes" json:"changes"`
+}
+
+// NewAuditEntry returns the entry of the action made by the actor, with the changed fields between before and after.
+// before is nil for a creation.
+func NewAuditEntry(action AuditAction, actor string, before, after *User) *AuditEntry {
+	return &AuditEntry{
+		UserID:    after.ID,
+		Action:    action,
+		Actor:     actor,
+		Timestamp: time.Now().Truncate(time.Millisecond),
+		Changes:   diffUsers(before, after),
+	}
+}
+
+//diffUsers returns the changes of the audited fields from before (nil for none) to after, the secrets redacted
+func diffUsers(before, after *User) []FieldChange {
+	if before == nil {
+		before = &User{}
+	}
+	changes := []FieldChange{}
+	for _, field := range auditedFields {
+		from, to := auditValue(before, field), auditValue(after, field)
+		if from == to {
+			continue
+		}
+		if secretFields[field] {
+			from, to = redactAuditValue(from), redactAuditValue(to)
+		}
+		changes = append(changes, FieldChange{Field: field, From: from, To: to})
+	}
+	return changes
+}
+
+func auditValue(u *User, field string) string {
+	switch field {
+	case "password":
+		return u.Password
+	case "deleted_at":
+		if u.DeletedAt == nil {
+			return ""
+		}
+		return u.DeletedAt.UTC().Format(sortTimeFormat)
+	}
+	return fieldValues[field](u)
+}
+
+func redactAuditValue(value string) string {
+	if value == "" {
+		return ""
+	}
+	return RedactedValue
+}
+
+//Redact removes the values of the fields removed by User.Redact
+func (e *AuditEntry) Redact() {
+	e.Changes = redactChanges(e.Changes)
+}
+
+//redactChanges returns a copy of the changes without the values of the fields removed by User.Redact
+func redactChanges(changes []FieldChange) []FieldChange {
+	redacted := append([]FieldChange{}, changes...)
+	for i, change := range redacted {
+		if change.Field == "email" {
+			redacted[i].From, redacted[i].To = redactAuditValue(change.From), redactAuditValue(change.To)
+		}
+	}
+	return redacted
+}
+
+// AuditQuery selects the entries of an AuditStore List, every empty field selects them all.
+type AuditQuery struct {
+	UserID   string
+	Actor    string
+	Action   AuditAction
+	Since    time.Time //included
+	Until    time.Time //included
+	Page     int64     //starting at 0
+	PageSize int64     //0 means no limit
+}
+
+//matches reports if the entry is selected by the query
+func (q AuditQuery) matches(e *AuditEntry) bool {
+	return (q.UserID == "" || e.UserID == q.UserID) &&
+		(q.Actor == "" || e.Actor == q.Actor) &&
+		(q.Action == "" || e.Action == q.Action) &&
+		(q.Since.IsZero() || !e.Timestamp.Before(q.Since)) &&
+		(q.Until.IsZero() || !e.Timestamp.After(q.Until))
+}
+
+// AuditPage is a page of the entries, the most recent first.
+type AuditPage struct {
+	Entries []AuditEntry
+	Total   int  //number of entries matching the query, whatever the page
+	HasNext bool //more entries follow this page
+}
+
+//newAuditPage trims the entries fetched with one extra to know if a next page exists
+func newAuditPage(entries []AuditEntry, total int, q AuditQuery) *AuditPage {
+	page := &AuditPage{Entries: entries, Total: total}
+	if q.PageSize > 0 && int64(len(entries)) > q.PageSize {
+		page.Entries = entries[:q.PageSize]
+		page.HasNext = true
+	}
+	return page
+}
+
+// AuditStore keeps the audit trail, it can only be appended to.
+// The UserRepository appends the entries of the changes of the Users itself, in the same transaction.
+type AuditStore interface {
+	Append(e *AuditEntry) error
+	//List returns the page of the entries matching the query, the most recent first
+	List(q AuditQuery) (*AuditPage, error)
+}
+
+// DecodeAuditQuery reads the query of the audit trail from the parameters user_id, actor, action,
+// since and until (RFC 3339 dates), page and page_size.
+func DecodeAuditQuery(query url.Values) (AuditQuery, error) {
+	var q AuditQuery
+	var err error
+	q.Page, err = utils.Int64FromQuery("page", query)
+	if err != nil {
+		return AuditQuery{}, errors2.BadRequest(err)
+	}
+	q.PageSize, err = utils.Int64FromQuery("page_size", query)
+	if err != nil {
+		return AuditQuery{}, errors2.BadRequest(err)
+	}
+	if q.Page < 0 || q.PageSize < 0 {
+		return AuditQuery{}, ErrParamPage
+	}
+
+	for _, id := range []string{query.Get("user_id"), query.Get("actor")} {
+		if id == "" {
+			continue
+		}
+		if _, err := ParseID(id); err != nil {
+			return AuditQuery{}, err
+		}
+	}
+	q.UserID, q.Actor = query.Get("user_id"), query.Get("actor")
+
+	switch action := AuditAction(query.Get("action")); action {
+	case "", AuditCreate, AuditUpdate, AuditDelete, AuditRestore:
+		q.Action = action
+	default:
+		return AuditQuery{}, ErrParamAuditAction
+	}
+
+	for _, date := range []struct {
+		key   string
+		value *time.Time
+	}{{"since", &q.Since}, {"until", &q.Until}} {
+		if query.Get(date.key) == "" {
+			continue
+		}
+		*date.value, err = time.Parse(time.RFC3339, query.Get(date.key))
+		if err != nil {
+			return AuditQuery{}, ErrParamDate
+		}
+	}
+	return q, nil
+}
@@ -0,0 +1,62 @@
+package user
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sync"
+)
+
+// AuditMemoryStore implements the AuditStore in memory.
+// It is safe for concurrent use.
+type AuditMemoryStore struct {
+	mu      sync.RWMutex
+	entries []AuditEntry //in the order they were appended
+}
+
+// NewAuditMemoryStore returns an empty AuditMemoryStore
+func NewAuditMemoryStore() *AuditMemoryStore {
+	return &AuditMemoryStore{}
+}
+
+// Append adds a copy of the entry to the audit trail, its id is set.
+func (s *AuditMemoryStore) Append(e *AuditEntry) error {
+	e.ID = primitive.NewObjectID().Hex()
+	stored := *e
+	stored.Changes = append([]FieldChange{}, e.Changes...)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries = append(s.entries, stored)
+	return nil
+}
+
+// List returns the page of the entries matching the query, the most recent first.
+func (s *AuditMemoryStore) List(q AuditQuery) (*AuditPage, error) {
+	s.mu.RLock()
+	var entries []AuditEntry
+	//the most recent were appended last
+	for i := len(s.entries) - 1; i >= 0; i-- {
+		if q.matches(&s.entries[i]) {
+			entry := s.entries[i]
+			entry.Changes = append([]FieldChange{}, entry.Changes...)
+			entries = append(entries, entry)
+		}
+	}
+	s.mu.RUnlock()
+
+	//the total ignores the pagination
+	total := len(entries)
+	if q.PageSize > 0 {
+		//rmq page start at 0
+		skip := q.PageSize * q.Page
+		if skip >= int64(len(entries)) {
+			skip = int64(len(entries))
+		}
+		entries = entries[skip:]
+		//one more entry tells if there is a next page
+		if int64(len(entries)) > q.PageSize+1 {
+			entries = entries[:q.PageSize+1]
+		}
+	}
+	return newAuditPage(entries, total, q), nil
+}
@@ -0,0 +1,90 @@
+package user
+
+import (
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"net/http"
+	"net/url"
+	"strconv"
+	"test/utils"
+)
+
+//Implements the audit trail handler
+
+// AuditResource implements the queries of the audit trail of every User.
+type AuditResource struct {
+	Store  AuditStore
+	Policy Policy
+}
+
+// NewAuditResource creates and returns an audit resource backed by any AuditStore, the access is decided by the Policy.
+func NewAuditResource(store AuditStore, policy Policy) *AuditResource {
+	return &AuditResource{
+		Store:  store,
+		Policy: policy,
+	}
+}
+
+// Router for the queries of the audit trail
+func (rs *AuditResource) Router() *chi.Mux {
+	r := chi.NewRouter()
+	r.Get("/", rs.list)
+	return r
+}
+
+//Response model for a page of the audit trail
+type auditListResponse struct {
+	success  bool
+	Entries  []AuditEntry `json:"entries"`
+	Count    int          `json:"count"` //number of entries in the page
+	Total    int          `json:"total"` //number of entries matching the query
+	Page     int64        `json:"page"`
+	PageSize int64        `json:"page_size"`
+	HasNext  bool         `json:"has_next"`
+	Links    pageLinks    `json:"links"`
+}
+
+func newAuditListResponse(auditPage *AuditPage, q AuditQuery, requestURL *url.URL, success bool) *auditListResponse {
+	//an empty page is an empty array
+	entries := auditPage.Entries
+	if entries == nil {
+		entries = []AuditEntry{}
+	}
+	resp := &auditListResponse{
+		success:  success,
+		Entries:  entries,
+		Count:    len(entries),
+		Total:    auditPage.Total,
+		Page:     q.Page,
+		PageSize: q.PageSize,
+		HasNext:  auditPage.HasNext,
+	}
+	if resp.HasNext {
+		resp.Links.Next = pageURL(requestURL, "page", strconv.FormatInt(q.Page+1, 10))
+	}
+	if q.PageSize > 0 && q.Page > 0 {
+		resp.Links.Prev = pageURL(requestURL, "page", strconv.FormatInt(q.Page-1, 10))
+	}
+	return resp
+}
+
+// Returns the entries of the audit trail matching the query, the most recent first
+func (rs *AuditResource) list(w http.ResponseWriter, r *http.Request) {
+	principal, _ := PrincipalFromContext(r.Context())
+	if _, err := rs.Policy.Authorize(principal, ActionAudit); err != nil {
+		utils.RenderForbidden(w, r, err)
+		return
+	}
+
+	q, err := DecodeAuditQuery(r.URL.Query())
+	if err != nil {
+		utils.Render(w, r, err)
+		return
+	}
+	auditPage, err := rs.Store.List(q)
+	if err != nil {
+		utils.Render(w, r, err)
+		return
+	}
+	render.Respond(w, r, newAuditListResponse(auditPage, q, r.URL, true))
+}
@@ -0,0 +1,94 @@
+package user
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuditMongoStore implements the AuditStore on MongoDB, in its own collection
+type AuditMongoStore struct {
+	collection *mongo.Collection
+	ctx        context.Context
+}
+
+// NewAuditMongoStore returns an AuditMongoStore, indexed for the history of a User and the global queries
+func NewAuditMongoStore(db *mongo.Database, ctx context.Context) (*AuditMongoStore, error) {
+	auditCollection := db.Collection("audit")
+	_, err := auditCollection.Indexes().CreateMany(
+		context.Background(),
+		[]mongo.IndexModel{
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "timestamp", Value: -1}}},
+			{Keys: bson.D{{Key: "timestamp", Value: -1}}},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuditMongoStore{
+		collection: auditCollection,
+		ctx:        ctx,
+	}, nil
+}
+
+// Append inserts the entry in the audit trail, its id is set.
+func (s *AuditMongoStore) Append(e *AuditEntry) error {
+	return s.insert(s.ctx, e)
+}
+
+//insert inserts the entry with ctx, the session of the transaction of the change for the UsersStore
+func (s *AuditMongoStore) insert(ctx context.Context, e *AuditEntry) error {
+	e.ID = ""
+	insertResult, err := s.collection.InsertOne(ctx, e)
+	if err != nil {
+		return err
+	}
+	return s.collection.FindOne(ctx, bson.M{"_id": insertResult.InsertedID}).Decode(e)
+}
+
+// List returns the page of the entries matching the query, the most recent first.
+func (s *AuditMongoStore) List(q AuditQuery) (*AuditPage, error) {
+	filter := bson.M{}
+	if q.UserID != "" {
+		filter["user_id"] = q.UserID
+	}
+	if q.Actor != "" {
+		filter["actor"] = q.Actor
+	}
+	if q.Action != "" {
+		filter["action"] = q.Action
+	}
+	if !q.Since.IsZero() || !q.Until.IsZero() {
+		bounds := bson.M{}
+		if !q.Since.IsZero() {
+			bounds["$gte"] = q.Since
+		}
+		if !q.Until.IsZero() {
+			bounds["$lte"] = q.Until
+		}
+		filter["timestamp"] = bounds
+	}
+
+	//the total ignores the pagination
+	total, err := s.collection.CountDocuments(s.ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	findOpts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}})
+	if q.PageSize > 0 {
+		//one more entry tells if there is a next page, rmq page start at 0
+		findOpts.SetLimit(q.PageSize + 1).SetSkip(q.PageSize * q.Page)
+	}
+	cursor, err := s.collection.Find(s.ctx, filter, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	var entries []AuditEntry
+	if err := cursor.All(s.ctx, &entries); err != nil {
+		return nil, err
+	}
+	return newAuditPage(entries, int(total), q), nil
+}
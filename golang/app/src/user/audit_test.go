@@ -0,0 +1,181 @@
+package user
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type diffUsersTest struct {
+	before          *User
+	after           *User
+	expectedChanges []FieldChange
+}
+
+func TestDiffUsers(t *testing.T) {
+	deletedAt := time.Date(2022, 1, 16, 13, 35, 13, 684000000, time.UTC)
+	user := User{
+		ID:        "61e41ed578752c5997718aff",
+		FirstName: "FirstName",
+		LastName:  "LastName",
+		Nickname:  "Nickname",
+		Password:  "$2a$04$hash",
+		Email:     "Email@email.com",
+		Country:   "Country",
+		Role:      RoleSelf,
+	}
+	updated := user
+	updated.LastName = "Longbow"
+	updated.Password = "$2a$04$otherhash"
+	deleted := user
+	deleted.DeletedAt = &deletedAt
+
+	diffUsersTests := []diffUsersTest{
+		//test a creation, the password redacted
+		{before: nil, after: &user, expectedChanges: []FieldChange{
+			{Field: "first_name", To: "FirstName"},
+			{Field: "last_name", To: "LastName"},
+			{Field: "nickname", To: "Nickname"},
+			{Field: "email", To: "Email@email.com"},
+			{Field: "country", To: "Country"},
+			{Field: "role", To: RoleSelf},
+			{Field: "password", To: RedactedValue},
+		}},
+		//test only the changed fields
+		{before: &user, after: &updated, expectedChanges: []FieldChange{
+			{Field: "last_name", From: "LastName", To: "Longbow"},
+			{Field: "password", From: RedactedValue, To: RedactedValue},
+		}},
+		{before: &user, after: &user, expectedChanges: []FieldChange{}},
+		//test a delete then a restore
+		{before: &user, after: &deleted, expectedChanges: []FieldChange{{Field: "deleted_at", To: "2022-01-16T13:35:13.684Z"}}},
+		{before: &deleted, after: &user, expectedChanges: []FieldChange{{Field: "deleted_at", From: "2022-01-16T13:35:13.684Z"}}},
+	}
+
+	for _, item := range diffUsersTests {
+		result := diffUsers(item.before, item.after)
+		if !reflect.DeepEqual(result, item.expectedChanges) {
+			t.Errorf("diffUsers for %v and %v output %v but expected %v", item.before, item.after, result, item.expectedChanges)
+		}
+	}
+
+	//the email values are removed for a redacted response
+	entry := NewAuditEntry(AuditCreate, "61e41ed578752c5997718aaa", nil, &user)
+	entry.Redact()
+	if entry.UserID != user.ID || entry.Changes[3] != (FieldChange{Field: "email", To: RedactedValue}) {
+		t.Errorf("AuditEntry.Redact output %v", entry)
+	}
+}
+
+type decodeAuditQueryTest struct {
+	query         string
+	expectedQuery AuditQuery
+	expectedErr   bool
+}
+
+func TestDecodeAuditQuery(t *testing.T) {
+	decodeAuditQueryTests := []decodeAuditQueryTest{
+		{query: "", expectedQuery: AuditQuery{}, expectedErr: false},
+		{
+			query: "user_id=61e41ed578752c5997718aff&actor=61e41ed578752c5997718aaa&action=update&since=2022-01-15T12:30:00Z&page=1&page_size=5",
+			expectedQuery: AuditQuery{
+				UserID:   "61e41ed578752c5997718aff",
+				Actor:    "61e41ed578752c5997718aaa",
+				Action:   AuditUpdate,
+				Since:    time.Date(2022, 1, 15, 12, 30, 0, 0, time.UTC),
+				Page:     1,
+				PageSize: 5,
+			},
+			expectedErr: false,
+		},
+		{query: "user_id=61e41ed5787", expectedErr: true},
+		{query: "action=read", expectedErr: true},
+		{query: "until=yesterday", expectedErr: true},
+		{query: "page_size=-1", expectedErr: true},
+	}
+
+	for _, item := range decodeAuditQueryTests {
+		query, _ := url.ParseQuery(item.query)
+		result, resultErr := DecodeAuditQuery(query)
+		if !item.expectedErr && resultErr != nil {
+			t.Errorf("DecodeAuditQuery for %v output err %v not expected", item.query, resultErr.Error())
+		}
+		if item.expectedErr && resultErr == nil {
+			t.Errorf("DecodeAuditQuery for %v output err expected but not found", item.query)
+		}
+		if resultErr == nil && !reflect.DeepEqual(result, item.expectedQuery) {
+			t.Errorf("DecodeAuditQuery for %v output %+v but expected %+v", item.query, result, item.expectedQuery)
+		}
+	}
+}
+
+func TestAuditStore(t *testing.T) {
+	var auditStore AuditStore = NewAuditMemoryStore()
+	if testDb != nil {
+		var err error
+		auditStore, err = NewAuditMongoStore(testDb, context.TODO())
+		if err != nil {
+			t.Fatalf("NewAuditMongoStore failled with err %v", err)
+		}
+	}
+	userID, otherID, actor := "61e41ed578752c5997718bbb", "61e41ed578752c5997718ccc", "61e41ed578752c5997718aaa"
+	user := User{ID: userID, FirstName: "FirstName", Email: "Email@email.com"}
+	updated := user
+	updated.FirstName = "Mike"
+	start := time.Now().Truncate(time.Millisecond)
+	entries := []*AuditEntry{
+		NewAuditEntry(AuditCreate, actor, nil, &user),
+		NewAuditEntry(AuditUpdate, userID, &user, &updated),
+		NewAuditEntry(AuditCreate, actor, nil, &User{ID: otherID, FirstName: "Other"}),
+	}
+	for i, entry := range entries {
+		entry.Timestamp = start.Add(time.Duration(i) * time.Millisecond)
+		if err := auditStore.Append(entry); err != nil || entry.ID == "" {
+			t.Fatalf("auditStore.Append for %v failled with err %v", entry, err)
+		}
+	}
+
+	auditStoreTests := []struct {
+		query           AuditQuery
+		expectedEntries []*AuditEntry
+		expectedTotal   int
+		expectedHasNext bool
+	}{
+		//the most recent first
+		{query: AuditQuery{UserID: userID}, expectedEntries: []*AuditEntry{entries[1], entries[0]}, expectedTotal: 2},
+		{query: AuditQuery{Actor: actor, Since: start}, expectedEntries: []*AuditEntry{entries[2], entries[0]}, expectedTotal: 2},
+		{query: AuditQuery{Action: AuditUpdate, Since: start, Until: start.Add(time.Millisecond)}, expectedEntries: []*AuditEntry{entries[1]}, expectedTotal: 1},
+		{query: AuditQuery{Since: start.Add(2 * time.Millisecond)}, expectedEntries: []*AuditEntry{entries[2]}, expectedTotal: 1},
+		//by page
+		{query: AuditQuery{Since: start, PageSize: 2}, expectedEntries: []*AuditEntry{entries[2], entries[1]}, expectedTotal: 3, expectedHasNext: true},
+		{query: AuditQuery{Since: start, Page: 1, PageSize: 2}, expectedEntries: []*AuditEntry{entries[0]}, expectedTotal: 3},
+		{query: AuditQuery{UserID: "61e41ed578752c5997718ddd"}, expectedEntries: nil, expectedTotal: 0},
+	}
+	for _, item := range auditStoreTests {
+		result, resultErr := auditStore.List(item.query)
+		if resultErr != nil {
+			t.Errorf("auditStore.List for %+v output err %v not expected", item.query, resultErr.Error())
+			continue
+		}
+		if result.Total != item.expectedTotal || result.HasNext != item.expectedHasNext || len(result.Entries) != len(item.expectedEntries) {
+			t.Errorf("auditStore.List for %+v output %+v", item.query, result)
+			continue
+		}
+		for i, entry := range result.Entries {
+			expected := item.expectedEntries[i]
+			if entry.ID != expected.ID || entry.Action != expected.Action || !entry.Timestamp.Equal(expected.Timestamp) || !reflect.DeepEqual(entry.Changes, expected.Changes) {
+				t.Errorf("auditStore.List for %+v output %+v at %v but expected %+v", item.query, entry, i, expected)
+			}
+		}
+	}
+
+	//the entries can't be modified through the returned ones
+	result, _ := auditStore.List(AuditQuery{UserID: userID, Action: AuditUpdate})
+	result.Entries[0].Changes[0].To = "Modified"
+	result, _ = auditStore.List(AuditQuery{UserID: userID, Action: AuditUpdate})
+	if result.Entries[0].Changes[0].To != "Mike" {
+		t.Errorf("auditStore.List output a modified entry %+v", result.Entries[0])
+	}
+}
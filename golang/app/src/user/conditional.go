@@ -0,0 +1,75 @@
+package user
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Implements the conditional GET of the Users, answered with a 304 when the client already has the response
+
+//listETag returns the weak entity tag of a list response, a hash of its content:
+//it changes with any User of the page, and with the Users entering or leaving it
+func listETag(resp *userListResponse) (string, error) {
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`, nil
+}
+
+//userETag returns the entity tag of the representation of the User: the strong version tag checked by If-Match for
+//the full User, a weak tag also changing with the selected fields and the redaction otherwise
+func userETag(u *User, fields []string, redacted bool) string {
+	if fields == nil && !redacted {
+		return ETag(u.Version)
+	}
+	selected := append([]string{}, fields...)
+	sort.Strings(selected)
+	sum := sha256.Sum256([]byte(strings.Join(selected, ",") + ";" + strconv.FormatBool(redacted)))
+	return `W/"` + strconv.FormatInt(u.Version, 10) + "-" + hex.EncodeToString(sum[:8]) + `"`
+}
+
+//setVary tells the caches a response depends on the caller, as its role redacts it
+func setVary(w http.ResponseWriter) {
+	w.Header().Add("Vary", "Authorization")
+}
+
+//setLastModified sets the Last-Modified header of the User, at the second as every http date
+func setLastModified(w http.ResponseWriter, u *User) {
+	if !u.UpdatedAt.IsZero() {
+		w.Header().Set("Last-Modified", u.UpdatedAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+// NotModified reports if the client already has the response with the etag, last modified at the time (zero if unknown).
+// If-None-Match takes precedence over If-Modified-Since, as the dates are only precise to the second.
+func NotModified(header http.Header, etag string, lastModified time.Time) bool {
+	if ifNoneMatch := header.Get("If-None-Match"); ifNoneMatch != "" {
+		return matchIfNoneMatch(ifNoneMatch, etag)
+	}
+	if ifModifiedSince := header.Get("If-Modified-Since"); ifModifiedSince != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(ifModifiedSince)
+		return err == nil && !lastModified.Truncate(time.Second).After(since)
+	}
+	return false
+}
+
+//matchIfNoneMatch reports if one of the entity tags of the header is the etag, weak tags matching strong ones
+func matchIfNoneMatch(header, etag string) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package user
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotModified(t *testing.T) {
+	lastModified := time.Date(2022, 1, 16, 13, 34, 13, 684000000, time.UTC)
+	for _, item := range []struct {
+		header   http.Header
+		expected bool
+	}{
+		{http.Header{}, false},
+		{http.Header{"If-None-Match": {`"3"`}}, true},
+		{http.Header{"If-None-Match": {`W/"3"`}}, true},
+		{http.Header{"If-None-Match": {`"1", "3"`}}, true},
+		{http.Header{"If-None-Match": {"*"}}, true},
+		{http.Header{"If-None-Match": {`"2"`}}, false},
+		{http.Header{"If-Modified-Since": {"Sun, 16 Jan 2022 13:34:13 GMT"}}, true},
+		{http.Header{"If-Modified-Since": {"Sun, 16 Jan 2022 14:00:00 GMT"}}, true},
+		{http.Header{"If-Modified-Since": {"Sun, 16 Jan 2022 13:34:12 GMT"}}, false},
+		{http.Header{"If-Modified-Since": {"yesterday"}}, false},
+		//If-None-Match takes precedence
+		{http.Header{"If-None-Match": {`"2"`}, "If-Modified-Since": {"Sun, 16 Jan 2022 14:00:00 GMT"}}, false},
+		{http.Header{"If-None-Match": {`"3"`}, "If-Modified-Since": {"Sun, 16 Jan 2022 13:00:00 GMT"}}, true},
+	} {
+		if result := NotModified(item.header, `"3"`, lastModified); result != item.expected {
+			t.Errorf("NotModified of %v output %v but expected %v", item.header, result, item.expected)
+		}
+	}
+	if NotModified(http.Header{"If-Modified-Since": {"Sun, 16 Jan 2022 14:00:00 GMT"}}, `"3"`, time.Time{}) {
+		t.Errorf("NotModified without a last modified date output true but expected false")
+	}
+}
+
+func TestUserETag(t *testing.T) {
+	u := &User{ID: "61e41ed578752c5997718aff", Version: 3}
+	full := userETag(u, nil, false)
+	if full != ETag(3) {
+		t.Errorf("userETag of the full User output %v but expected %v", full, ETag(3))
+	}
+	//every other representation has its own weak tag
+	tags := map[string]bool{full: true}
+	for _, item := range []struct {
+		fields   []string
+		redacted bool
+	}{
+		{fields: []string{"id"}},
+		{fields: []string{"id", "email"}},
+		{redacted: true},
+		{fields: []string{"id"}, redacted: true},
+	} {
+		result := userETag(u, item.fields, item.redacted)
+		if tags[result] || !strings.HasPrefix(result, `W/"3-`) {
+			t.Errorf("userETag for %v and redacted %v output %v", item.fields, item.redacted, result)
+		}
+		tags[result] = true
+	}
+	if userETag(u, []string{"email", "id"}, false) != userETag(u, []string{"id", "email"}, false) {
+		t.Errorf("userETag depends on the order of the fields")
+	}
+}
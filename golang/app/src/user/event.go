@@ -0,0 +1,106 @@
+package user
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+//Implements the events published on the changes of the Users
+
+// EventType names the change of an Event.
+type EventType string
+
+const (
+	EventUserCreated EventType = "user.created"
+	EventUserUpdated EventType = "user.updated"
+	EventUserDeleted EventType = "user.deleted"
+)
+
+// EventSchemaVersion is the version of the events content, incremented by every incompatible change.
+const EventSchemaVersion = 1
+
+// EventMeta is common to every Event.
+type EventMeta struct {
+	ID            string    `bson:"id" json:"id"`
+	Type          EventType `bson:"type" json:"type"`
+	Timestamp     time.Time `bson:"timestamp" json:"timestamp"`
+	SchemaVersion int       `bson:"schema_version" json:"schema_version"`
+}
+
+// Meta returns the EventMeta of an Event.
+func (m EventMeta) Meta() EventMeta {
+	return m
+}
+
+// Event is a change on a User published to the other components.
+type Event interface {
+	Meta() EventMeta
+	//Subject returns the User after the change, without its password
+	Subject() User
+}
+
+// UserCreated is published once a User is created.
+type UserCreated struct {
+	EventMeta `bson:",inline"`
+	User      User `bson:"user" json:"user"`
+}
+
+// UserUpdated is published once a User is updated, patched or restored, with its changed fields.
+type UserUpdated struct {
+	EventMeta `bson:",inline"`
+	User      User          `bson:"user" json:"user"`
+	Changes   []FieldChange `bson:"changes" json:"changes"`
+}
+
+// UserDeleted is published once a User is deleted, with the deleted User.
+type UserDeleted struct {
+	EventMeta `bson:",inline"`
+	User      User `bson:"user" json:"user"`
+}
+
+func (e UserCreated) Subject() User { return e.User }
+func (e UserUpdated) Subject() User { return e.User }
+func (e UserDeleted) Subject() User { return e.User }
+
+func newEventMeta(eventType EventType) EventMeta {
+	return EventMeta{
+		ID:            primitive.NewObjectID().Hex(),
+		Type:          eventType,
+		Timestamp:     time.Now().Truncate(time.Millisecond),
+		SchemaVersion: EventSchemaVersion,
+	}
+}
+
+//eventUser returns the User without its password hash, the events leave the service
+func eventUser(u *User) User {
+	published := *u
+	published.Password = ""
+	published.Score = 0
+	return published
+}
+
+// NewUserCreated returns the event of the creation of the User.
+func NewUserCreated(u *User) UserCreated {
+	return UserCreated{EventMeta: newEventMeta(EventUserCreated), User: eventUser(u)}
+}
+
+// NewUserUpdated returns the event of the change of the User from before to after, the secrets redacted.
+func NewUserUpdated(before, after *User) UserUpdated {
+	return UserUpdated{EventMeta: newEventMeta(EventUserUpdated), User: eventUser(after), Changes: diffUsers(before, after)}
+}
+
+// NewUserDeleted returns the event of the deletion of the User.
+func NewUserDeleted(u *User) UserDeleted {
+	return UserDeleted{EventMeta: newEventMeta(EventUserDeleted), User: eventUser(u)}
+}
+
+//newChangeEvent returns the Event of the change recorded in the audit trail by the action, before is nil for a creation
+func newChangeEvent(action AuditAction, before, after *User) Event {
+	switch action {
+	case AuditCreate:
+		return NewUserCreated(after)
+	case AuditDelete:
+		return NewUserDeleted(after)
+	}
+	return NewUserUpdated(before, after)
+}
@@ -0,0 +1,133 @@
+package user
+
+import (
+	"sync"
+	"time"
+)
+
+//Implements the stream of the Events, with a replay buffer to resume it
+
+const (
+	DefaultEventReplay    = 1000             //Events kept to resume a stream
+	DefaultEventBuffer    = 64               //Events waiting for a subscriber before it is disconnected
+	DefaultEventHeartbeat = 15 * time.Second //time between two heartbeats of an idle stream
+)
+
+// EventStream is the Publisher keeping the last Events in a bounded replay buffer and sending them to its subscribers.
+// A subscriber which doesn't keep up is disconnected, it resumes from the last Event it received.
+// It is safe for concurrent use.
+type EventStream struct {
+	Buffer    int           //Events waiting for a subscriber before it is disconnected
+	Heartbeat time.Duration //time between two heartbeats of an idle stream
+
+	mu          sync.Mutex
+	size        int
+	replay      []Event         //the last Events, the oldest first
+	ids         map[string]bool //of the Events in the replay buffer
+	subscribers map[chan Event]bool
+	closed      bool
+}
+
+// NewEventStream returns an EventStream replaying up to size Events, with the default settings.
+func NewEventStream(size int) *EventStream {
+	return &EventStream{
+		Buffer:      DefaultEventBuffer,
+		Heartbeat:   DefaultEventHeartbeat,
+		size:        size,
+		ids:         make(map[string]bool),
+		subscribers: make(map[chan Event]bool),
+	}
+}
+
+// StreamSubscription receives the Events of an EventStream, from its subscription or after the last Event received.
+type StreamSubscription struct {
+	Replay []Event      //the buffered Events after the last one received, to send first
+	Gap    bool         //the last Event received is no longer buffered, some Events were missed
+	Events <-chan Event //closed when the subscriber doesn't keep up or the stream is closed
+
+	close func()
+}
+
+// Close stops the subscription.
+func (s *StreamSubscription) Close() {
+	s.close()
+}
+
+// Publish buffers the Event and sends it to the subscribers, an Event already buffered is ignored.
+func (s *EventStream) Publish(e Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := e.Meta().ID
+	//the relay publishes at least once
+	if s.ids[id] {
+		return nil
+	}
+	if s.size > 0 {
+		if len(s.replay) >= s.size {
+			delete(s.ids, s.replay[0].Meta().ID)
+			s.replay = append(s.replay[:0], s.replay[1:]...)
+		}
+		s.replay = append(s.replay, e)
+		s.ids[id] = true
+	}
+	for ch := range s.subscribers {
+		select {
+		case ch <- e:
+		default:
+			//too slow, it resumes from the replay buffer
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+	return nil
+}
+
+// Subscribe returns a subscription to the Events after lastEventID, or to the next ones when it is empty.
+// The replay and the next Events follow each other without gap nor duplicate.
+func (s *EventStream) Subscribe(lastEventID string) *StreamSubscription {
+	ch := make(chan Event, s.Buffer)
+	subscription := &StreamSubscription{Events: ch}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if lastEventID != "" {
+		//the whole buffer when the last Event received is no longer in it
+		start := 0
+		subscription.Gap = true
+		for i := range s.replay {
+			if s.replay[i].Meta().ID == lastEventID {
+				start, subscription.Gap = i+1, false
+				break
+			}
+		}
+		subscription.Replay = append([]Event{}, s.replay[start:]...)
+	}
+	if s.closed {
+		close(ch)
+		subscription.close = func() {}
+		return subscription
+	}
+	s.subscribers[ch] = true
+
+	subscription.close = func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		//already closed when disconnected
+		if s.subscribers[ch] {
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+	return subscription
+}
+
+// Close disconnects every subscriber, and the next ones at once, so a server can shut down.
+func (s *EventStream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for ch := range s.subscribers {
+		delete(s.subscribers, ch)
+		close(ch)
+	}
+}
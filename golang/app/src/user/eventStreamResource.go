@@ -0,0 +1,208 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi"
+	"net/http"
+	"net/url"
+	"strings"
+	errors2 "test/errors"
+	"test/utils"
+	"time"
+)
+
+//Implements the Server-Sent Events stream of the changes of the Users
+
+// EventStreamReset is sent instead of the Events missed by a resumed stream, the Users have to be reloaded.
+const EventStreamReset = "stream.reset"
+
+var (
+	ErrParamEventType  = errors2.BadRequest(errors.New("type can only be user.created, user.updated or user.deleted"))
+	ErrStreamingFailed = errors.New("the response can't be streamed")
+)
+
+// EventStreamResource streams the Events of an EventStream to the clients, as Server-Sent Events.
+type EventStreamResource struct {
+	Stream *EventStream
+	Policy Policy
+}
+
+// NewEventStreamResource creates and returns a resource streaming the Events of the stream, the access is decided by the Policy.
+func NewEventStreamResource(stream *EventStream, policy Policy) *EventStreamResource {
+	return &EventStreamResource{
+		Stream: stream,
+		Policy: policy,
+	}
+}
+
+// Router for the stream of the Events, it lasts longer than a request timeout
+func (rs *EventStreamResource) Router() *chi.Mux {
+	r := chi.NewRouter()
+	r.Get("/", rs.stream)
+	return r
+}
+
+//eventStreamFilter selects the Events of a stream, an empty set selects them all
+type eventStreamFilter struct {
+	countries map[string]bool
+	types     map[EventType]bool
+}
+
+//decodeEventStreamFilter reads the filter from the parameters country and type, each a comma separated list
+func decodeEventStreamFilter(query url.Values) (eventStreamFilter, error) {
+	filter := eventStreamFilter{countries: map[string]bool{}, types: map[EventType]bool{}}
+	for _, country := range strings.Split(query.Get("country"), ",") {
+		if country = NormalizeString(country); country != "" {
+			filter.countries[country] = true
+		}
+	}
+	for _, eventType := range strings.Split(query.Get("type"), ",") {
+		if eventType = strings.TrimSpace(eventType); eventType == "" {
+			continue
+		}
+		if !knownEventType(EventType(eventType)) {
+			return eventStreamFilter{}, ErrParamEventType
+		}
+		filter.types[EventType(eventType)] = true
+	}
+	return filter, nil
+}
+
+//matches reports if the Event is selected, a User leaving a country is still seen from it
+func (f eventStreamFilter) matches(e Event) bool {
+	if len(f.types) > 0 && !f.types[e.Meta().Type] {
+		return false
+	}
+	if len(f.countries) == 0 || f.countries[e.Subject().Country] {
+		return true
+	}
+	if updated, ok := e.(UserUpdated); ok {
+		for _, change := range updated.Changes {
+			if change.Field == "country" && f.countries[change.From] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//redactEvent returns the Event without the fields removed by User.Redact
+func redactEvent(e Event) Event {
+	switch redacted := e.(type) {
+	case UserCreated:
+		redacted.User.Redact()
+		return redacted
+	case UserUpdated:
+		redacted.User.Redact()
+		redacted.Changes = redactChanges(redacted.Changes)
+		return redacted
+	case UserDeleted:
+		redacted.User.Redact()
+		return redacted
+	}
+	return e
+}
+
+//writeEvent writes the Event as a Server-Sent Event, its id resumes the stream
+func writeEvent(w http.ResponseWriter, e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", e.Meta().ID, e.Meta().Type, data)
+	return err
+}
+
+// Streams the Events of the Users the principal can list, from the Last-Event-ID header or last_event_id parameter when resumed.
+// A browser EventSource can't set the Authorization header, it authenticates with the access_token parameter.
+// The stream ends when the credentials of the principal expire, or at the first heartbeat once they are no longer valid
+// (revoked, the User deleted or its role changed): the client reconnects with a new access token
+func (rs *EventStreamResource) stream(w http.ResponseWriter, r *http.Request) {
+	principal, _ := PrincipalFromContext(r.Context())
+	permission, err := rs.Policy.Authorize(principal, ActionList)
+	if err != nil {
+		utils.RenderForbidden(w, r, err)
+		return
+	}
+	filter, err := decodeEventStreamFilter(r.URL.Query())
+	if err != nil {
+		utils.Render(w, r, err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		utils.Render(w, r, ErrStreamingFailed)
+		return
+	}
+
+	//an EventSource sends the header when it reconnects, the parameter resumes a new one
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	subscription := rs.Stream.Subscribe(lastEventID)
+	defer subscription.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	//the proxies must not buffer the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(e Event) error {
+		if !filter.matches(e) || !permission.Allows(principal, e.Subject().ID) {
+			return nil
+		}
+		if permission.Redacted {
+			e = redactEvent(e)
+		}
+		return writeEvent(w, e)
+	}
+	if subscription.Gap {
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: {}\n\n", EventStreamReset); err != nil {
+			return
+		}
+	}
+	for _, e := range subscription.Replay {
+		if err := send(e); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(rs.Stream.Heartbeat)
+	defer heartbeat.Stop()
+	var expired <-chan time.Time
+	if principal != nil && !principal.Expires.IsZero() {
+		expiry := time.NewTimer(time.Until(principal.Expires))
+		defer expiry.Stop()
+		expired = expiry.C
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-expired:
+			return
+		case e, ok := <-subscription.Events:
+			//disconnected, the client resumes from its last Event
+			if !ok {
+				return
+			}
+			if err := send(e); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if principal != nil && principal.Check != nil && principal.Check() != nil {
+				return
+			}
+			//a comment, ignored by the clients, keeps the connection open through the proxies
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,155 @@
+package user
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+//eventIDs returns the ids of the Events, in their order
+func eventIDs(events []Event) []string {
+	ids := []string{}
+	for _, e := range events {
+		ids = append(ids, e.Meta().ID)
+	}
+	return ids
+}
+
+func TestEventStream(t *testing.T) {
+	stream := NewEventStream(3)
+	stream.Buffer = 2
+	var events []Event
+	for i := 0; i < 4; i++ {
+		e := NewUserCreated(&User{ID: "61e41ed578752c5997718aff"})
+		events = append(events, e)
+		if err := stream.Publish(e); err != nil {
+			t.Fatalf("EventStream.Publish output err %v", err)
+		}
+	}
+	//published again by the relay, it is not buffered twice
+	stream.Publish(events[3])
+
+	for _, item := range []struct {
+		lastEventID string
+		expected    []Event
+		gap         bool
+	}{
+		{lastEventID: "", expected: nil},
+		{lastEventID: events[1].Meta().ID, expected: events[2:]},
+		{lastEventID: events[3].Meta().ID, expected: []Event{}},
+		//the first Event is no longer buffered
+		{lastEventID: events[0].Meta().ID, expected: events[1:], gap: true},
+		{lastEventID: "61e41ed578752c5997718aff", expected: events[1:], gap: true},
+	} {
+		subscription := stream.Subscribe(item.lastEventID)
+		if !reflect.DeepEqual(eventIDs(subscription.Replay), eventIDs(item.expected)) || subscription.Gap != item.gap {
+			t.Errorf("EventStream.Subscribe after %v output %v with gap %v but expected %v with gap %v",
+				item.lastEventID, eventIDs(subscription.Replay), subscription.Gap, eventIDs(item.expected), item.gap)
+		}
+		subscription.Close()
+	}
+
+	//the next Events follow, a subscriber which doesn't keep up is disconnected
+	subscription := stream.Subscribe("")
+	slow := stream.Subscribe("")
+	defer subscription.Close()
+	defer slow.Close()
+	for i := 0; i < 3; i++ {
+		e := NewUserDeleted(&User{ID: "61e41ed578752c5997718aff"})
+		stream.Publish(e)
+		if received := <-subscription.Events; received.Meta().ID != e.Meta().ID {
+			t.Errorf("EventStream subscriber received %v but expected %v", received.Meta().ID, e.Meta().ID)
+		}
+	}
+	received := 0
+	for range slow.Events {
+		received++
+	}
+	if received != stream.Buffer {
+		t.Errorf("EventStream slow subscriber received %v events before its disconnection but expected %v", received, stream.Buffer)
+	}
+
+	//a closed stream disconnects its subscribers, and the next ones at once
+	stream.Close()
+	if _, ok := <-subscription.Events; ok {
+		t.Errorf("EventStream.Close kept a subscriber")
+	}
+	if _, ok := <-stream.Subscribe("").Events; ok {
+		t.Errorf("EventStream.Subscribe after the close output an open subscription")
+	}
+}
+
+func TestEventStreamFilter(t *testing.T) {
+	french := &User{ID: "61e41ed578752c5997718aff", Country: "FR", Email: "fr@email.com"}
+	moved := &User{ID: "61e41ed578752c5997718aff", Country: "US", Email: "us@email.com"}
+	created, updated, deleted := NewUserCreated(french), NewUserUpdated(french, moved), NewUserDeleted(moved)
+
+	for _, item := range []struct {
+		query    string
+		expected []Event
+		err      error
+	}{
+		{query: "", expected: []Event{created, updated, deleted}},
+		{query: "type=user.created,user.deleted", expected: []Event{created, deleted}},
+		//a User leaving a country is still seen from it
+		{query: "country=FR", expected: []Event{created, updated}},
+		{query: "country=US&type=user.updated", expected: []Event{updated}},
+		{query: "country=DE", expected: []Event{}},
+		{query: "type=user.renamed", err: ErrParamEventType},
+	} {
+		query, _ := url.ParseQuery(item.query)
+		filter, err := decodeEventStreamFilter(query)
+		if err != item.err {
+			t.Errorf("decodeEventStreamFilter of %v output err %v but expected %v", item.query, err, item.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		matched := []Event{}
+		for _, e := range []Event{created, updated, deleted} {
+			if filter.matches(e) {
+				matched = append(matched, e)
+			}
+		}
+		if !reflect.DeepEqual(eventIDs(matched), eventIDs(item.expected)) {
+			t.Errorf("eventStreamFilter of %v matched %v but expected %v", item.query, eventIDs(matched), eventIDs(item.expected))
+		}
+	}
+
+	//the redacted Events keep the email changed, without its values
+	redacted := redactEvent(updated).(UserUpdated)
+	if redacted.User.Email != "" || updated.User.Email != "us@email.com" {
+		t.Errorf("redactEvent output the user %+v", redacted.User)
+	}
+	for i, change := range redacted.Changes {
+		if change.Field == "email" && (change.From != RedactedValue || change.To != RedactedValue || updated.Changes[i].From != "fr@email.com") {
+			t.Errorf("redactEvent output the change %+v", change)
+		}
+	}
+}
+
+func TestEventStreamExpiry(t *testing.T) {
+	stream := NewEventStream(DefaultEventReplay)
+	resource := NewEventStreamResource(stream, RolePolicy{})
+	//the credentials expire before the first heartbeat
+	principal := &Principal{ID: "61e41ed578752c5997718aff", Role: RoleAdmin, Expires: time.Now().Add(100 * time.Millisecond)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resource.stream(w, r.WithContext(NewPrincipalContext(r.Context(), principal)))
+	}))
+	defer server.Close()
+
+	start := time.Now()
+	resp, err := (&http.Client{Timeout: 5 * time.Second}).Get(server.URL)
+	if err != nil {
+		t.Fatalf("GET of the event stream output err %v", err)
+	}
+	defer resp.Body.Close()
+	if _, err := ioutil.ReadAll(resp.Body); err != nil || time.Since(start) > stream.Heartbeat {
+		t.Errorf("event stream ended after %v with err %v", time.Since(start), err)
+	}
+}
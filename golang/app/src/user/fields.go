@@ -0,0 +1,111 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	errors2 "test/errors"
+)
+
+//Implements the sparse fieldsets of the User responses
+
+func ErrSelectField(field string) error {
+	return errors2.BadRequest(errors.New("field " + field + " can't be selected, only " + strings.Join(SelectableFields, ", ")))
+}
+
+// SelectableFields are the json names of the fields a response can be restricted to.
+var SelectableFields = []string{"id", "first_name", "last_name", "nickname", "email", "country", "role", "created_at", "updated_at", "deleted_at", "version"}
+
+// ParseFields reads a list of fields like "id,first_name,email".
+func ParseFields(value string) ([]string, error) {
+	var fields []string
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if !isSelectable(field) {
+			return nil, ErrSelectField(field)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+func isSelectable(field string) bool {
+	for _, selectable := range SelectableFields {
+		if field == selectable {
+			return true
+		}
+	}
+	return false
+}
+
+//projectionFields returns the fields a List has to read: the selected ones and those of the sort, nil for every field
+func (opts ListOptions) projectionFields() []string {
+	if len(opts.Fields) == 0 {
+		return nil
+	}
+	fields := append([]string{"id"}, opts.Fields...)
+	for _, sortField := range opts.sort() {
+		fields = append(fields, sortField.Field)
+	}
+	return fields
+}
+
+//project keeps only the fields of the User, as a mongo projection does
+func project(u User, fields []string) User {
+	if fields == nil {
+		return u
+	}
+	projected := User{}
+	for _, field := range fields {
+		switch field {
+		case "id":
+			projected.ID = u.ID
+		case "first_name":
+			projected.FirstName = u.FirstName
+		case "last_name":
+			projected.LastName = u.LastName
+		case "nickname":
+			projected.Nickname = u.Nickname
+		case "email":
+			projected.Email = u.Email
+		case "country":
+			projected.Country = u.Country
+		case "role":
+			projected.Role = u.Role
+		case "created_at":
+			projected.CreatedAt = u.CreatedAt
+		case "updated_at":
+			projected.UpdatedAt = u.UpdatedAt
+		case "deleted_at":
+			projected.DeletedAt = u.DeletedAt
+		case "version":
+			projected.Version = u.Version
+		}
+	}
+	projected.Score = u.Score
+	return projected
+}
+
+// userFields marshals only the Fields of the User, every field when empty, and the score of a search.
+type userFields struct {
+	*User
+	Fields []string
+}
+
+func (uf userFields) MarshalJSON() ([]byte, error) {
+	b, err := json.Marshal(uf.User)
+	if err != nil || len(uf.Fields) == 0 {
+		return b, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(b, &all); err != nil {
+		return nil, err
+	}
+	selected := make(map[string]json.RawMessage, len(uf.Fields)+1)
+	for _, field := range append(uf.Fields, "score") {
+		if value, ok := all[field]; ok {
+			selected[field] = value
+		}
+	}
+	return json.Marshal(selected)
+}
@@ -0,0 +1,158 @@
+package user
+
+import (
+	"sort"
+	"strings"
+)
+
+//Implements the fuzzy lookup of the Users, tolerant to typos in their names
+
+// FuzzyFields are the fields compared by a fuzzy lookup, with the full name.
+var FuzzyFields = []string{"first_name", "last_name", "nickname"}
+
+// FuzzyOptions sets which Users a fuzzy lookup returns.
+// Only the Users sharing trigrams of their names with the value are compared, those sharing the most first.
+type FuzzyOptions struct {
+	Threshold  float64 //lowest similarity of a User, between 0 and 1
+	Limit      int     //most similar Users returned at most, 0 means no limit
+	Candidates int     //Users compared at most, 0 means no limit
+}
+
+// DefaultFuzzy tolerates about one typo every three characters, and returns the 20 most similar Users
+// of the 1000 sharing the most trigrams with the value.
+var DefaultFuzzy = FuzzyOptions{Threshold: 0.6, Limit: 20, Candidates: 1000}
+
+//trigrams returns the distinct trigrams of the words of the values in lower case, sorted. As in pg_trgm, each word is
+//padded with two spaces before and one after, so a short word or a typo after the first letter still shares some.
+func trigrams(values ...string) []string {
+	set := map[string]bool{}
+	for _, value := range values {
+		for _, word := range strings.Fields(strings.ToLower(value)) {
+			runes := []rune("  " + word + " ")
+			for i := 0; i+3 <= len(runes); i++ {
+				set[string(runes[i:i+3])] = true
+			}
+		}
+	}
+	grams := make([]string, 0, len(set))
+	for gram := range set {
+		grams = append(grams, gram)
+	}
+	sort.Strings(grams)
+	return grams
+}
+
+//fuzzyGrams returns the trigrams of the FuzzyFields of the User, saved with it to select the candidates of a lookup
+func fuzzyGrams(u *User) []string {
+	values := make([]string, 0, len(FuzzyFields))
+	for _, field := range FuzzyFields {
+		values = append(values, fieldValues[field](u))
+	}
+	return trigrams(values...)
+}
+
+//preselect keeps the candidates sharing trigrams with the value, up to Candidates of those sharing the most,
+//as the UsersStore does in the database
+func (o FuzzyOptions) preselect(candidates []User, value string) []User {
+	grams := map[string]bool{}
+	for _, gram := range trigrams(value) {
+		grams[gram] = true
+	}
+	shared := map[string]int{}
+	var selected []User
+	for _, u := range candidates {
+		for _, gram := range fuzzyGrams(&u) {
+			if grams[gram] {
+				shared[u.ID]++
+			}
+		}
+		if shared[u.ID] > 0 {
+			selected = append(selected, u)
+		}
+	}
+	sort.Slice(selected, func(i, j int) bool {
+		if shared[selected[i].ID] != shared[selected[j].ID] {
+			return shared[selected[i].ID] > shared[selected[j].ID]
+		}
+		return selected[i].ID < selected[j].ID
+	})
+	if o.Candidates > 0 && len(selected) > o.Candidates {
+		selected = selected[:o.Candidates]
+	}
+	return selected
+}
+
+//rank scores the candidates by their similarity to the value, and keeps the most similar ones reaching the threshold
+func (o FuzzyOptions) rank(candidates []User, value string) []User {
+	var ranked []User
+	for _, u := range candidates {
+		u.Score = fuzzyScore(&u, value)
+		if u.Score > 0 && u.Score >= o.Threshold {
+			ranked = append(ranked, u)
+		}
+	}
+	sort.Slice(ranked, func(i, j int) bool { return RelevanceSort.less(&ranked[i], &ranked[j]) })
+	if o.Limit > 0 && len(ranked) > o.Limit {
+		ranked = ranked[:o.Limit]
+	}
+	return ranked
+}
+
+//fuzzyScore returns the best similarity of the value to the FuzzyFields or to the full name of the User
+func fuzzyScore(u *User, value string) float64 {
+	best := similarity(u.FirstName+" "+u.LastName, value)
+	for _, field := range FuzzyFields {
+		if score := similarity(fieldValues[field](u), value); score > best {
+			best = score
+		}
+	}
+	return best
+}
+
+//similarity is 1 for the same words ignoring the case, down to 0 with their edit distance
+func similarity(a, b string) float64 {
+	runesA := []rune(strings.ToLower(strings.Join(strings.Fields(a), " ")))
+	runesB := []rune(strings.ToLower(strings.Join(strings.Fields(b), " ")))
+	longest := len(runesA)
+	if len(runesB) > longest {
+		longest = len(runesB)
+	}
+	if longest == 0 {
+		return 0
+	}
+	return 1 - float64(editDistance(runesA, runesB))/float64(longest)
+}
+
+//editDistance counts the insertions, deletions, substitutions and transpositions of two adjacent characters
+//turning a into b (optimal string alignment)
+func editDistance(a, b []rune) int {
+	//the rows i-2, i-1 and i of the distances between the prefixes
+	previous2 := make([]int, len(b)+1)
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(minInt(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				current[j] = minInt(current[j], previous2[j-2]+1)
+			}
+		}
+		previous2, previous, current = previous, current, previous2
+	}
+	return previous[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,77 @@
+package user
+
+import (
+	"reflect"
+	"testing"
+)
+
+type similarityTest struct {
+	a                  string
+	b                  string
+	expectedDistance   int
+	expectedSimilarity float64
+}
+
+func TestSimilarity(t *testing.T) {
+	similarityTests := []similarityTest{
+		{a: "John", b: "John", expectedDistance: 0, expectedSimilarity: 1},
+		//test the case and the spaces are ignored
+		{a: "john  smith", b: " John Smith", expectedDistance: 4, expectedSimilarity: 1},
+		//test a transposition is one edit
+		{a: "John", b: "Jonh", expectedDistance: 1, expectedSimilarity: 0.75},
+		{a: "Smith", b: "Smyth", expectedDistance: 1, expectedSimilarity: 0.8},
+		{a: "Jon", b: "John", expectedDistance: 1, expectedSimilarity: 0.75},
+		{a: "José", b: "Jose", expectedDistance: 1, expectedSimilarity: 0.75},
+		{a: "Bob", b: "Alice", expectedDistance: 5, expectedSimilarity: 0},
+		{a: "", b: "", expectedDistance: 0, expectedSimilarity: 0},
+	}
+
+	for _, item := range similarityTests {
+		result := editDistance([]rune(item.a), []rune(item.b))
+		if result != item.expectedDistance {
+			t.Errorf("editDistance for %q and %q output %v but expected %v", item.a, item.b, result, item.expectedDistance)
+		}
+		resultSimilarity := similarity(item.a, item.b)
+		if resultSimilarity != item.expectedSimilarity {
+			t.Errorf("similarity for %q and %q output %v but expected %v", item.a, item.b, resultSimilarity, item.expectedSimilarity)
+		}
+	}
+}
+
+func TestTrigrams(t *testing.T) {
+	result := trigrams("Jo  JO", "Al")
+	expected := []string{"  a", "  j", " al", " jo", "al ", "jo "}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("trigrams output %q but expected %q", result, expected)
+	}
+	if result := trigrams(" "); len(result) != 0 {
+		t.Errorf("trigrams of no word output %q", result)
+	}
+}
+
+func TestPreselect(t *testing.T) {
+	candidates := []User{
+		{ID: "1", FirstName: "Alice", LastName: "Smith"},
+		{ID: "2", FirstName: "Bob", LastName: "Brown"},
+		{ID: "3", FirstName: "John", LastName: "Smith"},
+		{ID: "4", FirstName: "Jon", LastName: "Smyth"},
+	}
+	preselectTests := []struct {
+		candidates  int
+		expectedIDs []string
+	}{
+		//test the Users sharing no trigram are left out, those sharing the most come first then by id
+		{candidates: 0, expectedIDs: []string{"3", "1", "4"}},
+		{candidates: 2, expectedIDs: []string{"3", "1"}},
+	}
+	for _, item := range preselectTests {
+		result := FuzzyOptions{Candidates: item.candidates}.preselect(candidates, "Jonh Smith")
+		var resultIDs []string
+		for _, u := range result {
+			resultIDs = append(resultIDs, u.ID)
+		}
+		if !reflect.DeepEqual(resultIDs, item.expectedIDs) {
+			t.Errorf("preselect with %v candidates output %v but expected %v", item.candidates, resultIDs, item.expectedIDs)
+		}
+	}
+}
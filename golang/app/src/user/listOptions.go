@@ -0,0 +1,221 @@
+package user
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+	errors2 "test/errors"
+	"time"
+)
+
+//Implements the sort and the pagination of the Users list
+
+const (
+	//same precision as a mongo date, sortable as a string in UTC
+	sortTimeFormat = "2006-01-02T15:04:05.000Z"
+)
+
+var (
+	ErrCursor      = errors2.BadRequest(errors.New("invalid cursor, or used with another sort"))
+	ErrCursorScore = errors2.BadRequest(errors.New("cursor can't be used with the sort on score, only page"))
+	ErrSortScore   = errors2.BadRequest(errors.New("sort on score is only descending, with a search q or a fuzzy text"))
+)
+
+func ErrSortField(field string) error {
+	return errors2.BadRequest(errors.New("sort on " + field + " not supported, only on " + strings.Join(SortableFields, ", ")))
+}
+
+// SortableFields are the json names of the fields a List can be sorted on, the score only descending with a search.
+var SortableFields = []string{"first_name", "last_name", "nickname", "email", "country", "created_at", "updated_at", "score"}
+
+//sortValues returns the value of each sortable field, as compared by the sort
+var sortValues = map[string]func(u *User) string{
+	"first_name": func(u *User) string { return u.FirstName },
+	"last_name":  func(u *User) string { return u.LastName },
+	"nickname":   func(u *User) string { return u.Nickname },
+	"email":      func(u *User) string { return u.Email },
+	"country":    func(u *User) string { return u.Country },
+	"created_at": func(u *User) string { return u.CreatedAt.UTC().Format(sortTimeFormat) },
+	"updated_at": func(u *User) string { return u.UpdatedAt.UTC().Format(sortTimeFormat) },
+	//positive, its fixed width keeps the string order
+	"score": func(u *User) string { return fmt.Sprintf("%020.6f", u.Score) },
+}
+
+// SortField is a field of a Sort, ascending unless Desc.
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// Sort orders a List by its fields, then by id in the direction of the last field.
+type Sort []SortField
+
+// DefaultSort lists the most recent Users first.
+var DefaultSort = Sort{{Field: "created_at", Desc: true}}
+
+// ParseSort reads a sort like "last_name,-updated_at", a "-" prefix sorts the field descending.
+func ParseSort(value string) (Sort, error) {
+	var s Sort
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		sortField := SortField{Field: strings.TrimPrefix(field, "-"), Desc: strings.HasPrefix(field, "-")}
+		if _, ok := sortValues[sortField.Field]; !ok {
+			return nil, ErrSortField(field)
+		}
+		if sortField.Field == "score" && !sortField.Desc {
+			return nil, ErrSortScore
+		}
+		s = append(s, sortField)
+	}
+	return s, nil
+}
+
+func (s Sort) String() string {
+	fields := make([]string, 0, len(s))
+	for _, sortField := range s {
+		if sortField.Desc {
+			fields = append(fields, "-"+sortField.Field)
+		} else {
+			fields = append(fields, sortField.Field)
+		}
+	}
+	return strings.Join(fields, ",")
+}
+
+//hasScore reports if the Sort is on the relevance of a search
+func (s Sort) hasScore() bool {
+	for _, sortField := range s {
+		if sortField.Field == "score" {
+			return true
+		}
+	}
+	return false
+}
+
+//idDesc reports the direction of the id tiebreaker
+func (s Sort) idDesc() bool {
+	return s[len(s)-1].Desc
+}
+
+//less reports if the User a comes before b
+func (s Sort) less(a, b *User) bool {
+	for _, sortField := range s {
+		valueA, valueB := sortValues[sortField.Field](a), sortValues[sortField.Field](b)
+		if valueA != valueB {
+			return (valueA < valueB) != sortField.Desc
+		}
+	}
+	return (a.ID < b.ID) != s.idDesc()
+}
+
+// ListOptions sets the order, which page of the filtered Users and which of their fields a List returns.
+type ListOptions struct {
+	Sort           Sort     //DefaultSort when empty
+	Page           int64    //starting at 0, ignored with a Cursor
+	PageSize       int64    //0 means no limit
+	Cursor         *Cursor  //seeks after this position instead of skipping the previous pages
+	Fields         []string //json names of the fields to read, every field when empty
+	WithScore      bool     //returns the relevance score of a search
+	IncludeDeleted bool     //returns the soft deleted Users too
+}
+
+//sort returns the Sort to apply
+func (opts ListOptions) sort() Sort {
+	if len(opts.Sort) == 0 {
+		return DefaultSort
+	}
+	return opts.Sort
+}
+
+// Cursor is the position of a User in the list: its values of the sort fields, then its id.
+type Cursor struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v"`
+	ID     string   `json:"i"`
+}
+
+// NewCursor returns the position of the User in the list ordered by s.
+func NewCursor(u *User, s Sort) *Cursor {
+	c := &Cursor{Sort: s.String(), ID: u.ID}
+	for _, sortField := range s {
+		c.Values = append(c.Values, sortValues[sortField.Field](u))
+	}
+	return c
+}
+
+// Encode returns the opaque form of the Cursor, used as the cursor query parameter.
+func (c *Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor reads an encoded Cursor, made for the Sort s.
+func DecodeCursor(value string, s Sort) (*Cursor, error) {
+	if s.hasScore() {
+		return nil, ErrCursorScore
+	}
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrCursor
+	}
+	var c Cursor
+	if err := json.Unmarshal(b, &c); err != nil || c.Sort != s.String() || len(c.Values) != len(s) {
+		return nil, ErrCursor
+	}
+	for i, sortField := range s {
+		if isTimeField(sortField.Field) {
+			if _, err := time.Parse(sortTimeFormat, c.Values[i]); err != nil {
+				return nil, ErrCursor
+			}
+		}
+	}
+	if _, err := primitive.ObjectIDFromHex(c.ID); err != nil {
+		return nil, ErrCursor
+	}
+	return &c, nil
+}
+
+//isAfter reports if the User comes after the Cursor in the list ordered by s
+func (c *Cursor) isAfter(u *User, s Sort) bool {
+	for i, sortField := range s {
+		value := sortValues[sortField.Field](u)
+		if value != c.Values[i] {
+			return (value > c.Values[i]) != sortField.Desc
+		}
+	}
+	return u.ID != c.ID && (u.ID > c.ID) != s.idDesc()
+}
+
+func isTimeField(field string) bool {
+	return field == "created_at" || field == "updated_at"
+}
+
+// UsersPage is a page of the Users list.
+type UsersPage struct {
+	Users      []User
+	Total      int    //number of Users matching the filters, whatever the page
+	HasNext    bool   //more Users follow this page
+	NextCursor string //position of the last User of the page, when HasNext
+}
+
+//newUsersPage trims the Users fetched with one extra to know if a next page exists, and their score unless asked
+func newUsersPage(uList []User, total int, opts ListOptions) *UsersPage {
+	page := &UsersPage{Users: uList, Total: total}
+	if opts.PageSize > 0 && int64(len(uList)) > opts.PageSize {
+		page.Users = uList[:opts.PageSize]
+		page.HasNext = true
+		//no cursor on the score
+		if !opts.sort().hasScore() {
+			page.NextCursor = NewCursor(&page.Users[opts.PageSize-1], opts.sort()).Encode()
+		}
+	}
+	if !opts.WithScore {
+		for i := range page.Users {
+			page.Users[i].Score = 0
+		}
+	}
+	return page
+}
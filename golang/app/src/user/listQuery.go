@@ -0,0 +1,233 @@
+package user
+
+import (
+	"errors"
+	"golang.org/x/text/unicode/norm"
+	"net/url"
+	"sort"
+	"strings"
+	errors2 "test/errors"
+	"test/utils"
+)
+
+//Implements the decoding of the Users list query parameters
+
+var (
+	ErrParamDate    = errors2.BadRequest(errors.New("Date format error"))
+	ErrParamPage    = errors2.BadRequest(errors.New("page and page_size can't be negative"))
+	ErrParamMode    = errors2.BadRequest(errors.New("mode can only be regex, prefix, exact or fuzzy"))
+	ErrParamOptions = errors2.BadRequest(errors.New("options can only be i"))
+)
+
+//optionParameters are the query parameters of the ListOptions and of the text matching, the other ones are filters
+var optionParameters = map[string]bool{"page": true, "page_size": true, "sort": true, "cursor": true, "fields": true, "mode": true, "options": true, "q": true, "with_score": true, "include_deleted": true}
+
+//searchModes are the operators of the text filters without one, selected by the mode parameter
+var searchModes = map[string]Operator{"": OpContains, "regex": OpRegex, "prefix": OpPrefix, "exact": OpEq}
+
+//modeFuzzy looks up the text parameter by similarity to the names, the other text filters contain their value
+const modeFuzzy = "fuzzy"
+
+//legacyDateParameters are the first date filters, each one a bound of a range
+var legacyDateParameters = map[string]struct {
+	field string
+	end   bool
+}{
+	"startdcreated": {"created_at", false},
+	"enddcreated":   {"created_at", true},
+	"startdupdated": {"updated_at", false},
+	"enddupdated":   {"updated_at", true},
+}
+
+// DecodeListQuery reads the filter and the options of a List from the query parameters.
+// A filter is a field with its operator between brackets, like first_name[prefix]=Jo or country[in]=FR,US.
+// Without operator the text fields contain the value, or match it as selected by the mode, the other fields are equal.
+// With the options i, the text filters are case insensitive.
+// The q parameter is a full text search, and the text parameter with the mode fuzzy a lookup tolerant to typos,
+// their Users are ordered by relevance unless another sort is given.
+func DecodeListQuery(query url.Values) (UserFilter, ListOptions, error) {
+	filter, err := decodeFilter(query)
+	if err != nil {
+		return UserFilter{}, ListOptions{}, err
+	}
+	opts, err := decodeListOptions(query, filter)
+	if err != nil {
+		return UserFilter{}, ListOptions{}, err
+	}
+	return filter, opts, nil
+}
+
+//revealsRedacted reports if the filter or the sort of a List use one of the RedactedFields, directly or through
+//the TextField and the full text search which include them
+func revealsRedacted(f UserFilter, opts ListOptions) bool {
+	redacted := map[string]bool{}
+	for _, field := range RedactedFields {
+		redacted[field] = true
+		if isTextField(field) {
+			redacted[TextField] = true
+		}
+		if _, ok := SearchWeights[field]; ok && f.Search != "" {
+			return true
+		}
+	}
+	for _, c := range f.Conditions {
+		if redacted[c.Field] {
+			return true
+		}
+	}
+	for _, sortField := range opts.Sort {
+		if redacted[sortField.Field] {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeFilter(query url.Values) (UserFilter, error) {
+	//in a stable order
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	mode := query.Get("mode")
+	fuzzy := mode == modeFuzzy
+	if fuzzy {
+		mode = ""
+	}
+	textOp, ok := searchModes[mode]
+	if !ok {
+		return UserFilter{}, ErrParamMode
+	}
+	var caseInsensitive bool
+	switch query.Get("options") {
+	case "":
+	case "i":
+		caseInsensitive = true
+	default:
+		return UserFilter{}, ErrParamOptions
+	}
+
+	var filter UserFilter
+	if q := strings.TrimSpace(query.Get("q")); q != "" {
+		filter = filter.Matching(norm.NFC.String(q))
+	}
+	for _, key := range keys {
+		if optionParameters[key] {
+			continue
+		}
+		if legacy, ok := legacyDateParameters[key]; ok {
+			if value := query.Get(key); value != "" {
+				if legacy.end {
+					filter = filter.Where(legacy.field, OpRange, "", value)
+				} else {
+					filter = filter.Where(legacy.field, OpRange, value, "")
+				}
+			}
+			continue
+		}
+		if fuzzy && key == TextField {
+			for _, value := range query[key] {
+				if value = strings.TrimSpace(value); value != "" {
+					filter = filter.Resembling(norm.NFC.String(value))
+				}
+			}
+			continue
+		}
+
+		field, op := key, Operator("")
+		if i := strings.Index(key, "["); i > 0 && strings.HasSuffix(key, "]") {
+			field, op = key[:i], Operator(key[i+1:len(key)-1])
+		}
+		if _, ok := fieldOperators[field]; !ok {
+			//the other parameters are ignored, unless they look like a filter
+			if op != "" {
+				return UserFilter{}, ErrFilterOperator(field, op)
+			}
+			continue
+		}
+		if op == "" {
+			op = OpEq
+			if isTextField(field) {
+				op = textOp
+			}
+		}
+		for _, value := range query[key] {
+			if value == "" {
+				continue
+			}
+			values := []string{value}
+			if op == OpIn || op == OpRange {
+				values = strings.Split(value, ",")
+			}
+			for i := range values {
+				values[i] = normalizeFilterValue(field, values[i])
+			}
+			if caseInsensitive && isTextField(field) && op != OpRange {
+				filter = filter.WhereFold(field, op, values...)
+			} else {
+				filter = filter.Where(field, op, values...)
+			}
+		}
+	}
+	return filter, filter.Validate()
+}
+
+//normalizeFilterValue puts the value of a text field in the Unicode form of the saved User fields, see User.Normalize
+func normalizeFilterValue(field string, value string) string {
+	if !isTextField(field) {
+		return value
+	}
+	return norm.NFC.String(value)
+}
+
+func decodeListOptions(query url.Values, filter UserFilter) (ListOptions, error) {
+	//get page (number) and page_size
+	page, err := utils.Int64FromQuery("page", query)
+	if err != nil {
+		return ListOptions{}, errors2.BadRequest(err)
+	}
+	pageSize, err := utils.Int64FromQuery("page_size", query)
+	if err != nil {
+		return ListOptions{}, errors2.BadRequest(err)
+	}
+	if page < 0 || pageSize < 0 {
+		return ListOptions{}, ErrParamPage
+	}
+	opts := ListOptions{
+		Page:           page,
+		PageSize:       pageSize,
+		WithScore:      query.Get("with_score") == "true",
+		IncludeDeleted: query.Get("include_deleted") == "true",
+	}
+	if sortQuery := query.Get("sort"); sortQuery != "" {
+		opts.Sort, err = ParseSort(sortQuery)
+		if err != nil {
+			return ListOptions{}, err
+		}
+	} else if filter.Search != "" || filter.Fuzzy != "" {
+		opts.Sort = RelevanceSort
+	}
+	//the cursor replaces the page
+	if cursor := query.Get("cursor"); cursor != "" {
+		opts.Cursor, err = DecodeCursor(cursor, opts.sort())
+		if err != nil {
+			return ListOptions{}, err
+		}
+		opts.Page = 0
+	}
+	opts.Fields, err = fieldsFromQuery(query)
+	if err != nil {
+		return ListOptions{}, err
+	}
+	return opts, nil
+}
+
+//fieldsFromQuery returns the fields selected by the fields parameter, nil for every field
+func fieldsFromQuery(query url.Values) ([]string, error) {
+	if fieldsQuery := query.Get("fields"); fieldsQuery != "" {
+		return ParseFields(fieldsQuery)
+	}
+	return nil, nil
+}
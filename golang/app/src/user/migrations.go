@@ -0,0 +1,178 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"net/url"
+)
+
+//Implements the one-off migrations of the users collection
+
+// MigrationUnescape is the id of the migration unescaping the Users saved URL-escaped by the first versions.
+const MigrationUnescape = "users-unescape"
+
+//migration is the progress of a migration, saved in the migrations collection
+type migration struct {
+	ID     string             `bson:"_id"`
+	LastID primitive.ObjectID `bson:"last_id"` //last User migrated
+	Done   bool               `bson:"done"`
+}
+
+// MigrateUnescape unescapes then normalizes the text fields of the Users saved URL-escaped, and returns how many were changed.
+// It runs once: its progress is saved in the migrations collection, so an interrupted migration resumes after
+// the last User migrated and a User is never unescaped twice.
+func MigrateUnescape(ctx context.Context, db *mongo.Database) (int, error) {
+	migrations := db.Collection("migrations")
+	users := db.Collection("users")
+
+	var progress migration
+	err := migrations.FindOne(ctx, bson.M{"_id": MigrationUnescape}).Decode(&progress)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return 0, err
+	}
+	if progress.Done {
+		return 0, nil
+	}
+
+	filter := bson.M{}
+	if !progress.LastID.IsZero() {
+		filter["_id"] = bson.M{"$gt": progress.LastID}
+	}
+	cursor, err := users.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	changed := 0
+	saveProgress := options.Update().SetUpsert(true)
+	for cursor.Next(ctx) {
+		var u User
+		if err := cursor.Decode(&u); err != nil {
+			return changed, err
+		}
+		primId, err := primitive.ObjectIDFromHex(u.ID)
+		if err != nil {
+			return changed, err
+		}
+		set, err := unescapedFields(&u)
+		if err != nil {
+			return changed, fmt.Errorf("user %v can't be unescaped: %w", u.ID, err)
+		}
+		if len(set) > 0 {
+			//fails on a duplicate email or nickname, once normalized
+			if _, err := users.UpdateOne(ctx, bson.M{"_id": primId}, bson.M{"$set": set}); err != nil {
+				return changed, fmt.Errorf("user %v can't be unescaped: %w", u.ID, err)
+			}
+			changed++
+		}
+		_, err = migrations.UpdateOne(ctx, bson.M{"_id": MigrationUnescape}, bson.M{"$set": bson.M{"last_id": primId}}, saveProgress)
+		if err != nil {
+			return changed, err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return changed, err
+	}
+
+	_, err = migrations.UpdateOne(ctx, bson.M{"_id": MigrationUnescape}, bson.M{"$set": bson.M{"done": true}}, saveProgress)
+	return changed, err
+}
+
+// MigrateFuzzyGrams saves the trigrams of the names of the Users saved without them by the previous versions,
+// so a fuzzy lookup finds them, and returns how many were changed. The Users migrated have their trigrams,
+// so it can be interrupted and run again.
+func MigrateFuzzyGrams(ctx context.Context, db *mongo.Database) (int, error) {
+	users := db.Collection("users")
+	cursor, err := users.Find(ctx, bson.M{"fuzzy_grams": bson.M{"$exists": false}}, options.Find().SetProjection(projectionDocument(FuzzyFields, false)))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	changed := 0
+	for cursor.Next(ctx) {
+		var u User
+		if err := cursor.Decode(&u); err != nil {
+			return changed, err
+		}
+		primId, err := primitive.ObjectIDFromHex(u.ID)
+		if err != nil {
+			return changed, err
+		}
+		if _, err := users.UpdateOne(ctx, bson.M{"_id": primId}, bson.M{"$set": bson.M{"fuzzy_grams": fuzzyGrams(&u)}}); err != nil {
+			return changed, err
+		}
+		changed++
+	}
+	return changed, cursor.Err()
+}
+
+// MigrateHashPasswords hashes with the hasher the passwords saved URL-escaped in plain text by the first versions,
+// and returns how many were changed. A hashed password is never hashed again, so it can be interrupted and run again.
+func MigrateHashPasswords(ctx context.Context, db *mongo.Database, hasher PasswordHasher) (int, error) {
+	users := db.Collection("users")
+	filter := bson.M{"password": bson.M{"$not": primitive.Regex{Pattern: `^\$(2[aby]|` + HashArgon2id + `)\$`}}}
+	cursor, err := users.Find(ctx, filter, options.Find().SetProjection(bson.M{"password": 1}))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	changed := 0
+	for cursor.Next(ctx) {
+		var u User
+		if err := cursor.Decode(&u); err != nil {
+			return changed, err
+		}
+		if u.Password == "" || IsPasswordHash(u.Password) {
+			continue
+		}
+		primId, err := primitive.ObjectIDFromHex(u.ID)
+		if err != nil {
+			return changed, err
+		}
+		password, err := url.QueryUnescape(u.Password)
+		if err != nil {
+			return changed, fmt.Errorf("password of the user %v can't be unescaped: %w", u.ID, err)
+		}
+		hash, err := hasher.Hash(password)
+		if err != nil {
+			return changed, err
+		}
+		//a password changed meanwhile is kept
+		_, err = users.UpdateOne(ctx, bson.M{"_id": primId, "password": u.Password}, bson.M{"$set": bson.M{"password": hash}})
+		if err != nil {
+			return changed, err
+		}
+		changed++
+	}
+	return changed, cursor.Err()
+}
+
+//unescapedFields unescapes then normalizes the text fields of the User, and returns the changed ones by field name
+func unescapedFields(u *User) (bson.M, error) {
+	set := bson.M{}
+	for field, value := range map[string]*string{
+		"first_name": &u.FirstName,
+		"last_name":  &u.LastName,
+		"nickname":   &u.Nickname,
+		"email":      &u.Email,
+		"country":    &u.Country,
+	} {
+		unescaped, err := url.QueryUnescape(*value)
+		if err != nil {
+			return nil, err
+		}
+		unescaped = NormalizeString(unescaped)
+		if unescaped != *value {
+			*value = unescaped
+			set[field] = unescaped
+		}
+	}
+	return set, nil
+}
@@ -0,0 +1,63 @@
+package user
+
+import (
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"time"
+)
+
+//Implements the transactional outbox of the Events: they are saved with the change of the User, then published by a Relay
+
+// OutboxStatus is the delivery state of an OutboxMessage, a published message is removed from the outbox.
+type OutboxStatus string
+
+const (
+	OutboxPending OutboxStatus = "pending" //waiting for its next attempt
+	OutboxDead    OutboxStatus = "dead"    //given up after too many failed attempts, kept to be inspected
+)
+
+var ErrUnknownEventType = errors.New("unknown event type")
+
+// OutboxMessage is an Event waiting in the outbox, with the state of its delivery.
+type OutboxMessage struct {
+	Event       Event
+	Status      OutboxStatus
+	Attempts    int       //failed publications
+	NextAttempt time.Time //a pending message is published from then
+	LastError   string    //of the last failed publication
+}
+
+func newOutboxMessage(e Event) OutboxMessage {
+	return OutboxMessage{Event: e, Status: OutboxPending, NextAttempt: e.Meta().Timestamp}
+}
+
+// Outbox holds the Events saved with the changes of the Users, until a Relay publishes them.
+type Outbox interface {
+	//Due returns at most limit pending messages to publish at the time, the oldest Events first
+	Due(now time.Time, limit int) ([]OutboxMessage, error)
+	//MarkSent removes the message of the published Event
+	MarkSent(eventID string) error
+	//MarkFailed records a failed publication, the message is retried from next, or dead
+	MarkFailed(eventID string, cause error, next time.Time, dead bool) error
+	//Count returns the number of messages in the status
+	Count(status OutboxStatus) (int, error)
+}
+
+//decodeEvent returns the saved Event of the type
+func decodeEvent(eventType EventType, raw bson.Raw) (Event, error) {
+	switch eventType {
+	case EventUserCreated:
+		var e UserCreated
+		err := bson.Unmarshal(raw, &e)
+		return e, err
+	case EventUserUpdated:
+		var e UserUpdated
+		err := bson.Unmarshal(raw, &e)
+		return e, err
+	case EventUserDeleted:
+		var e UserDeleted
+		err := bson.Unmarshal(raw, &e)
+		return e, err
+	}
+	return nil, ErrUnknownEventType
+}
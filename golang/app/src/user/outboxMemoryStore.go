@@ -0,0 +1,90 @@
+package user
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// OutboxMemoryStore implements the Outbox in memory, for the UsersMemoryStore.
+// It is safe for concurrent use.
+type OutboxMemoryStore struct {
+	mu       sync.RWMutex
+	messages map[string]OutboxMessage //by Event id
+}
+
+// NewOutboxMemoryStore returns an empty OutboxMemoryStore
+func NewOutboxMemoryStore() *OutboxMemoryStore {
+	return &OutboxMemoryStore{messages: make(map[string]OutboxMessage)}
+}
+
+//add saves the Event, called by the UsersMemoryStore with the change
+func (s *OutboxMemoryStore) add(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages[e.Meta().ID] = newOutboxMessage(e)
+}
+
+// Due returns at most limit pending messages to publish at the time, the oldest Events first.
+func (s *OutboxMemoryStore) Due(now time.Time, limit int) ([]OutboxMessage, error) {
+	s.mu.RLock()
+	var due []OutboxMessage
+	for _, message := range s.messages {
+		if message.Status == OutboxPending && !message.NextAttempt.After(now) {
+			due = append(due, message)
+		}
+	}
+	s.mu.RUnlock()
+
+	//the ids are ObjectIDs, increasing with the time
+	sort.Slice(due, func(i, j int) bool {
+		mi, mj := due[i].Event.Meta(), due[j].Event.Meta()
+		if !mi.Timestamp.Equal(mj.Timestamp) {
+			return mi.Timestamp.Before(mj.Timestamp)
+		}
+		return mi.ID < mj.ID
+	})
+	if limit > 0 && len(due) > limit {
+		due = due[:limit]
+	}
+	return due, nil
+}
+
+// MarkSent removes the message of the published Event.
+func (s *OutboxMemoryStore) MarkSent(eventID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.messages, eventID)
+	return nil
+}
+
+// MarkFailed records a failed publication, the message is retried from next, or dead.
+func (s *OutboxMemoryStore) MarkFailed(eventID string, cause error, next time.Time, dead bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	message, ok := s.messages[eventID]
+	if !ok {
+		return nil
+	}
+	message.Attempts++
+	message.LastError = cause.Error()
+	message.NextAttempt = next
+	if dead {
+		message.Status = OutboxDead
+	}
+	s.messages[eventID] = message
+	return nil
+}
+
+// Count returns the number of messages in the status.
+func (s *OutboxMemoryStore) Count(status OutboxStatus) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	count := 0
+	for _, message := range s.messages {
+		if message.Status == status {
+			count++
+		}
+	}
+	return count, nil
+}
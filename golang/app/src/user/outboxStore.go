@@ -0,0 +1,117 @@
+package user
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// OutboxMongoStore implements the Outbox on MongoDB, in its own collection.
+// The UsersStore inserts the Events in the transaction of their change.
+type OutboxMongoStore struct {
+	collection *mongo.Collection
+	ctx        context.Context
+}
+
+//outboxDocument is an OutboxMessage as saved, with the Event decoded according to its type
+type outboxDocument struct {
+	ID          string       `bson:"_id"` //of the Event
+	Type        EventType    `bson:"type"`
+	Timestamp   time.Time    `bson:"timestamp"`
+	Event       bson.Raw     `bson:"event"`
+	Status      OutboxStatus `bson:"status"`
+	Attempts    int          `bson:"attempts"`
+	NextAttempt time.Time    `bson:"next_attempt"`
+	LastError   string       `bson:"last_error,omitempty"`
+}
+
+// NewOutboxMongoStore returns an OutboxMongoStore, indexed for the messages due
+func NewOutboxMongoStore(db *mongo.Database, ctx context.Context) (*OutboxMongoStore, error) {
+	outboxCollection := db.Collection("outbox")
+	_, err := outboxCollection.Indexes().CreateOne(
+		context.Background(),
+		mongo.IndexModel{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt", Value: 1}}},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OutboxMongoStore{
+		collection: outboxCollection,
+		ctx:        ctx,
+	}, nil
+}
+
+//insert saves the Event, in the transaction of the session context of its change
+func (s *OutboxMongoStore) insert(sc mongo.SessionContext, e Event) error {
+	raw, err := bson.Marshal(e)
+	if err != nil {
+		return err
+	}
+	message := newOutboxMessage(e)
+	_, err = s.collection.InsertOne(sc, outboxDocument{
+		ID:          e.Meta().ID,
+		Type:        e.Meta().Type,
+		Timestamp:   e.Meta().Timestamp,
+		Event:       raw,
+		Status:      message.Status,
+		NextAttempt: message.NextAttempt,
+	})
+	return err
+}
+
+// Due returns at most limit pending messages to publish at the time, the oldest Events first.
+func (s *OutboxMongoStore) Due(now time.Time, limit int) ([]OutboxMessage, error) {
+	findOpts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}})
+	if limit > 0 {
+		findOpts.SetLimit(int64(limit))
+	}
+	cursor, err := s.collection.Find(s.ctx, bson.M{"status": OutboxPending, "next_attempt": bson.M{"$lte": now}}, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	var documents []outboxDocument
+	if err := cursor.All(s.ctx, &documents); err != nil {
+		return nil, err
+	}
+
+	messages := make([]OutboxMessage, 0, len(documents))
+	for _, document := range documents {
+		e, err := decodeEvent(document.Type, document.Event)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, OutboxMessage{
+			Event:       e,
+			Status:      document.Status,
+			Attempts:    document.Attempts,
+			NextAttempt: document.NextAttempt,
+			LastError:   document.LastError,
+		})
+	}
+	return messages, nil
+}
+
+// MarkSent removes the message of the published Event.
+func (s *OutboxMongoStore) MarkSent(eventID string) error {
+	_, err := s.collection.DeleteOne(s.ctx, bson.M{"_id": eventID})
+	return err
+}
+
+// MarkFailed records a failed publication, the message is retried from next, or dead.
+func (s *OutboxMongoStore) MarkFailed(eventID string, cause error, next time.Time, dead bool) error {
+	set := bson.M{"next_attempt": next, "last_error": cause.Error()}
+	if dead {
+		set["status"] = OutboxDead
+	}
+	_, err := s.collection.UpdateOne(s.ctx, bson.M{"_id": eventID}, bson.M{"$set": set, "$inc": bson.M{"attempts": 1}})
+	return err
+}
+
+// Count returns the number of messages in the status.
+func (s *OutboxMongoStore) Count(status OutboxStatus) (int, error) {
+	count, err := s.collection.CountDocuments(s.ctx, bson.M{"status": status})
+	return int(count), err
+}
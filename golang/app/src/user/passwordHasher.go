@@ -0,0 +1,178 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strconv"
+	"strings"
+)
+
+//Implements the hashing of the Users passwords
+
+const (
+	HashBcrypt   = "bcrypt"
+	HashArgon2id = "argon2id"
+)
+
+var (
+	ErrHashAlgorithm    = errors.New("unknown password hash algorithm")
+	ErrHashFormat       = errors.New("password hash format error")
+	ErrPasswordMismatch = errors.New("password mismatch")
+)
+
+// PasswordHasher hashes passwords before they are saved and compares them at login.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Compare(hash, password string) error
+}
+
+// NewPasswordHasher returns the PasswordHasher for the algorithm (bcrypt by default).
+// The cost is the bcrypt cost or the argon2id number of passes, 0 selects the default one.
+func NewPasswordHasher(algorithm string, cost int) (PasswordHasher, error) {
+	switch algorithm {
+	case "", HashBcrypt:
+		if cost == 0 {
+			cost = bcrypt.DefaultCost
+		}
+		if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		return &bcryptHasher{cost: cost}, nil
+	case HashArgon2id:
+		if cost == 0 {
+			cost = 3
+		}
+		if cost < 1 {
+			return nil, errors.New("argon2id cost must be positive")
+		}
+		return &argon2Hasher{time: uint32(cost), memory: 64 * 1024, threads: 2, keyLen: 32}, nil
+	}
+	return nil, ErrHashAlgorithm
+}
+
+// IsPasswordHash reports if the stored value is a hash produced by one of the PasswordHasher.
+func IsPasswordHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$") ||
+		strings.HasPrefix(hash, "$"+HashArgon2id+"$")
+}
+
+// comparePassword checks the password against a hash of any supported algorithm,
+// so the hashes stay valid when the configured algorithm changes.
+func comparePassword(hash, password string) error {
+	if strings.HasPrefix(hash, "$"+HashArgon2id+"$") {
+		return compareArgon2(hash, password)
+	}
+	if IsPasswordHash(hash) {
+		if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+			return ErrPasswordMismatch
+		}
+		return nil
+	}
+	return ErrHashFormat
+}
+
+type bcryptHasher struct {
+	cost int
+}
+
+func (h *bcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (h *bcryptHasher) Compare(hash, password string) error {
+	return comparePassword(hash, password)
+}
+
+type argon2Hasher struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+	keyLen  uint32
+}
+
+//Hash encodes as $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>
+func (h *argon2Hasher) Hash(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.time, h.memory, h.threads, h.keyLen)
+	return fmt.Sprintf(
+		"$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		HashArgon2id, argon2.Version, h.memory, h.time, h.threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h *argon2Hasher) Compare(hash, password string) error {
+	return comparePassword(hash, password)
+}
+
+func compareArgon2(hash, password string) error {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[2] != "v="+strconv.Itoa(argon2.Version) {
+		return ErrHashFormat
+	}
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return ErrHashFormat
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return ErrHashFormat
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return ErrHashFormat
+	}
+	computed := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, computed) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+//rehashPassword returns the value to save for the password: the stored hash when it is the same password,
+//so an unchanged password is not recorded as changed, a new hash otherwise
+func rehashPassword(hasher PasswordHasher, stored, password string) (string, error) {
+	if IsPasswordHash(stored) && hasher.Compare(stored, password) == nil {
+		return stored, nil
+	}
+	return hasher.Hash(password)
+}
+
+//dummyPassword is hashed by newDummyHash
+const dummyPassword = "dummy password"
+
+//newDummyHash returns a hash by the hasher compared when no User has the login, so that an unknown login takes as long
+//to answer as a wrong password and can't be told apart. It is empty if the hasher fails
+func newDummyHash(hasher PasswordHasher) string {
+	hash, err := hasher.Hash(dummyPassword)
+	if err != nil {
+		return ""
+	}
+	return hash
+}
+
+// verifyStoredPassword compares the password with the stored hash, a stored value which is not a hash never matches.
+func verifyStoredPassword(hasher PasswordHasher, stored, password string) error {
+	if password == "" || !IsPasswordHash(stored) {
+		return ErrInvalidCredentials
+	}
+	err := hasher.Compare(stored, password)
+	if err == ErrPasswordMismatch {
+		return ErrInvalidCredentials
+	}
+	return err
+}
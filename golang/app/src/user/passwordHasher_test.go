@@ -0,0 +1,101 @@
+package user
+
+import (
+	"strings"
+	"testing"
+)
+
+type passwordHasherTest struct {
+	algorithm    string
+	cost         int
+	expectedHash string //prefix of the hash
+	expectedErr  bool
+}
+
+func TestPasswordHasher(t *testing.T) {
+	passwordHasherTests := []passwordHasherTest{
+		//test normal behaviors
+		{algorithm: "", cost: 4, expectedHash: "$2a$04$", expectedErr: false},
+		{algorithm: HashBcrypt, cost: 5, expectedHash: "$2a$05$", expectedErr: false},
+		{algorithm: HashArgon2id, cost: 1, expectedHash: "$argon2id$v=19$m=65536,t=1,p=2$", expectedErr: false},
+		//test invalid configurations
+		{algorithm: HashBcrypt, cost: 2, expectedErr: true},
+		{algorithm: HashArgon2id, cost: -1, expectedErr: true},
+		{algorithm: "md5", cost: 0, expectedErr: true},
+	}
+
+	for _, item := range passwordHasherTests {
+		hasher, resultErr := NewPasswordHasher(item.algorithm, item.cost)
+		if item.expectedErr {
+			if resultErr == nil {
+				t.Errorf("NewPasswordHasher for %v output err expected but not found", item)
+			}
+			continue
+		}
+		if resultErr != nil {
+			t.Errorf("NewPasswordHasher for %v output err %v not expected", item, resultErr.Error())
+			continue
+		}
+
+		hash, err := hasher.Hash("Pass word")
+		if err != nil {
+			t.Errorf("PasswordHasher.Hash for %v output err %v not expected", item, err.Error())
+		}
+		if !strings.HasPrefix(hash, item.expectedHash) || !IsPasswordHash(hash) {
+			t.Errorf("PasswordHasher.Hash for %v output %v but expected a hash starting with %v", item, hash, item.expectedHash)
+		}
+		if err := hasher.Compare(hash, "Pass word"); err != nil {
+			t.Errorf("PasswordHasher.Compare for %v output err %v not expected", item, err.Error())
+		}
+		if err := hasher.Compare(hash, "Pass%20word"); err != ErrPasswordMismatch {
+			t.Errorf("PasswordHasher.Compare for %v output err %v but %v was expected", item, err, ErrPasswordMismatch)
+		}
+	}
+}
+
+func TestPasswordHasherChangeAlgorithm(t *testing.T) {
+	bcryptHasher, _ := NewPasswordHasher(HashBcrypt, 4)
+	argon2Hasher, _ := NewPasswordHasher(HashArgon2id, 1)
+
+	//a hash stays valid once another algorithm is configured
+	hash, _ := bcryptHasher.Hash("Password")
+	if err := argon2Hasher.Compare(hash, "Password"); err != nil {
+		t.Errorf("argon2id PasswordHasher.Compare of a bcrypt hash output err %v not expected", err.Error())
+	}
+	hash, _ = argon2Hasher.Hash("Password")
+	if err := bcryptHasher.Compare(hash, "Password"); err != nil {
+		t.Errorf("bcrypt PasswordHasher.Compare of an argon2id hash output err %v not expected", err.Error())
+	}
+}
+
+type verifyStoredPasswordTest struct {
+	stored, password string
+	expectedErr      bool
+}
+
+func TestVerifyStoredPassword(t *testing.T) {
+	hasher, _ := NewPasswordHasher(HashBcrypt, 4)
+	hash, _ := hasher.Hash("Pass word")
+
+	verifyStoredPasswordTests := []verifyStoredPasswordTest{
+		//test hashed password
+		{stored: hash, password: "Pass word", expectedErr: false},
+		{stored: hash, password: "Pass", expectedErr: true},
+		//test a plain text password never matches
+		{stored: "Pass%20word", password: "Pass word", expectedErr: true},
+		{stored: "Pass%20word", password: "Pass%20word", expectedErr: true},
+		//test empty values
+		{stored: hash, password: "", expectedErr: true},
+		{stored: "", password: "", expectedErr: true},
+	}
+
+	for _, item := range verifyStoredPasswordTests {
+		resultErr := verifyStoredPassword(hasher, item.stored, item.password)
+		if !item.expectedErr && resultErr != nil {
+			t.Errorf("verifyStoredPassword for %v output err %v not expected", item, resultErr.Error())
+		}
+		if item.expectedErr && resultErr != ErrInvalidCredentials {
+			t.Errorf("verifyStoredPassword for %v output err %v but %v was expected", item, resultErr, ErrInvalidCredentials)
+		}
+	}
+}
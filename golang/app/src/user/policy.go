@@ -0,0 +1,95 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+//Implements the access control on the Users
+
+var (
+	ErrForbidden          = errors.New("action not allowed")
+	ErrForbiddenOtherUser = errors.New("action only allowed on your own user")
+)
+
+type Action string
+
+const (
+	ActionList     Action = "list"
+	ActionRead     Action = "read"
+	ActionCreate   Action = "create"
+	ActionUpdate   Action = "update"
+	ActionDelete   Action = "delete"
+	ActionRestore  Action = "restore"
+	ActionAudit    Action = "audit"    //query the audit trail of every User
+	ActionWebhooks Action = "webhooks" //manage the webhook subscriptions
+)
+
+// Principal is the authenticated caller.
+type Principal struct {
+	ID      string
+	Role    string
+	Expires time.Time    //of its credentials, zero when they don't expire
+	Check   func() error //reports why its credentials are no longer valid, nil when they can't be checked again
+}
+
+type contextKey struct {
+	name string
+}
+
+var principalCtxKey = &contextKey{"Principal"}
+
+// NewPrincipalContext returns a copy of ctx carrying the Principal.
+func NewPrincipalContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalCtxKey, p)
+}
+
+// PrincipalFromContext returns the Principal set by the authentication.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalCtxKey).(*Principal)
+	return p, ok
+}
+
+// Permission describes how an allowed action is restricted.
+type Permission struct {
+	OwnOnly     bool //only on the Principal own User
+	Redacted    bool //the sensitive fields are removed from the response
+	ManageRoles bool //the role of the Users can be set
+	SeeDeleted  bool //the deleted Users can be listed
+}
+
+// Policy decides who may do which action on the Users.
+type Policy interface {
+	Authorize(p *Principal, action Action) (Permission, error)
+}
+
+// RolePolicy is the Policy based on the Principal role:
+// admin can do everything, including listing and restoring the deleted Users, querying the audit trail and
+// managing the webhooks, support can list and read redacted Users, self can read and update its own User.
+// An unknown role is treated as self.
+type RolePolicy struct{}
+
+func (RolePolicy) Authorize(p *Principal, action Action) (Permission, error) {
+	if p == nil {
+		return Permission{}, ErrForbidden
+	}
+	switch p.Role {
+	case RoleAdmin:
+		return Permission{ManageRoles: true, SeeDeleted: true}, nil
+	case RoleSupport:
+		if action == ActionList || action == ActionRead {
+			return Permission{Redacted: true}, nil
+		}
+	default:
+		if action == ActionList || action == ActionRead || action == ActionUpdate {
+			return Permission{OwnOnly: true}, nil
+		}
+	}
+	return Permission{}, ErrForbidden
+}
+
+// Allows reports if the Permission covers the User targetID.
+func (p Permission) Allows(principal *Principal, targetID string) bool {
+	return !p.OwnOnly || principal.ID == targetID
+}
@@ -0,0 +1,70 @@
+package user
+
+import (
+	"testing"
+)
+
+type rolePolicyTest struct {
+	principal          *Principal
+	action             Action
+	expectedPermission Permission
+	expectedErr        bool
+}
+
+func TestRolePolicy(t *testing.T) {
+	admin := &Principal{ID: "61e41ed578752c5997718aaa", Role: RoleAdmin}
+	support := &Principal{ID: "61e41ed578752c5997718bbb", Role: RoleSupport}
+	self := &Principal{ID: "61e41ed578752c5997718ccc", Role: RoleSelf}
+	unknown := &Principal{ID: "61e41ed578752c5997718ddd", Role: ""}
+
+	rolePolicyTests := []rolePolicyTest{
+		//admin
+		{principal: admin, action: ActionList, expectedPermission: Permission{ManageRoles: true, SeeDeleted: true}},
+		{principal: admin, action: ActionRead, expectedPermission: Permission{ManageRoles: true, SeeDeleted: true}},
+		{principal: admin, action: ActionCreate, expectedPermission: Permission{ManageRoles: true, SeeDeleted: true}},
+		{principal: admin, action: ActionUpdate, expectedPermission: Permission{ManageRoles: true, SeeDeleted: true}},
+		{principal: admin, action: ActionDelete, expectedPermission: Permission{ManageRoles: true, SeeDeleted: true}},
+		{principal: admin, action: ActionRestore, expectedPermission: Permission{ManageRoles: true, SeeDeleted: true}},
+		{principal: admin, action: ActionWebhooks, expectedPermission: Permission{ManageRoles: true, SeeDeleted: true}},
+		//support
+		{principal: support, action: ActionList, expectedPermission: Permission{Redacted: true}},
+		{principal: support, action: ActionRead, expectedPermission: Permission{Redacted: true}},
+		{principal: support, action: ActionCreate, expectedErr: true},
+		{principal: support, action: ActionUpdate, expectedErr: true},
+		{principal: support, action: ActionDelete, expectedErr: true},
+		{principal: support, action: ActionRestore, expectedErr: true},
+		{principal: support, action: ActionWebhooks, expectedErr: true},
+		//self
+		{principal: self, action: ActionList, expectedPermission: Permission{OwnOnly: true}},
+		{principal: self, action: ActionRead, expectedPermission: Permission{OwnOnly: true}},
+		{principal: self, action: ActionCreate, expectedErr: true},
+		{principal: self, action: ActionUpdate, expectedPermission: Permission{OwnOnly: true}},
+		{principal: self, action: ActionDelete, expectedErr: true},
+		{principal: self, action: ActionRestore, expectedErr: true},
+		{principal: self, action: ActionWebhooks, expectedErr: true},
+		//unknown role is self
+		{principal: unknown, action: ActionUpdate, expectedPermission: Permission{OwnOnly: true}},
+		{principal: unknown, action: ActionDelete, expectedErr: true},
+		//not authenticated
+		{principal: nil, action: ActionList, expectedErr: true},
+	}
+
+	for _, item := range rolePolicyTests {
+		resultPermission, resultErr := RolePolicy{}.Authorize(item.principal, item.action)
+		if !item.expectedErr && resultErr != nil {
+			t.Errorf("RolePolicy.Authorize for %v %v output err %v not expected", item.principal, item.action, resultErr.Error())
+		}
+		if item.expectedErr && resultErr != ErrForbidden {
+			t.Errorf("RolePolicy.Authorize for %v %v output err %v but %v was expected", item.principal, item.action, resultErr, ErrForbidden)
+		}
+		if resultPermission != item.expectedPermission {
+			t.Errorf("RolePolicy.Authorize for %v %v output %v but expected %v", item.principal, item.action, resultPermission, item.expectedPermission)
+		}
+	}
+
+	//own only permission
+	permission, _ := RolePolicy{}.Authorize(self, ActionUpdate)
+	if !permission.Allows(self, self.ID) || permission.Allows(self, admin.ID) {
+		t.Errorf("Permission.Allows for %v output a wrong result", permission)
+	}
+}
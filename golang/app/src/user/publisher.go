@@ -0,0 +1,154 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+)
+
+//Implements the publishers of the Events
+
+var ErrEventDropped = errors.New("event dropped, a subscriber channel is full")
+
+// Publisher sends the Events to the components interested in the changes of the Users.
+type Publisher interface {
+	Publish(e Event) error
+}
+
+// EventHandler receives the Events of an InProcessPublisher.
+type EventHandler func(e Event) error
+
+// InProcessPublisher calls its handlers synchronously, in their subscription order.
+// It is safe for concurrent use, without handlers it publishes nothing.
+type InProcessPublisher struct {
+	mu            sync.RWMutex
+	subscriptions []subscription
+	next          int
+}
+
+type subscription struct {
+	id      int
+	handler EventHandler
+}
+
+// NewInProcessPublisher returns an InProcessPublisher without handlers.
+func NewInProcessPublisher() *InProcessPublisher {
+	return &InProcessPublisher{}
+}
+
+// Subscribe adds the handler, until the returned func is called.
+func (p *InProcessPublisher) Subscribe(handler EventHandler) (unsubscribe func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	id := p.next
+	p.next++
+	p.subscriptions = append(p.subscriptions, subscription{id: id, handler: handler})
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		//a new slice, so a Publish in progress keeps its own
+		kept := make([]subscription, 0, len(p.subscriptions))
+		for _, s := range p.subscriptions {
+			if s.id != id {
+				kept = append(kept, s)
+			}
+		}
+		p.subscriptions = kept
+	}
+}
+
+// Publish calls every handler, even after a failure, and returns the first error.
+func (p *InProcessPublisher) Publish(e Event) error {
+	p.mu.RLock()
+	subscriptions := p.subscriptions
+	p.mu.RUnlock()
+
+	var first error
+	for _, s := range subscriptions {
+		if err := s.handler(e); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// LogPublisher writes each Event as a line of JSON.
+type LogPublisher struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewLogPublisher returns a LogPublisher writing to w.
+func NewLogPublisher(w io.Writer) *LogPublisher {
+	return &LogPublisher{w: w}
+}
+
+// Publish writes the Event.
+func (p *LogPublisher) Publish(e Event) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err = p.w.Write(append(line, '\n'))
+	return err
+}
+
+// ChannelPublisher sends the Events to the channels of its subscribers.
+// A subscriber which doesn't keep up loses the Events its channel can't buffer, the others still receive them.
+type ChannelPublisher struct {
+	mu       sync.RWMutex
+	channels map[chan Event]bool
+}
+
+// NewChannelPublisher returns a ChannelPublisher without subscribers.
+func NewChannelPublisher() *ChannelPublisher {
+	return &ChannelPublisher{channels: make(map[chan Event]bool)}
+}
+
+// Subscribe returns a channel buffering up to buffer Events, until the returned func is called and the channel closed.
+func (p *ChannelPublisher) Subscribe(buffer int) (events <-chan Event, unsubscribe func()) {
+	ch := make(chan Event, buffer)
+	p.mu.Lock()
+	p.channels[ch] = true
+	p.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			delete(p.channels, ch)
+			close(ch)
+		})
+	}
+}
+
+// Publish sends the Event without waiting, ErrEventDropped if a channel is full.
+func (p *ChannelPublisher) Publish(e Event) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var err error
+	for ch := range p.channels {
+		select {
+		case ch <- e:
+		default:
+			err = ErrEventDropped
+		}
+	}
+	return err
+}
+
+// MultiPublisher publishes the Events to each of its Publishers, even after a failure, and returns the first error.
+type MultiPublisher []Publisher
+
+func (m MultiPublisher) Publish(e Event) error {
+	var first error
+	for _, p := range m {
+		if err := p.Publish(e); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
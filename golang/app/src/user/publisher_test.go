@@ -0,0 +1,111 @@
+package user
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestNewUserUpdated(t *testing.T) {
+	before := &User{ID: "61e41ed578752c5997718aff", FirstName: "FirstName", Password: "hash", Country: "FR", Score: 2}
+	after := &User{ID: "61e41ed578752c5997718aff", FirstName: "Updated", Password: "other hash", Country: "FR", Score: 2}
+	e := NewUserUpdated(before, after)
+	if e.Type != EventUserUpdated || e.ID == "" || e.Timestamp.IsZero() || e.SchemaVersion != EventSchemaVersion {
+		t.Errorf("NewUserUpdated output the meta %+v", e.EventMeta)
+	}
+	if e.User.Password != "" || e.User.Score != 0 || after.Password != "other hash" {
+		t.Errorf("NewUserUpdated output the user %+v, the password and the score were expected removed from a copy", e.User)
+	}
+	expected := []FieldChange{{Field: "first_name", From: "FirstName", To: "Updated"}, {Field: "password", From: RedactedValue, To: RedactedValue}}
+	if !reflect.DeepEqual(e.Changes, expected) {
+		t.Errorf("NewUserUpdated output the changes %+v but expected %+v", e.Changes, expected)
+	}
+}
+
+func TestInProcessPublisher(t *testing.T) {
+	p := NewInProcessPublisher()
+	if err := p.Publish(NewUserCreated(&User{})); err != nil {
+		t.Errorf("InProcessPublisher.Publish without handlers output err %v", err)
+	}
+
+	var calls []string
+	errFirst := errors.New("first handler failed")
+	unsubscribeFirst := p.Subscribe(func(e Event) error {
+		calls = append(calls, "first")
+		return errFirst
+	})
+	p.Subscribe(func(e Event) error {
+		calls = append(calls, "second")
+		return nil
+	})
+	//every handler is called in order, the first error is returned
+	if err := p.Publish(NewUserCreated(&User{})); err != errFirst || !reflect.DeepEqual(calls, []string{"first", "second"}) {
+		t.Errorf("InProcessPublisher.Publish output err %v and called %v", err, calls)
+	}
+	unsubscribeFirst()
+	calls = nil
+	if err := p.Publish(NewUserCreated(&User{})); err != nil || !reflect.DeepEqual(calls, []string{"second"}) {
+		t.Errorf("InProcessPublisher.Publish after an unsubscribe output err %v and called %v", err, calls)
+	}
+}
+
+func TestLogPublisher(t *testing.T) {
+	var buffer bytes.Buffer
+	p := NewLogPublisher(&buffer)
+	created := NewUserCreated(&User{ID: "61e41ed578752c5997718aff", Nickname: "Nickname", Password: "hash"})
+	deleted := NewUserDeleted(&User{ID: "61e41ed578752c5997718aff", Nickname: "Nickname"})
+	for _, e := range []Event{created, deleted} {
+		if err := p.Publish(e); err != nil {
+			t.Fatalf("LogPublisher.Publish output err %v", err)
+		}
+	}
+
+	lines := bytes.Split(bytes.TrimSpace(buffer.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("LogPublisher wrote %q but expected 2 lines", buffer.String())
+	}
+	var written UserCreated
+	if err := json.Unmarshal(lines[0], &written); err != nil {
+		t.Fatalf("LogPublisher wrote %q, err %v trying to parse it", lines[0], err)
+	}
+	if written.ID != created.ID || written.Type != EventUserCreated || written.User.Nickname != "Nickname" || bytes.Contains(lines[0], []byte("hash")) {
+		t.Errorf("LogPublisher wrote %q for %+v", lines[0], created)
+	}
+}
+
+func TestChannelPublisher(t *testing.T) {
+	p := NewChannelPublisher()
+	fast, unsubscribeFast := p.Subscribe(2)
+	slow, unsubscribeSlow := p.Subscribe(1)
+	defer unsubscribeSlow()
+
+	first, second := NewUserCreated(&User{}), NewUserDeleted(&User{})
+	if err := p.Publish(first); err != nil {
+		t.Errorf("ChannelPublisher.Publish output err %v", err)
+	}
+	//the slow subscriber loses the event, the fast one still receives it
+	if err := p.Publish(second); err != ErrEventDropped {
+		t.Errorf("ChannelPublisher.Publish to a full channel output err %v but %v was expected", err, ErrEventDropped)
+	}
+	if e := <-fast; e.Meta().ID != first.ID {
+		t.Errorf("ChannelPublisher sent %+v but expected %+v", e, first)
+	}
+	if e := <-fast; e.Meta().ID != second.ID {
+		t.Errorf("ChannelPublisher sent %+v but expected %+v", e, second)
+	}
+	if e := <-slow; e.Meta().ID != first.ID {
+		t.Errorf("ChannelPublisher sent %+v but expected %+v", e, first)
+	}
+
+	//the channel is closed once unsubscribed, twice is harmless
+	unsubscribeFast()
+	unsubscribeFast()
+	if _, ok := <-fast; ok {
+		t.Errorf("ChannelPublisher channel still open after the unsubscribe")
+	}
+	if err := p.Publish(first); err != nil {
+		t.Errorf("ChannelPublisher.Publish after an unsubscribe output err %v", err)
+	}
+}
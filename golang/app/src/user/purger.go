@@ -0,0 +1,47 @@
+package user
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+//Implements the scheduled purge of the deleted Users
+
+const (
+	DefaultPurgeRetention = 30 * 24 * time.Hour
+	DefaultPurgeInterval  = time.Hour
+)
+
+// Purger removes for good the Users deleted for longer than the Retention, every Interval.
+type Purger struct {
+	Store     UserRepository
+	Retention time.Duration //time a deleted User can still be restored
+	Interval  time.Duration //time between two purges
+}
+
+// NewPurger returns a Purger of the store with the default retention and interval.
+func NewPurger(store UserRepository) *Purger {
+	return &Purger{
+		Store:     store,
+		Retention: DefaultPurgeRetention,
+		Interval:  DefaultPurgeInterval,
+	}
+}
+
+// PurgeOnce removes the Users deleted for longer than the Retention, and returns how many were removed.
+func (p *Purger) PurgeOnce(now time.Time) (int, error) {
+	return p.Store.Purge(now.Add(-p.Retention))
+}
+
+// Run purges at once then every Interval, until the context is done. The errors are logged, the next purge retries.
+func (p *Purger) Run(ctx context.Context) {
+	runEvery(ctx, p.Interval, func(now time.Time) {
+		purged, err := p.PurgeOnce(now)
+		if err != nil {
+			log.Println("purge of the deleted users failed:", err)
+		} else if purged > 0 {
+			log.Println(purged, "deleted users purged")
+		}
+	})
+}
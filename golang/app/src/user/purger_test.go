@@ -0,0 +1,51 @@
+package user
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPurger(t *testing.T) {
+	user := User{
+		FirstName: "FirstName",
+		LastName:  "LastName",
+		Nickname:  "PurgerNickname",
+		Password:  "Password",
+		Email:     "PurgerEmail@email.com",
+		Country:   "Country",
+	}
+	if err := testUsersStore.Create("", &user); err != nil {
+		t.Fatalf("Create user failled for purger test with err %v", err)
+	}
+	if err := testUsersStore.Delete("", user.ID, AnyVersion); err != nil {
+		t.Fatalf("Delete user failled for purger test with err %v", err)
+	}
+
+	purger := NewPurger(testUsersStore)
+	//still in the retention
+	purged, err := purger.PurgeOnce(time.Now())
+	if err != nil || purged != 0 {
+		t.Errorf("Purger.PurgeOnce in the retention output %v with err %v", purged, err)
+	}
+	purged, err = purger.PurgeOnce(time.Now().Add(DefaultPurgeRetention + time.Minute))
+	if err != nil || purged != 1 {
+		t.Errorf("Purger.PurgeOnce after the retention output %v with err %v", purged, err)
+	}
+
+	//runs until the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	purger.Interval = time.Millisecond
+	go func() {
+		purger.Run(ctx)
+		close(done)
+	}()
+	time.Sleep(5 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("Purger.Run did not stop with its context")
+	}
+}
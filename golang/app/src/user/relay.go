@@ -0,0 +1,84 @@
+package user
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+//Implements the relay publishing the Events of the outbox
+
+const (
+	DefaultRelayInterval    = time.Second
+	DefaultRelayBatchSize   = 100
+	DefaultRelayMaxAttempts = 10
+	DefaultRelayBackoff     = time.Second
+	DefaultRelayMaxBackoff  = 10 * time.Minute
+)
+
+// Relay publishes the Events of the Outbox every Interval, at least once: an Event whose publication failed is retried
+// after a backoff doubled at each attempt, and is dead after MaxAttempts. The subscribers recognize a duplicate by its id.
+type Relay struct {
+	Outbox      Outbox
+	Publisher   Publisher
+	Interval    time.Duration //time between two checks of the outbox
+	BatchSize   int           //Events read at once from the outbox
+	MaxAttempts int           //failed publications before an Event is dead
+	Backoff     time.Duration //delay before the first retry
+	MaxBackoff  time.Duration //longest delay between two retries
+}
+
+// NewRelay returns a Relay of the outbox to the publisher, with the default settings.
+func NewRelay(outbox Outbox, publisher Publisher) *Relay {
+	return &Relay{
+		Outbox:      outbox,
+		Publisher:   publisher,
+		Interval:    DefaultRelayInterval,
+		BatchSize:   DefaultRelayBatchSize,
+		MaxAttempts: DefaultRelayMaxAttempts,
+		Backoff:     DefaultRelayBackoff,
+		MaxBackoff:  DefaultRelayMaxBackoff,
+	}
+}
+
+// RelayOnce publishes the Events due at the time, until none is left, and returns how many were published.
+func (r *Relay) RelayOnce(now time.Time) (int, error) {
+	published := 0
+	for {
+		messages, err := r.Outbox.Due(now, r.BatchSize)
+		if err != nil {
+			return published, err
+		}
+		for _, message := range messages {
+			id := message.Event.Meta().ID
+			if err := r.Publisher.Publish(message.Event); err != nil {
+				attempts := message.Attempts + 1
+				dead := attempts >= r.MaxAttempts
+				if dead {
+					log.Println("publication of the event", id, "given up after", attempts, "attempts:", err)
+				}
+				if err := r.Outbox.MarkFailed(id, err, now.Add(backoffDelay(r.Backoff, r.MaxBackoff, attempts)), dead); err != nil {
+					return published, err
+				}
+				continue
+			}
+			//a failure here publishes the Event again
+			if err := r.Outbox.MarkSent(id); err != nil {
+				return published, err
+			}
+			published++
+		}
+		if r.BatchSize <= 0 || len(messages) < r.BatchSize {
+			return published, nil
+		}
+	}
+}
+
+// Run relays at once then every Interval, until the context is done. The errors are logged, the next check retries.
+func (r *Relay) Run(ctx context.Context) {
+	runEvery(ctx, r.Interval, func(now time.Time) {
+		if _, err := r.RelayOnce(now); err != nil {
+			log.Println("relay of the events failed:", err)
+		}
+	})
+}
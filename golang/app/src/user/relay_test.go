@@ -0,0 +1,110 @@
+package user
+
+import (
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"reflect"
+	"testing"
+	"time"
+)
+
+//failingPublisher fails the publication of the Events, until fixed
+type failingPublisher struct {
+	fixed     bool
+	published []Event
+}
+
+var errPublisherDown = errors.New("publisher down")
+
+func (p *failingPublisher) Publish(e Event) error {
+	if !p.fixed {
+		return errPublisherDown
+	}
+	p.published = append(p.published, e)
+	return nil
+}
+
+func TestRelay(t *testing.T) {
+	outbox := NewOutboxMemoryStore()
+	publisher := &failingPublisher{}
+	relay := NewRelay(outbox, publisher)
+	relay.BatchSize = 2
+	relay.MaxAttempts = 3
+	relay.Backoff = time.Second
+
+	first := NewUserCreated(&User{ID: "61e41ed578752c5997718aff"})
+	second := NewUserDeleted(&User{ID: "61e41ed578752c5997718aff"})
+	third := NewUserCreated(&User{ID: "61e41ed578752c5997718b00"})
+	for _, e := range []Event{first, second, third} {
+		outbox.add(e)
+	}
+	now := time.Now()
+
+	//every failure delays the next attempt twice more
+	for attempt, delay := range []time.Duration{0, time.Second, 2 * time.Second} {
+		now = now.Add(delay)
+		published, err := relay.RelayOnce(now)
+		if err != nil || published != 0 {
+			t.Errorf("Relay.RelayOnce attempt %v output %v with err %v", attempt, published, err)
+		}
+		if published, _ := relay.RelayOnce(now.Add(backoffDelay(relay.Backoff, relay.MaxBackoff, attempt+1) - time.Millisecond)); published != 0 {
+			t.Errorf("Relay.RelayOnce before the backoff of attempt %v output %v", attempt, published)
+		}
+	}
+	//given up after the attempts, the dead Events are kept
+	publisher.fixed = true
+	if published, err := relay.RelayOnce(now.Add(time.Hour)); err != nil || published != 0 {
+		t.Errorf("Relay.RelayOnce of the dead events output %v with err %v", published, err)
+	}
+	if dead, _ := outbox.Count(OutboxDead); dead != 3 {
+		t.Errorf("Outbox.Count of the dead events output %v but expected 3", dead)
+	}
+	if message := outbox.messages[first.ID]; message.Attempts != 3 || message.LastError != errPublisherDown.Error() {
+		t.Errorf("Outbox dead message %+v", message)
+	}
+
+	//the pending Events are published in their order, then removed
+	fourth := NewUserUpdated(&User{ID: "61e41ed578752c5997718aff"}, &User{ID: "61e41ed578752c5997718aff", Country: "FR"})
+	fifth := NewUserCreated(&User{ID: "61e41ed578752c5997718b01"})
+	sixth := NewUserCreated(&User{ID: "61e41ed578752c5997718b02"})
+	for _, e := range []Event{fourth, fifth, sixth} {
+		outbox.add(e)
+	}
+	published, err := relay.RelayOnce(time.Now())
+	if err != nil || published != 3 {
+		t.Errorf("Relay.RelayOnce output %v with err %v but expected 3", published, err)
+	}
+	if !reflect.DeepEqual(publisher.published, []Event{fourth, fifth, sixth}) {
+		t.Errorf("Relay published %+v", publisher.published)
+	}
+	if pending, _ := outbox.Count(OutboxPending); pending != 0 {
+		t.Errorf("Outbox.Count of the pending events output %v but expected 0", pending)
+	}
+}
+
+func TestDecodeEvent(t *testing.T) {
+	deletedAt := time.Now().Truncate(time.Millisecond).UTC()
+	for _, e := range []Event{
+		NewUserCreated(&User{ID: "61e41ed578752c5997718aff", Nickname: "Nickname", Version: 1}),
+		NewUserUpdated(&User{ID: "61e41ed578752c5997718aff", Country: "US"}, &User{ID: "61e41ed578752c5997718aff", Country: "FR"}),
+		NewUserDeleted(&User{ID: "61e41ed578752c5997718aff", DeletedAt: &deletedAt}),
+	} {
+		raw, err := bson.Marshal(e)
+		if err != nil {
+			t.Fatalf("bson.Marshal of %+v output err %v", e, err)
+		}
+		decoded, err := decodeEvent(e.Meta().Type, raw)
+		if err != nil {
+			t.Errorf("decodeEvent of %+v output err %v", e, err)
+		}
+		//the dates are decoded in UTC
+		meta := e.Meta()
+		meta.Timestamp = meta.Timestamp.UTC()
+		if decoded.Meta() != meta || !reflect.DeepEqual(decoded.Subject(), e.Subject()) {
+			t.Errorf("decodeEvent output %+v but expected %+v", decoded, e)
+		}
+	}
+	if _, err := decodeEvent("user.unknown", nil); err != ErrUnknownEventType {
+		t.Errorf("decodeEvent of an unknown type output err %v but %v was expected", err, ErrUnknownEventType)
+	}
+}
@@ -0,0 +1,34 @@
+package user
+
+import (
+	"context"
+	"time"
+)
+
+//Implements the scheduling shared by the background jobs
+
+//runEvery calls once at once then every interval, until the context is done
+func runEvery(ctx context.Context, interval time.Duration, once func(now time.Time)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		once(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+//backoffDelay returns the delay before the next attempt after the failed attempts: base doubled at each attempt, up to max
+func backoffDelay(base, max time.Duration, attempts int) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		return max
+	}
+	return delay
+}
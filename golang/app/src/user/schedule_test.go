@@ -0,0 +1,50 @@
+package user
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	for _, item := range []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{20, 5 * time.Second},
+	} {
+		if delay := backoffDelay(time.Second, 5*time.Second, item.attempts); delay != item.expected {
+			t.Errorf("backoffDelay after %v attempts output %v but expected %v", item.attempts, delay, item.expected)
+		}
+	}
+}
+
+func TestRunEvery(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	runs := make(chan time.Time, 10)
+	done := make(chan bool)
+	go func() {
+		runEvery(ctx, 10*time.Millisecond, func(now time.Time) {
+			runs <- now
+		})
+		done <- true
+	}()
+	//the first run is immediate, the next ones every interval
+	for i := 0; i < 3; i++ {
+		select {
+		case <-runs:
+		case <-time.After(time.Second):
+			t.Fatalf("runEvery did not run %v times", i+1)
+		}
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("runEvery did not return once the context done")
+	}
+}
@@ -0,0 +1,70 @@
+package user
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+//Implements the full text search of the Users
+
+// SearchWeights are the fields of the text index, by their weight in the relevance score.
+var SearchWeights = map[string]int{
+	"nickname":   10,
+	"first_name": 5,
+	"last_name":  5,
+	"email":      2,
+}
+
+// RelevanceSort lists the most relevant Users of a search first.
+var RelevanceSort = Sort{{Field: "score", Desc: true}}
+
+//searchFields returns the fields of the text index in a stable order
+func searchFields() []string {
+	fields := make([]string, 0, len(SearchWeights))
+	for field := range SearchWeights {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+//tokenize splits the value in lower case words, on anything else than letters and digits
+func tokenize(value string) []string {
+	return strings.FieldsFunc(strings.ToLower(value), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+//searchScore returns the relevance of the User for the words of the search, as the weight of each field
+//containing a word, 0 when no word is found
+func searchScore(u *User, search string) float64 {
+	words := tokenize(search)
+	score := 0
+	for _, field := range searchFields() {
+		tokens := map[string]bool{}
+		for _, token := range tokenize(fieldValues[field](u)) {
+			tokens[token] = true
+		}
+		for _, word := range words {
+			if tokens[word] {
+				score += SearchWeights[field]
+			}
+		}
+	}
+	return float64(score)
+}
+
+//checkSearchSort rejects a sort on the score without a search or a fuzzy lookup, or with a cursor
+func checkSearchSort(f UserFilter, opts ListOptions) error {
+	if !opts.sort().hasScore() {
+		return nil
+	}
+	if f.Search == "" && f.Fuzzy == "" {
+		return ErrSortScore
+	}
+	if opts.Cursor != nil {
+		return ErrCursorScore
+	}
+	return nil
+}
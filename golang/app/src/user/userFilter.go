@@ -0,0 +1,198 @@
+package user
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	errors2 "test/errors"
+	"time"
+)
+
+//Implements the filters of the Users list
+
+// Operator compares a field of the Users to the values of a Condition.
+type Operator string
+
+const (
+	OpEq       Operator = "eq"       //equal to the value
+	OpPrefix   Operator = "prefix"   //starts with the value
+	OpContains Operator = "contains" //contains the value
+	OpIn       Operator = "in"       //equal to one of the values
+	OpNot      Operator = "not"      //different from the value
+	OpRange    Operator = "range"    //between the two values included, "" for an open bound
+	OpRegex    Operator = "regex"    //matches the regular expression, in the RE2 syntax
+)
+
+// MaxRegexLength is the longest regular expression of an OpRegex.
+const MaxRegexLength = 256
+
+// TextField is the pseudo field of a Condition on any of the TextFields.
+const TextField = "text"
+
+// TextFields are the fields searched by a Condition on the TextField.
+var TextFields = []string{"first_name", "last_name", "nickname", "email", "country"}
+
+//fieldOperators are the operators supported by each field
+var fieldOperators = map[string][]Operator{
+	"id":         {OpEq, OpIn, OpNot},
+	"first_name": {OpEq, OpPrefix, OpContains, OpIn, OpNot, OpRange, OpRegex},
+	"last_name":  {OpEq, OpPrefix, OpContains, OpIn, OpNot, OpRange, OpRegex},
+	"nickname":   {OpEq, OpPrefix, OpContains, OpIn, OpNot, OpRange, OpRegex},
+	"email":      {OpEq, OpPrefix, OpContains, OpIn, OpNot, OpRange, OpRegex},
+	"country":    {OpEq, OpPrefix, OpContains, OpIn, OpNot, OpRange, OpRegex},
+	"role":       {OpEq, OpIn, OpNot},
+	"created_at": {OpEq, OpIn, OpNot, OpRange},
+	"updated_at": {OpEq, OpIn, OpNot, OpRange},
+	TextField:    {OpEq, OpPrefix, OpContains, OpRegex},
+}
+
+//fieldValues returns the value of each filterable field, as compared by the operators
+var fieldValues = map[string]func(u *User) string{
+	"id":         func(u *User) string { return u.ID },
+	"first_name": func(u *User) string { return u.FirstName },
+	"last_name":  func(u *User) string { return u.LastName },
+	"nickname":   func(u *User) string { return u.Nickname },
+	"email":      func(u *User) string { return u.Email },
+	"country":    func(u *User) string { return u.Country },
+	"role":       func(u *User) string { return u.Role },
+}
+
+// ErrFilterFuzzy rejects a fuzzy lookup combined with a full text search, both ranking the Users.
+var ErrFilterFuzzy = errors2.BadRequest(errors.New("fuzzy text can't be combined with a search q"))
+
+func ErrFilterOperator(field string, op Operator) error {
+	return errors2.BadRequest(errors.New("filter " + string(op) + " not supported on " + field))
+}
+
+func ErrFilterValues(field string, op Operator) error {
+	return errors2.BadRequest(errors.New("wrong number of values for the filter " + string(op) + " on " + field))
+}
+
+func ErrFilterCase(field string, op Operator) error {
+	return errors2.BadRequest(errors.New("filter " + string(op) + " on " + field + " can't be case insensitive"))
+}
+
+func ErrFilterRegex(err error) error {
+	return errors2.BadRequest(errors.New("invalid regex of at most " + strconv.Itoa(MaxRegexLength) + " characters: " + err.Error()))
+}
+
+// Condition filters the Users on one field, with the operator and its values.
+type Condition struct {
+	Field           string
+	Op              Operator
+	Values          []string //two for OpRange, one or more for OpIn, one otherwise
+	CaseInsensitive bool     //only on the text fields, not with OpRange
+}
+
+// UserFilter selects the Users matching all its Conditions and the Search or the Fuzzy value, no Condition selects every User.
+type UserFilter struct {
+	Conditions []Condition
+	Search     string //words searched in the text index, any of them matches, see SearchWeights
+	Fuzzy      string //value close to the names of the Users, see FuzzyOptions
+}
+
+// Where returns the filter with one more Condition, so filters are built by chaining them.
+// _example_: UserFilter{}.Where("country", OpEq, "FR").Where("created_at", OpRange, "2022-01-01T00:00:00Z", "")
+func (f UserFilter) Where(field string, op Operator, values ...string) UserFilter {
+	return f.And(UserFilter{Conditions: []Condition{{Field: field, Op: op, Values: values}}})
+}
+
+// Matching returns the filter searching the words in the text index too.
+func (f UserFilter) Matching(search string) UserFilter {
+	return f.And(UserFilter{Search: search})
+}
+
+// Resembling returns the filter keeping only the Users with names close to the value too.
+func (f UserFilter) Resembling(value string) UserFilter {
+	return f.And(UserFilter{Fuzzy: value})
+}
+
+// WhereFold returns the filter with one more Condition, case insensitive.
+func (f UserFilter) WhereFold(field string, op Operator, values ...string) UserFilter {
+	return f.And(UserFilter{Conditions: []Condition{{Field: field, Op: op, Values: values, CaseInsensitive: true}}})
+}
+
+// And returns the filter matching the Users matched by both f and other.
+func (f UserFilter) And(other UserFilter) UserFilter {
+	conditions := make([]Condition, 0, len(f.Conditions)+len(other.Conditions))
+	return UserFilter{
+		Conditions: append(append(conditions, f.Conditions...), other.Conditions...),
+		Search:     strings.TrimSpace(f.Search + " " + other.Search),
+		Fuzzy:      strings.TrimSpace(f.Fuzzy + " " + other.Fuzzy),
+	}
+}
+
+// Validate checks the field, the operator and the values of every Condition, and that the Search and the Fuzzy value are not both set.
+func (f UserFilter) Validate() error {
+	if f.Search != "" && f.Fuzzy != "" {
+		return ErrFilterFuzzy
+	}
+	for _, c := range f.Conditions {
+		if err := c.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c Condition) validate() error {
+	if !c.supported() {
+		return ErrFilterOperator(c.Field, c.Op)
+	}
+	switch {
+	case c.Op == OpRange && len(c.Values) != 2,
+		c.Op == OpIn && len(c.Values) == 0,
+		c.Op != OpRange && c.Op != OpIn && len(c.Values) != 1:
+		return ErrFilterValues(c.Field, c.Op)
+	}
+	if c.CaseInsensitive && (!isTextField(c.Field) || c.Op == OpRange) {
+		return ErrFilterCase(c.Field, c.Op)
+	}
+	if c.Op == OpRegex {
+		if len(c.Values[0]) > MaxRegexLength {
+			return ErrFilterRegex(errors.New("too long"))
+		}
+		if _, err := regexp.Compile(c.Values[0]); err != nil {
+			return ErrFilterRegex(err)
+		}
+	}
+	for _, value := range c.Values {
+		if value == "" && c.Op == OpRange {
+			continue
+		}
+		switch {
+		case c.Field == "id":
+			if _, err := ParseID(value); err != nil {
+				return err
+			}
+		case isTimeField(c.Field):
+			if _, err := time.Parse(time.RFC3339, value); err != nil {
+				return ErrParamDate
+			}
+		}
+	}
+	return nil
+}
+
+//isTextField reports if the field is the TextField or one of the TextFields, escaped when saved
+func isTextField(field string) bool {
+	if field == TextField {
+		return true
+	}
+	for _, textField := range TextFields {
+		if field == textField {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Condition) supported() bool {
+	for _, op := range fieldOperators[c.Field] {
+		if op == c.Op {
+			return true
+		}
+	}
+	return false
+}
@@ -4,70 +4,116 @@ import (
 	"errors"
 	"github.com/go-ozzo/ozzo-validation"
 	"github.com/go-ozzo/ozzo-validation/is"
-	"net/url"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/text/unicode/norm"
 	"strings"
+	errors2 "test/errors"
 	"time"
 )
 
+const (
+	RoleAdmin   = "admin"   //manages every User
+	RoleSupport = "support" //reads every User, without the sensitive fields
+	RoleSelf    = "self"    //reads and updates only its own User
+)
+
+const ErrMessageRequired = "value required"
+
+// ErrInvalidID is the error of an id which is not an ObjectID, of any length or characters.
+var ErrInvalidID = errors2.InvalidID(errors.New("id must be an ObjectID of 24 hexadecimal characters"))
+
+// ParseID returns the ObjectID of the id, ErrInvalidID when it is malformed.
+func ParseID(id string) (primitive.ObjectID, error) {
+	primId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, ErrInvalidID
+	}
+	return primId, nil
+}
+
 func ErrRequiredValue(field string) error {
-	return errors.New(field + " value required")
+	return errors2.Validation(errors2.FieldErrors{field: ErrMessageRequired})
 }
 
 // User represents the schema for the User
 type User struct {
-	ID        string    `bson:"_id,omitempty" json:"id,omitempty"`
-	FirstName string    `bson:"first_name" json:"first_name,omitempty"`
-	LastName  string    `bson:"last_name" json:"last_name,omitempty"`
-	Nickname  string    `bson:"nickname" json:"nickname,omitempty"`
-	Password  string    `bson:"password" json:"password,omitempty"`
-	Email     string    `bson:"email" json:"email,omitempty"`
-	Country   string    `bson:"country" json:"country,omitempty"`
-	CreatedAt time.Time `bson:"created_at" json:"created_at,omitempty"`
-	UpdatedAt time.Time `bson:"updated_at" json:"updated_at,omitempty"`
+	ID        string     `bson:"_id,omitempty" json:"id,omitempty"`
+	FirstName string     `bson:"first_name" json:"first_name,omitempty"`
+	LastName  string     `bson:"last_name" json:"last_name,omitempty"`
+	Nickname  string     `bson:"nickname" json:"nickname,omitempty"`
+	Password  string     `bson:"password" json:"-"` //hashed, never serialized
+	Email     string     `bson:"email" json:"email,omitempty"`
+	Country   string     `bson:"country" json:"country,omitempty"`
+	Role      string     `bson:"role" json:"role,omitempty"`
+	CreatedAt time.Time  `bson:"created_at" json:"created_at,omitempty"`
+	UpdatedAt time.Time  `bson:"updated_at" json:"updated_at,omitempty"`
+	DeletedAt *time.Time `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"` //set by a soft delete, nil for a User not deleted
+	Version   int64      `bson:"version" json:"version,omitempty"`                 //incremented by every change, 0 for a User saved before versioning
+	Score     float64    `bson:"score,omitempty" json:"score,omitempty"`           //relevance of a search, never saved
 }
 
-//Escape User for safety
-func (u *User) Escape() {
-	u.FirstName = strings.ReplaceAll(url.QueryEscape(u.FirstName), "+", "%20")
-	u.LastName = strings.ReplaceAll(url.QueryEscape(u.LastName), "+", "%20")
-	u.Nickname = strings.ReplaceAll(url.QueryEscape(u.Nickname), "+", "%20")
-	u.Password = strings.ReplaceAll(url.QueryEscape(u.Password), "+", "%20")
-	u.Email = strings.ReplaceAll(strings.ReplaceAll(url.QueryEscape(u.Email), "+", "%20"), "%40", "@")
-	u.Country = strings.ReplaceAll(url.QueryEscape(u.Country), "+", "%20")
+//Normalize the User text fields the way they are saved, the password is kept as written as it is hashed before being saved
+func (u *User) Normalize() {
+	u.FirstName = NormalizeString(u.FirstName)
+	u.LastName = NormalizeString(u.LastName)
+	u.Nickname = NormalizeString(u.Nickname)
+	u.Email = NormalizeString(u.Email)
+	u.Country = NormalizeString(u.Country)
 }
 
-// Validate validates User email and returns validation errors.
+//NormalizeString returns the value trimmed and in the Unicode NFC form, the way the User fields are saved
+func NormalizeString(value string) string {
+	return norm.NFC.String(strings.TrimSpace(value))
+}
+
+// Validate validates the User fields and returns all their errors as errors.FieldErrors.
 func (u *User) Validate() error {
-	if u.FirstName == "" {
-		return ErrRequiredValue("first_name")
-	}
-	if u.LastName == "" {
-		return ErrRequiredValue("last_name")
-	}
-	if u.Nickname == "" {
-		return ErrRequiredValue("nickname")
+	fieldErrors := errors2.FieldErrors{}
+	for _, f := range []struct {
+		name  string
+		value string
+	}{
+		{"first_name", u.FirstName},
+		{"last_name", u.LastName},
+		{"nickname", u.Nickname},
+		{"password", u.Password},
+		{"email", u.Email},
+		{"country", u.Country},
+	} {
+		if f.value == "" {
+			fieldErrors[f.name] = ErrMessageRequired
+		}
 	}
-	if u.Password == "" {
-		return ErrRequiredValue("password")
+
+	// Verify that the email and the role values are valid
+	if u.Email != "" {
+		if err := validation.Validate(u.Email, is.Email); err != nil {
+			fieldErrors["email"] = err.Error()
+		}
 	}
-	if u.Email == "" {
-		return ErrRequiredValue("email")
+	if err := validation.Validate(u.Role, validation.In(RoleAdmin, RoleSupport, RoleSelf)); err != nil {
+		fieldErrors["role"] = err.Error()
 	}
-	if u.Country == "" {
-		return ErrRequiredValue("country")
+	if len(fieldErrors) > 0 {
+		return errors2.Validation(fieldErrors)
 	}
+	return nil
+}
+
+// RedactedFields are the fields removed by Redact, a redacted List can't be filtered, sorted nor searched on them.
+var RedactedFields = []string{"email"}
 
-	// Verify that the name value is valid
-	return validation.ValidateStruct(u,
-		validation.Field(&u.Email, validation.Required, is.Email),
-	)
+//Redact removes the sensitive fields
+func (u *User) Redact() {
+	u.Password = ""
+	u.Email = ""
 }
 
+//IsSoftEqual compares the editable fields, except the password which is only comparable through its hash
 func (u *User) IsSoftEqual(u2 *User) bool {
 	return u.FirstName == u2.FirstName &&
 		u.LastName == u2.LastName &&
 		u.Nickname == u2.Nickname &&
-		u.Password == u2.Password &&
 		u.Email == u2.Email &&
 		u.Country == u2.Country
 }
@@ -1,16 +1,18 @@
 package user
 
 import (
+	"errors"
+	errors2 "test/errors"
 	"testing"
 )
 
-type userEscapeTest struct {
-	user                User
-	userEscapedExpected User
+type userNormalizeTest struct {
+	user                   User
+	userNormalizedExpected User
 }
 
-func TestEscape(t *testing.T) {
-	userModelTests := []userEscapeTest{
+func TestNormalize(t *testing.T) {
+	userModelTests := []userNormalizeTest{
 		//test without special characters
 		{
 			user: User{
@@ -21,7 +23,7 @@ func TestEscape(t *testing.T) {
 				Email:     "Email@email.com",
 				Country:   "Country",
 			},
-			userEscapedExpected: User{
+			userNormalizedExpected: User{
 				FirstName: "FirstName",
 				LastName:  "LastName",
 				Nickname:  "Nickname",
@@ -30,31 +32,50 @@ func TestEscape(t *testing.T) {
 				Country:   "Country",
 			},
 		},
-		//test with special characters
+		//test with special characters, saved as written
 		{
 			user: User{
 				FirstName: "FirstName£",
 				LastName:  "Last§Name",
-				Nickname:  "]Nickname",
+				Nickname:  "]Nick name",
 				Password:  "Pass word",
 				Email:     "Eµmail@email.com",
 				Country:   "Country@",
 			},
-			userEscapedExpected: User{
-				FirstName: "FirstName%C2%A3",
-				LastName:  "Last%C2%A7Name",
-				Nickname:  "%5DNickname",
-				Password:  "Pass%20word",
-				Email:     "E%C2%B5mail@email.com",
-				Country:   "Country%40",
+			userNormalizedExpected: User{
+				FirstName: "FirstName£",
+				LastName:  "Last§Name",
+				Nickname:  "]Nick name",
+				Password:  "Pass word",
+				Email:     "Eµmail@email.com",
+				Country:   "Country@",
+			},
+		},
+		//test the trimming and the NFC form, not on the password
+		{
+			user: User{
+				FirstName: " Jose\u0301 ",
+				LastName:  "\tLastName\n",
+				Nickname:  "Nickname ",
+				Password:  " Password ",
+				Email:     "Email@email.com",
+				Country:   "Cote\u0302",
+			},
+			userNormalizedExpected: User{
+				FirstName: "Jos\u00e9",
+				LastName:  "LastName",
+				Nickname:  "Nickname",
+				Password:  " Password ",
+				Email:     "Email@email.com",
+				Country:   "Cot\u00ea",
 			},
 		},
 	}
 
 	for _, item := range userModelTests {
-		item.user.Escape()
-		if !item.user.IsSoftEqual(&item.userEscapedExpected) {
-			t.Errorf("User.Escape output %v but expected %v", item.user, item.userEscapedExpected)
+		item.user.Normalize()
+		if !item.user.IsSoftEqual(&item.userNormalizedExpected) || item.user.Password != item.userNormalizedExpected.Password {
+			t.Errorf("User.Normalize output %v but expected %v", item.user, item.userNormalizedExpected)
 		}
 	}
 }
@@ -78,6 +99,32 @@ func TestValidator(t *testing.T) {
 			},
 			expectedErr: false,
 		},
+		//test with role
+		{
+			user: User{
+				FirstName: "FirstName",
+				LastName:  "LastName",
+				Nickname:  "Nickname",
+				Password:  "Password",
+				Email:     "Email@email.com",
+				Country:   "Country",
+				Role:      RoleSupport,
+			},
+			expectedErr: false,
+		},
+		//test unknown role
+		{
+			user: User{
+				FirstName: "FirstName",
+				LastName:  "LastName",
+				Nickname:  "Nickname",
+				Password:  "Password",
+				Email:     "Email@email.com",
+				Country:   "Country",
+				Role:      "superuser",
+			},
+			expectedErr: true,
+		},
 		//test one missing
 		{
 			user: User{
@@ -172,3 +219,25 @@ func TestValidator(t *testing.T) {
 		}
 	}
 }
+
+func TestValidatorFieldErrors(t *testing.T) {
+	u := User{
+		LastName: "LastName",
+		Nickname: "Nickname",
+		Email:    "Emailemailcom",
+		Role:     "superuser",
+	}
+	resultErr := u.Validate()
+	var fieldErrors errors2.FieldErrors
+	if !errors.As(resultErr, &fieldErrors) {
+		t.Fatalf("user.Validate for %v output err %v without the field errors", u, resultErr)
+	}
+	for _, field := range []string{"first_name", "password", "email", "country", "role"} {
+		if fieldErrors[field] == "" {
+			t.Errorf("user.Validate for %v output the field errors %v without %v", u, fieldErrors, field)
+		}
+	}
+	if len(fieldErrors) != 5 {
+		t.Errorf("user.Validate for %v output the field errors %v but expected 5", u, fieldErrors)
+	}
+}
@@ -0,0 +1,190 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-ozzo/ozzo-validation"
+	"github.com/go-ozzo/ozzo-validation/is"
+	"strings"
+	errors2 "test/errors"
+)
+
+//Implements the partial modification of a User
+
+const (
+	ContentTypeMergePatch = "application/merge-patch+json"
+	ContentTypeJSONPatch  = "application/json-patch+json"
+)
+
+var (
+	ErrPatchFormat    = errors2.BadRequest(errors.New("patch format error"))
+	ErrPatchOperation = errors2.BadRequest(errors.New("patch operation not supported, only add, replace and remove are"))
+)
+
+func ErrPatchValue(field string) error {
+	return errors2.Validation(errors2.FieldErrors{field: "value must be a string"})
+}
+
+// UserPatch holds the modified fields of a User, nil fields are left untouched.
+type UserPatch struct {
+	FirstName *string
+	LastName  *string
+	Nickname  *string
+	Password  *string
+	Email     *string
+	Country   *string
+	Role      *string
+}
+
+//fields maps the json names to the patchable fields
+func (p *UserPatch) fields() map[string]**string {
+	return map[string]**string{
+		"first_name": &p.FirstName,
+		"last_name":  &p.LastName,
+		"nickname":   &p.Nickname,
+		"password":   &p.Password,
+		"email":      &p.Email,
+		"country":    &p.Country,
+		"role":       &p.Role,
+	}
+}
+
+// ParseMergePatch reads a JSON Merge Patch (RFC 7396).
+// Every field is required so removing one (null) is a validation error, the unknown fields are ignored.
+func ParseMergePatch(body []byte) (UserPatch, error) {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(body, &members); err != nil || members == nil {
+		return UserPatch{}, ErrPatchFormat
+	}
+
+	var p UserPatch
+	fields := p.fields()
+	for name, raw := range members {
+		field, ok := fields[name]
+		if !ok {
+			continue
+		}
+		if err := setPatchValue(field, name, raw); err != nil {
+			return UserPatch{}, err
+		}
+	}
+	return p, nil
+}
+
+type jsonPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// ParseJSONPatch reads a JSON Patch (RFC 6902) made of add, replace and remove operations on the User fields.
+func ParseJSONPatch(body []byte) (UserPatch, error) {
+	var operations []jsonPatchOperation
+	if err := json.Unmarshal(body, &operations); err != nil {
+		return UserPatch{}, ErrPatchFormat
+	}
+
+	var p UserPatch
+	fields := p.fields()
+	for _, operation := range operations {
+		name := strings.TrimPrefix(operation.Path, "/")
+		field, ok := fields[name]
+		if !ok || !strings.HasPrefix(operation.Path, "/") {
+			return UserPatch{}, errors2.BadRequest(errors.New("patch path " + operation.Path + " not supported"))
+		}
+		switch operation.Op {
+		case "add", "replace":
+			if operation.Value == nil {
+				return UserPatch{}, ErrPatchValue(name)
+			}
+			if err := setPatchValue(field, name, operation.Value); err != nil {
+				return UserPatch{}, err
+			}
+		case "remove":
+			return UserPatch{}, ErrRequiredValue(name)
+		default:
+			return UserPatch{}, ErrPatchOperation
+		}
+	}
+	return p, nil
+}
+
+func setPatchValue(field **string, name string, raw json.RawMessage) error {
+	if string(raw) == "null" {
+		return ErrRequiredValue(name)
+	}
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return ErrPatchValue(name)
+	}
+	*field = &value
+	return nil
+}
+
+//Normalize the modified fields like User.Normalize
+func (p *UserPatch) Normalize() {
+	for name, field := range p.fields() {
+		if *field == nil || name == "password" || name == "role" {
+			continue
+		}
+		normalized := NormalizeString(**field)
+		*field = &normalized
+	}
+}
+
+// Validate validates only the modified fields and returns all their errors as errors.FieldErrors.
+func (p *UserPatch) Validate() error {
+	fieldErrors := errors2.FieldErrors{}
+	fields := p.fields()
+	for _, name := range []string{"first_name", "last_name", "nickname", "password", "email", "country"} {
+		field := *fields[name]
+		if field != nil && *field == "" {
+			fieldErrors[name] = ErrMessageRequired
+		}
+	}
+	if p.Email != nil && *p.Email != "" {
+		if err := validation.Validate(*p.Email, is.Email); err != nil {
+			fieldErrors["email"] = err.Error()
+		}
+	}
+	if p.Role != nil {
+		if err := validation.Validate(*p.Role, validation.Required, validation.In(RoleAdmin, RoleSupport, RoleSelf)); err != nil {
+			fieldErrors["role"] = err.Error()
+		}
+	}
+	if len(fieldErrors) > 0 {
+		return errors2.Validation(fieldErrors)
+	}
+	return nil
+}
+
+//apply sets the modified fields on the User
+func (p *UserPatch) apply(u *User) {
+	for _, value := range []struct {
+		patch  *string
+		target *string
+	}{
+		{p.FirstName, &u.FirstName},
+		{p.LastName, &u.LastName},
+		{p.Nickname, &u.Nickname},
+		{p.Password, &u.Password},
+		{p.Email, &u.Email},
+		{p.Country, &u.Country},
+		{p.Role, &u.Role},
+	} {
+		if value.patch != nil {
+			*value.target = *value.patch
+		}
+	}
+}
+
+//set returns the modified fields by their bson name
+func (p *UserPatch) set() map[string]interface{} {
+	set := make(map[string]interface{})
+	for name, field := range p.fields() {
+		if *field != nil {
+			set[name] = **field
+		}
+	}
+	return set
+}
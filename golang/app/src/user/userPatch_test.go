@@ -0,0 +1,118 @@
+package user
+
+import (
+	"testing"
+)
+
+type userPatchParseTest struct {
+	contentType string
+	body        string
+	expected    map[string]interface{}
+	expectedErr bool
+}
+
+func TestParsePatch(t *testing.T) {
+	userPatchParseTests := []userPatchParseTest{
+		//test merge patch normal behaviors
+		{
+			contentType: ContentTypeMergePatch,
+			body:        `{"last_name":"Longbow"}`,
+			expected:    map[string]interface{}{"last_name": "Longbow"},
+			expectedErr: false,
+		},
+		{
+			contentType: ContentTypeMergePatch,
+			body:        `{"first_name":"Mike","password":"dad154","id":"61e41ed578752c5997718aff"}`,
+			expected:    map[string]interface{}{"first_name": "Mike", "password": "dad154"},
+			expectedErr: false,
+		},
+		{
+			contentType: ContentTypeMergePatch,
+			body:        `{}`,
+			expected:    map[string]interface{}{},
+			expectedErr: false,
+		},
+		//test merge patch removing a required field
+		{contentType: ContentTypeMergePatch, body: `{"email":null}`, expectedErr: true},
+		//test merge patch wrong values
+		{contentType: ContentTypeMergePatch, body: `{"country":12}`, expectedErr: true},
+		{contentType: ContentTypeMergePatch, body: `["country"]`, expectedErr: true},
+		{contentType: ContentTypeMergePatch, body: `null`, expectedErr: true},
+		//test json patch normal behaviors
+		{
+			contentType: ContentTypeJSONPatch,
+			body:        `[{"op":"replace","path":"/last_name","value":"Longbow"},{"op":"add","path":"/country","value":"UK"}]`,
+			expected:    map[string]interface{}{"last_name": "Longbow", "country": "UK"},
+			expectedErr: false,
+		},
+		//test json patch wrong operations
+		{contentType: ContentTypeJSONPatch, body: `[{"op":"remove","path":"/email"}]`, expectedErr: true},
+		{contentType: ContentTypeJSONPatch, body: `[{"op":"move","from":"/email","path":"/nickname"}]`, expectedErr: true},
+		{contentType: ContentTypeJSONPatch, body: `[{"op":"replace","path":"/id","value":"61e41ed578752c5997718aff"}]`, expectedErr: true},
+		{contentType: ContentTypeJSONPatch, body: `[{"op":"replace","path":"/last_name"}]`, expectedErr: true},
+		{contentType: ContentTypeJSONPatch, body: `{"last_name":"Longbow"}`, expectedErr: true},
+	}
+
+	for _, item := range userPatchParseTests {
+		var patch UserPatch
+		var resultErr error
+		if item.contentType == ContentTypeJSONPatch {
+			patch, resultErr = ParseJSONPatch([]byte(item.body))
+		} else {
+			patch, resultErr = ParseMergePatch([]byte(item.body))
+		}
+		if !item.expectedErr && resultErr != nil {
+			t.Errorf("Parse patch for %v output err %v not expected", item.body, resultErr.Error())
+		}
+		if item.expectedErr && resultErr == nil {
+			t.Errorf("Parse patch for %v output no err but one was expected", item.body)
+		}
+		if resultErr != nil {
+			continue
+		}
+		set := patch.set()
+		if len(set) != len(item.expected) {
+			t.Errorf("Parse patch for %v output %v but expected %v", item.body, set, item.expected)
+		}
+		for name, value := range item.expected {
+			if set[name] != value {
+				t.Errorf("Parse patch for %v output %v but expected %v", item.body, set, item.expected)
+			}
+		}
+	}
+}
+
+type userPatchValidateTest struct {
+	body        string
+	expectedErr bool
+}
+
+func TestPatchValidate(t *testing.T) {
+	userPatchValidateTests := []userPatchValidateTest{
+		//test normal behaviors, the untouched fields are not required
+		{body: `{"last_name":"Longbow"}`, expectedErr: false},
+		{body: `{"email":"miky@ggmail.com","role":"support"}`, expectedErr: false},
+		{body: `{}`, expectedErr: false},
+		//test empty values
+		{body: `{"first_name":""}`, expectedErr: true},
+		{body: `{"password":""}`, expectedErr: true},
+		//test invalid values
+		{body: `{"email":"mikyggmail.com"}`, expectedErr: true},
+		{body: `{"role":"superuser"}`, expectedErr: true},
+	}
+
+	for _, item := range userPatchValidateTests {
+		patch, err := ParseMergePatch([]byte(item.body))
+		if err != nil {
+			t.Errorf("ParseMergePatch for %v output err %v not expected", item.body, err.Error())
+			continue
+		}
+		resultErr := patch.Validate()
+		if !item.expectedErr && resultErr != nil {
+			t.Errorf("UserPatch.Validate for %v output err %v not expected", item.body, resultErr.Error())
+		}
+		if item.expectedErr && resultErr == nil {
+			t.Errorf("UserPatch.Validate for %v output no err but one was expected", item.body)
+		}
+	}
+}
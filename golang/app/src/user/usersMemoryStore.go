@@ -0,0 +1,508 @@
+package user
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	errors2 "test/errors"
+	"time"
+)
+
+// ErrDuplicateValue is the error of a nickname or an email already used by another User, as a field error.
+func ErrDuplicateValue(field string) error {
+	return errors2.Conflict(errors2.FieldErrors{field: "value already used"})
+}
+
+// UsersMemoryStore implements the UserRepository in memory, with the same semantics as UsersStore.
+// It is safe for concurrent use.
+type UsersMemoryStore struct {
+	mu     sync.RWMutex
+	users  map[string]User
+	hasher PasswordHasher
+	Fuzzy  FuzzyOptions //Users returned by a fuzzy lookup
+	outbox *OutboxMemoryStore
+	audit  *AuditMemoryStore
+	dummy  string //compared for an unknown login
+}
+
+// NewUsersMemoryStore returns an empty UsersMemoryStore, the passwords are saved hashed by the hasher
+func NewUsersMemoryStore(hasher PasswordHasher) *UsersMemoryStore {
+	return &UsersMemoryStore{
+		users:  make(map[string]User),
+		hasher: hasher,
+		Fuzzy:  DefaultFuzzy,
+		outbox: NewOutboxMemoryStore(),
+		audit:  NewAuditMemoryStore(),
+		dummy:  newDummyHash(hasher),
+	}
+}
+
+// Outbox returns the Events saved with the changes of the Users, under the same lock.
+func (s *UsersMemoryStore) Outbox() Outbox {
+	return s.outbox
+}
+
+// Audit returns the audit trail of the changes of the Users, appended under the same lock.
+func (s *UsersMemoryStore) Audit() AuditStore {
+	return s.audit
+}
+
+//record saves the Event and the audit entry of the change made by the actor, the lock must be held
+func (s *UsersMemoryStore) record(action AuditAction, actor string, before, after *User) {
+	s.outbox.add(newChangeEvent(action, before, after))
+	s.audit.Append(NewAuditEntry(action, actor, before, after))
+}
+
+// Create creates a new User, with its UserCreated Event in the outbox and its audit entry.
+func (s *UsersMemoryStore) Create(actor string, u *User) error {
+	//same precision as a mongo date
+	now := time.Now().Truncate(time.Millisecond)
+	u.ID = ""
+	u.CreatedAt = now
+	u.UpdatedAt = now
+	u.Version = 1
+	if u.Role == "" {
+		u.Role = RoleSelf
+	}
+	err := u.Validate()
+	if err != nil {
+		return err
+	}
+	u.Password, err = s.hasher.Hash(u.Password)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkUnique(u, ""); err != nil {
+		return err
+	}
+	u.ID = primitive.NewObjectID().Hex()
+	s.users[u.ID] = *u
+	s.record(AuditCreate, actor, nil, u)
+	return nil
+}
+
+// Get returns the User from its id, mongo.ErrNoDocuments if there is none.
+func (s *UsersMemoryStore) Get(id string) (*User, error) {
+	if _, err := ParseID(id); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, ok := s.users[id]
+	if !ok || u.DeletedAt != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	return &u, nil
+}
+
+// Update update an existing User at the version, with its UserUpdated Event in the outbox and its audit entry.
+func (s *UsersMemoryStore) Update(actor, id string, version int64, u *User) error {
+	u.UpdatedAt = time.Now().Truncate(time.Millisecond)
+
+	err := u.Validate()
+	if err != nil {
+		return err
+	}
+
+	if _, err := ParseID(id); err != nil {
+		return err
+	}
+	u.Password, err = rehashPassword(s.hasher, s.storedPassword(id), u.Password)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, err := s.getAt(id, version)
+	if err != nil {
+		return err
+	}
+	before := stored
+	if err := s.checkUnique(u, id); err != nil {
+		return err
+	}
+	//Do not allow to directly modify id, created_at and updated_at
+	stored.FirstName = u.FirstName
+	stored.LastName = u.LastName
+	stored.Nickname = u.Nickname
+	stored.Password = u.Password
+	stored.Email = u.Email
+	stored.Country = u.Country
+	//without role the current one is kept
+	if u.Role != "" {
+		stored.Role = u.Role
+	}
+	stored.UpdatedAt = u.UpdatedAt
+	stored.Version++
+	s.users[id] = stored
+	s.record(AuditUpdate, actor, &before, &stored)
+
+	*u = stored
+	return nil
+}
+
+// Patch updates only the fields of the patch on an existing User at the version, the updated User is set in u.
+// Its UserUpdated Event is saved in the outbox, with its audit entry.
+func (s *UsersMemoryStore) Patch(actor, id string, version int64, patch UserPatch, u *User) error {
+	err := patch.Validate()
+	if err != nil {
+		return err
+	}
+
+	if _, err := ParseID(id); err != nil {
+		return err
+	}
+	if patch.Password != nil {
+		hash, err := rehashPassword(s.hasher, s.storedPassword(id), *patch.Password)
+		if err != nil {
+			return err
+		}
+		patch.Password = &hash
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, err := s.getAt(id, version)
+	if err != nil {
+		return err
+	}
+	before := stored
+	patch.apply(&stored)
+	if err := s.checkUnique(&stored, id); err != nil {
+		return err
+	}
+	stored.UpdatedAt = time.Now().Truncate(time.Millisecond)
+	stored.Version++
+	s.users[id] = stored
+	s.record(AuditUpdate, actor, &before, &stored)
+
+	*u = stored
+	return nil
+}
+
+// Delete marks a User deleted from its id at the version, it is hidden until it is restored or purged.
+// Its UserDeleted Event is saved in the outbox, with its audit entry.
+func (s *UsersMemoryStore) Delete(actor, id string, version int64) error {
+	if _, err := ParseID(id); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, err := s.getAt(id, version)
+	if err != nil {
+		return err
+	}
+	before := stored
+	now := time.Now().Truncate(time.Millisecond)
+	stored.DeletedAt = &now
+	stored.Version++
+	s.users[id] = stored
+	s.record(AuditDelete, actor, &before, &stored)
+	return nil
+}
+
+//storedPassword returns the saved password of the User, "" when there is none
+func (s *UsersMemoryStore) storedPassword(id string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.users[id].Password
+}
+
+//getAt returns the stored User not deleted at the version, the lock must be held
+func (s *UsersMemoryStore) getAt(id string, version int64) (User, error) {
+	stored, ok := s.users[id]
+	if !ok || stored.DeletedAt != nil {
+		return User{}, mongo.ErrNoDocuments
+	}
+	if version != AnyVersion && stored.Version != version {
+		return User{}, ErrVersionMismatch
+	}
+	return stored, nil
+}
+
+// Restore brings back a deleted User, the restored User is set in u.
+// Its UserUpdated Event is saved in the outbox, with its audit entry.
+func (s *UsersMemoryStore) Restore(actor, id string, u *User) error {
+	if _, err := ParseID(id); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.users[id]
+	if !ok || stored.DeletedAt == nil {
+		return mongo.ErrNoDocuments
+	}
+	before := stored
+	stored.DeletedAt = nil
+	stored.UpdatedAt = time.Now().Truncate(time.Millisecond)
+	stored.Version++
+	s.users[id] = stored
+	s.record(AuditRestore, actor, &before, &stored)
+
+	*u = stored
+	return nil
+}
+
+// Purge removes for good the Users deleted before the time, and returns how many were removed.
+func (s *UsersMemoryStore) Purge(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purged := 0
+	for id, u := range s.users {
+		if u.DeletedAt != nil && !u.DeletedAt.After(before) {
+			delete(s.users, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// Return a List of User filtered, according to the page and page_size or the cursor required, with the total of filtered Users.
+func (s *UsersMemoryStore) List(filter UserFilter, opts ListOptions) (*UsersPage, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+	if err := checkSearchSort(filter, opts); err != nil {
+		return nil, err
+	}
+	matchers, err := compileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	var uList []User
+	for _, u := range s.users {
+		//a search matches the Users with one of its words, scored by the fields they are in
+		if filter.Search != "" {
+			u.Score = searchScore(&u, filter.Search)
+			if u.Score == 0 {
+				continue
+			}
+		}
+		if (opts.IncludeDeleted || u.DeletedAt == nil) && matchAll(&u, matchers) {
+			uList = append(uList, u)
+		}
+	}
+	s.mu.RUnlock()
+
+	//a fuzzy lookup keeps the Users most similar to the value among the candidates, scored by their similarity
+	if filter.Fuzzy != "" {
+		uList = s.Fuzzy.rank(s.Fuzzy.preselect(uList, filter.Fuzzy), filter.Fuzzy)
+	}
+	return paginate(uList, opts), nil
+}
+
+//paginate orders the filtered Users, then returns the page of the options, as UsersStore.List does in the database
+func paginate(uList []User, opts ListOptions) *UsersPage {
+	//order by the sort fields, the id breaks the ties
+	listSort := opts.sort()
+	sort.Slice(uList, func(i, j int) bool { return listSort.less(&uList[i], &uList[j]) })
+
+	//the total ignores the pagination
+	total := len(uList)
+	if opts.Cursor != nil {
+		//seeks after the cursor position
+		start := sort.Search(len(uList), func(i int) bool { return opts.Cursor.isAfter(&uList[i], listSort) })
+		uList = uList[start:]
+	} else if opts.PageSize > 0 {
+		//rmq page start at 0
+		skip := opts.PageSize * opts.Page
+		if skip >= int64(len(uList)) {
+			skip = int64(len(uList))
+		}
+		uList = uList[skip:]
+	}
+	//a page_size of 0 means no limit, one more User tells if there is a next page
+	if opts.PageSize > 0 && int64(len(uList)) > opts.PageSize+1 {
+		uList = uList[:opts.PageSize+1]
+	}
+	//keeps only the selected fields, and those of the sort for the cursor
+	if fields := opts.projectionFields(); fields != nil {
+		for i := range uList {
+			uList[i] = project(uList[i], fields)
+		}
+	}
+	return newUsersPage(uList, total, opts)
+}
+
+// VerifyPassword returns the User with this nickname or email if the password matches.
+// An unknown login is compared to a dummy hash, so it takes as long to answer as a wrong password.
+// The slow comparison is made on a copy of the User without holding the lock.
+func (s *UsersMemoryStore) VerifyPassword(login, password string) (*User, error) {
+	u, found := s.findLogin(login)
+	if !found {
+		_ = verifyStoredPassword(s.hasher, s.dummy, password)
+		return nil, ErrInvalidCredentials
+	}
+	if err := verifyStoredPassword(s.hasher, u.Password, password); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+//findLogin returns a copy of the User not deleted with this nickname or email
+func (s *UsersMemoryStore) findLogin(login string) (User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, u := range s.users {
+		if (u.Nickname == login || u.Email == login) && u.DeletedAt == nil {
+			return u, true
+		}
+	}
+	return User{}, false
+}
+
+//checkUnique verifies no other User than the one with ignoredId uses the nickname or the email
+func (s *UsersMemoryStore) checkUnique(u *User, ignoredId string) error {
+	for id, stored := range s.users {
+		if id == ignoredId {
+			continue
+		}
+		if stored.Email == u.Email {
+			return ErrDuplicateValue("email")
+		}
+		if stored.Nickname == u.Nickname {
+			return ErrDuplicateValue("nickname")
+		}
+	}
+	return nil
+}
+
+//userMatcher reports if a User matches a Condition
+type userMatcher func(u *User) bool
+
+//compileFilter returns a matcher by Condition of the filter, the regular expressions and the times are parsed once
+func compileFilter(f UserFilter) ([]userMatcher, error) {
+	var matchers []userMatcher
+	for _, c := range f.Conditions {
+		matcher, err := compileCondition(c)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, matcher)
+	}
+	return matchers, nil
+}
+
+func compileCondition(c Condition) (userMatcher, error) {
+	if isTimeField(c.Field) {
+		times := make([]time.Time, len(c.Values))
+		for i, value := range c.Values {
+			if value != "" {
+				times[i], _ = time.Parse(time.RFC3339, value)
+			}
+		}
+		if c.Field == "created_at" {
+			return func(u *User) bool { return matchTime(c.Op, u.CreatedAt, times) }, nil
+		}
+		return func(u *User) bool { return matchTime(c.Op, u.UpdatedAt, times) }, nil
+	}
+
+	var pattern *regexp.Regexp
+	if c.Op == OpRegex {
+		flags := ""
+		if c.CaseInsensitive {
+			flags = "(?i)"
+		}
+		var err error
+		pattern, err = regexp.Compile(flags + c.Values[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+	//a case insensitive Condition compares the lower case values
+	value := func(field string, u *User) string { return fieldValues[field](u) }
+	if c.CaseInsensitive {
+		lowerValues := make([]string, len(c.Values))
+		for i := range c.Values {
+			lowerValues[i] = strings.ToLower(c.Values[i])
+		}
+		c.Values = lowerValues
+		value = func(field string, u *User) string { return strings.ToLower(fieldValues[field](u)) }
+	}
+	//the text is searched in any of the text fields
+	fields := []string{c.Field}
+	if c.Field == TextField {
+		fields = TextFields
+	}
+	return func(u *User) bool {
+		for _, field := range fields {
+			if matchString(c, value(field, u), pattern) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+func matchString(c Condition, value string, pattern *regexp.Regexp) bool {
+	switch c.Op {
+	case OpEq:
+		return value == c.Values[0]
+	case OpPrefix:
+		return strings.HasPrefix(value, c.Values[0])
+	case OpContains:
+		return strings.Contains(value, c.Values[0])
+	case OpIn:
+		for _, v := range c.Values {
+			if value == v {
+				return true
+			}
+		}
+		return false
+	case OpNot:
+		return value != c.Values[0]
+	case OpRange:
+		return (c.Values[0] == "" || value >= c.Values[0]) && (c.Values[1] == "" || value <= c.Values[1])
+	case OpRegex:
+		return pattern.MatchString(value)
+	}
+	return false
+}
+
+//matchTime compares the value to the times of the Condition, a zero time is an open bound of a range
+func matchTime(op Operator, value time.Time, times []time.Time) bool {
+	switch op {
+	case OpEq:
+		return value.Equal(times[0])
+	case OpIn:
+		for _, t := range times {
+			if value.Equal(t) {
+				return true
+			}
+		}
+		return false
+	case OpNot:
+		return !value.Equal(times[0])
+	case OpRange:
+		return (times[0].IsZero() || !value.Before(times[0])) && (times[1].IsZero() || !value.After(times[1]))
+	}
+	return false
+}
+
+func matchAll(u *User, matchers []userMatcher) bool {
+	for _, match := range matchers {
+		if !match(u) {
+			return false
+		}
+	}
+	return true
+}
@@ -4,27 +4,37 @@ import (
 	"errors"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/render"
+	"io/ioutil"
+	"mime"
 	"net/http"
 	"net/url"
-	"strings"
+	"strconv"
+	errors2 "test/errors"
 	"test/utils"
+	"time"
 )
 
 //Implements the User management handler
 
 var (
-	ErrParamDate = errors.New("Date format error")
+	ErrPatchContentType = errors.New("Content-Type must be " + ContentTypeMergePatch + " or " + ContentTypeJSONPatch)
+	ErrPatchRole        = errors.New("role can only be changed by an admin")
+	ErrIncludeDeleted   = errors.New("deleted users can only be listed by an admin")
+	ErrRedactedQuery    = errors.New("redacted fields can't be filtered, sorted nor searched on, neither by text nor q")
 )
 
-// UsersStore implements User management handler.
+// UsersResource implements User management handler.
 type UsersResource struct {
-	Store UsersStore
+	Store  UserRepository
+	Policy Policy
 }
 
-// NewUsersStore creates and returns a User resource.
-func NewUsersResource(store UsersStore) *UsersResource {
+// NewUsersResource creates and returns a User resource backed by any UserRepository, the access is decided by the Policy.
+// The store saves every change with its Event in the Outbox and its entry in the audit trail.
+func NewUsersResource(store UserRepository, policy Policy) *UsersResource {
 	return &UsersResource{
-		Store: store,
+		Store:  store,
+		Policy: policy,
 	}
 }
 
@@ -34,8 +44,12 @@ func (rs *UsersResource) Router() *chi.Mux {
 	r.Get("/", rs.list)
 	r.Post("/", rs.create)
 	r.Route("/{userID}", func(r chi.Router) {
+		r.Get("/", rs.get)
 		r.Put("/", rs.update)
+		r.Patch("/", rs.patch)
 		r.Delete("/", rs.delete)
+		r.Post("/restore", rs.restore)
+		r.Get("/history", rs.history)
 	})
 	return r
 }
@@ -43,160 +57,414 @@ func (rs *UsersResource) Router() *chi.Mux {
 // Request expected
 type userRequest struct {
 	User
+	Password string `json:"password"` //the User password is never (de)serialized
 }
 
 //Binding of the http request to the userRequest
 func (ur *userRequest) Bind(r *http.Request) error {
+	ur.User.Password = ur.Password
 	return nil
 }
 
-//Response model for one User
+//Response model for one User, restricted to the selected fields
 type userResponse struct {
 	success bool
-	*User
+	userFields
 }
 
 //Response model for multiple User
 type userListResponse struct {
-	success bool
-	Users   []User `json:"users"`
-	Count   int    `json:"count"`
+	success    bool
+	Users      []userFields `json:"users"`
+	Count      int          `json:"count"` //number of Users in the page
+	Total      int          `json:"total"` //number of Users matching the filters
+	Page       int64        `json:"page"`
+	PageSize   int64        `json:"page_size"`
+	HasNext    bool         `json:"has_next"`
+	NextCursor string       `json:"next_cursor,omitempty"` //to request the next page with cursor
+	Links      pageLinks    `json:"links"`
+}
+
+//Links to the pages around the current one
+type pageLinks struct {
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
 }
 
-func newUserResponse(u *User, success bool) *userResponse {
-	resp := &userResponse{success: success, User: u}
+func newUserResponse(u *User, fields []string, success bool) *userResponse {
+	resp := &userResponse{success: success, userFields: userFields{User: u, Fields: fields}}
 	return resp
 }
 
-func newUsersListResponse(u []User, count int, success bool) *userListResponse {
+func newUsersListResponse(usersPage *UsersPage, opts ListOptions, requestURL *url.URL, success bool) *userListResponse {
+	//an empty page is an empty array
+	u := make([]userFields, 0, len(usersPage.Users))
+	for i := range usersPage.Users {
+		u = append(u, userFields{User: &usersPage.Users[i], Fields: opts.Fields})
+	}
 	resp := &userListResponse{
-		success: success,
-		Users:   u,
-		Count:   count,
+		success:    success,
+		Users:      u,
+		Count:      len(u),
+		Total:      usersPage.Total,
+		Page:       opts.Page,
+		PageSize:   opts.PageSize,
+		HasNext:    usersPage.HasNext,
+		NextCursor: usersPage.NextCursor,
+	}
+	//a cursor only goes forward
+	if opts.Cursor != nil {
+		if resp.HasNext {
+			resp.Links.Next = pageURL(requestURL, "cursor", resp.NextCursor)
+		}
+		return resp
+	}
+	if resp.HasNext {
+		resp.Links.Next = pageURL(requestURL, "page", strconv.FormatInt(opts.Page+1, 10))
+	}
+	if opts.PageSize > 0 && opts.Page > 0 {
+		resp.Links.Prev = pageURL(requestURL, "page", strconv.FormatInt(opts.Page-1, 10))
 	}
 	return resp
 }
 
+//pageURL returns the request URL for another page, with the key parameter set to value
+func pageURL(requestURL *url.URL, key, value string) string {
+	query := requestURL.Query()
+	query.Set(key, value)
+	link := url.URL{Path: requestURL.Path, RawQuery: query.Encode()}
+	return link.String()
+}
+
+//authorize checks the Policy for the action on the User targetID ("" for none), the denial is rendered
+func (rs *UsersResource) authorize(w http.ResponseWriter, r *http.Request, action Action, targetID string) (Permission, bool) {
+	principal, _ := PrincipalFromContext(r.Context())
+	permission, err := rs.Policy.Authorize(principal, action)
+	if err == nil && targetID != "" && !permission.Allows(principal, targetID) {
+		err = ErrForbiddenOtherUser
+	}
+	if err != nil {
+		utils.RenderForbidden(w, r, err)
+		return permission, false
+	}
+	return permission, true
+}
+
+//actor returns the id of the Principal making the change, recorded in the audit trail
+func actor(r *http.Request) string {
+	if principal, ok := PrincipalFromContext(r.Context()); ok && principal != nil {
+		return principal.ID
+	}
+	return ""
+}
+
+//setETag sets the ETag header of the User version
+func setETag(w http.ResponseWriter, u *User) {
+	w.Header().Set("ETag", ETag(u.Version))
+}
+
 // Adds a new User
 func (rs *UsersResource) create(w http.ResponseWriter, r *http.Request) {
-	//binds body request to User
+	permission, ok := rs.authorize(w, r, ActionCreate, "")
+	if !ok {
+		return
+	}
 
+	//binds body request to User
 	uR := &userRequest{}
 	if err := render.Bind(r, uR); err != nil {
-		utils.Render(w, r, err)
+		utils.Render(w, r, errors2.BadRequest(err))
 		return
 	}
 	u := uR.User
-	u.Escape()
+	u.Normalize()
+	if !permission.ManageRoles {
+		u.Role = ""
+	}
 	//creates it
-	err := rs.Store.Create(&u)
+	err := rs.Store.Create(actor(r), &u)
 	if err != nil {
 		utils.Render(w, r, err)
 		return
 	}
-	SendNotification("Created", u)
-	render.Respond(w, r, newUserResponse(&u, true))
+	setETag(w, &u)
+	render.Respond(w, r, newUserResponse(&u, nil, true))
+}
+
+// Returns one User
+func (rs *UsersResource) get(w http.ResponseWriter, r *http.Request) {
+	//gets User ID from URL Parameters
+	id := chi.URLParam(r, "userID")
+	permission, ok := rs.authorize(w, r, ActionRead, id)
+	if !ok {
+		return
+	}
+
+	fields, err := fieldsFromQuery(r.URL.Query())
+	if err != nil {
+		utils.Render(w, r, err)
+		return
+	}
+
+	u, err := rs.Store.Get(id)
+	if err != nil {
+		utils.Render(w, r, err)
+		return
+	}
+
+	if permission.Redacted {
+		u.Redact()
+	}
+	etag := userETag(u, fields, permission.Redacted)
+	w.Header().Set("ETag", etag)
+	setVary(w)
+	setLastModified(w, u)
+	//the client already has this representation
+	if NotModified(r.Header, etag, u.UpdatedAt) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	render.Respond(w, r, newUserResponse(u, fields, true))
 }
 
 // Update an already existing User
 func (rs *UsersResource) update(w http.ResponseWriter, r *http.Request) {
 	//gets User ID from URL Parameters
 	id := chi.URLParam(r, "userID")
+	permission, ok := rs.authorize(w, r, ActionUpdate, id)
+	if !ok {
+		return
+	}
 
 	//binds body request to User
 	uR := &userRequest{}
 	if err := render.Bind(r, uR); err != nil {
-
-		utils.Render(w, r, err)
+		utils.Render(w, r, errors2.BadRequest(err))
 		return
 	}
 	u := uR.User
-	u.Escape()
+	u.Normalize()
+	if !permission.ManageRoles {
+		u.Role = ""
+	}
 
+	//the version the change is made on
+	current, err := rs.Store.Get(id)
+	if err != nil {
+		utils.Render(w, r, err)
+		return
+	}
+	version, err := MatchIfMatch(r.Header.Get("If-Match"), current.Version)
+	if err != nil {
+		utils.Render(w, r, err)
+		return
+	}
 	//update it
-	err := rs.Store.Update(id, &u)
+	err = rs.Store.Update(actor(r), id, version, &u)
+	if err != nil {
+		utils.Render(w, r, err)
+		return
+	}
+	setETag(w, &u)
+	render.Respond(w, r, newUserResponse(&u, nil, true))
+}
+
+// Update only some fields of an already existing User, from a JSON Merge Patch or a JSON Patch
+func (rs *UsersResource) patch(w http.ResponseWriter, r *http.Request) {
+	//gets User ID from URL Parameters
+	id := chi.URLParam(r, "userID")
+	permission, ok := rs.authorize(w, r, ActionUpdate, id)
+	if !ok {
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		utils.Render(w, r, errors2.BadRequest(err))
+		return
+	}
+	//parses the body according to its content type
+	var patch UserPatch
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch contentType {
+	case ContentTypeMergePatch, "application/json":
+		patch, err = ParseMergePatch(body)
+	case ContentTypeJSONPatch:
+		patch, err = ParseJSONPatch(body)
+	default:
+		utils.RenderUnsupportedMediaType(w, r, ErrPatchContentType)
+		return
+	}
+	if err != nil {
+		utils.Render(w, r, err)
+		return
+	}
+	if patch.Role != nil && !permission.ManageRoles {
+		utils.RenderForbidden(w, r, ErrPatchRole)
+		return
+	}
+	patch.Normalize()
+
+	//the version the change is made on
+	current, err := rs.Store.Get(id)
 	if err != nil {
 		utils.Render(w, r, err)
 		return
 	}
-	SendNotification("Updated", u)
-	render.Respond(w, r, newUserResponse(&u, true))
+	version, err := MatchIfMatch(r.Header.Get("If-Match"), current.Version)
+	if err != nil {
+		utils.Render(w, r, err)
+		return
+	}
+	//update only the patched fields
+	var u User
+	err = rs.Store.Patch(actor(r), id, version, patch, &u)
+	if err != nil {
+		utils.Render(w, r, err)
+		return
+	}
+	setETag(w, &u)
+	render.Respond(w, r, newUserResponse(&u, nil, true))
 }
 
 // Deletes User
 func (rs *UsersResource) delete(w http.ResponseWriter, r *http.Request) {
 	//gets User ID from URL Parameters
 	id := chi.URLParam(r, "userID")
+	if _, ok := rs.authorize(w, r, ActionDelete, id); !ok {
+		return
+	}
 
+	//the version the change is made on
+	current, err := rs.Store.Get(id)
+	if err != nil {
+		utils.Render(w, r, err)
+		return
+	}
+	version, err := MatchIfMatch(r.Header.Get("If-Match"), current.Version)
+	if err != nil {
+		utils.Render(w, r, err)
+		return
+	}
 	//delete it
-	if err := rs.Store.Delete(id); err != nil {
+	if err := rs.Store.Delete(actor(r), id, version); err != nil {
 		utils.Render(w, r, err)
 		return
 	}
-	SendNotification("Deleted", User{ID: id})
 
-	render.Respond(w, r, newUserResponse(&User{ID: id}, true))
+	render.Respond(w, r, newUserResponse(&User{ID: id}, nil, true))
 }
 
-// Returns filtered User in a list
-func (rs *UsersResource) list(w http.ResponseWriter, r *http.Request) {
-	//get the url query
-	query := r.URL.Query()
+// Brings back a deleted User
+func (rs *UsersResource) restore(w http.ResponseWriter, r *http.Request) {
+	//gets User ID from URL Parameters
+	id := chi.URLParam(r, "userID")
+	if _, ok := rs.authorize(w, r, ActionRestore, id); !ok {
+		return
+	}
 
-	//parses parameters from query
-	textS := utils.StringFromQuery("text", query)
-	strings.ReplaceAll(url.QueryEscape(textS), "%40", "@") //for email
-	idS := utils.StringFromQuery("id", query)
-	firstNameS := utils.StringFromQuery("first_name", query)
-	lastNameS := utils.StringFromQuery("last_name", query)
-	nicknameS := utils.StringFromQuery("nickname", query)
-	passwordS := utils.StringFromQuery("password", query)
-	emailS := utils.StringFromQuery("email", query)
-	strings.ReplaceAll(url.QueryEscape(emailS), "%40", "@") //for email validation
-	countryS := utils.StringFromQuery("country", query)
-	startDateCreated, err := utils.DateFromQuery("startdcreated", query)
-	if err != nil {
-		utils.Render(w, r, ErrParamDate)
+	var u User
+	if err := rs.Store.Restore(actor(r), id, &u); err != nil {
+		utils.Render(w, r, err)
 		return
 	}
-	endDateCreated, err := utils.DateFromQuery("enddcreated", query)
-	if err != nil {
-		utils.Render(w, r, ErrParamDate)
+	setETag(w, &u)
+	render.Respond(w, r, newUserResponse(&u, nil, true))
+}
+
+// Returns the audit trail of a User, the most recent change first
+func (rs *UsersResource) history(w http.ResponseWriter, r *http.Request) {
+	//gets User ID from URL Parameters
+	id := chi.URLParam(r, "userID")
+	permission, ok := rs.authorize(w, r, ActionRead, id)
+	if !ok {
 		return
 	}
-	startDateUpdated, err := utils.DateFromQuery("startdupdated", query)
-	if err != nil {
-		utils.Render(w, r, ErrParamDate)
+	if _, err := ParseID(id); err != nil {
+		utils.Render(w, r, err)
 		return
 	}
-	endDateUpdated, err := utils.DateFromQuery("enddupdated", query)
+
+	q, err := DecodeAuditQuery(r.URL.Query())
 	if err != nil {
-		utils.Render(w, r, ErrParamDate)
+		utils.Render(w, r, err)
 		return
 	}
-	//get page (number) and page_size
-	page, err := utils.Int64FromQuery("page", query)
+	//the history of a deleted User stays readable
+	q.UserID = id
+	auditPage, err := rs.Store.Audit().List(q)
 	if err != nil {
 		utils.Render(w, r, err)
 		return
 	}
-	pageSize, err := utils.Int64FromQuery("page_size", query)
+
+	if permission.Redacted {
+		for i := range auditPage.Entries {
+			auditPage.Entries[i].Redact()
+		}
+	}
+	render.Respond(w, r, newAuditListResponse(auditPage, q, r.URL, true))
+}
+
+// Returns filtered User in a list
+func (rs *UsersResource) list(w http.ResponseWriter, r *http.Request) {
+	permission, ok := rs.authorize(w, r, ActionList, "")
+	if !ok {
+		return
+	}
+
+	//parses the filter and the options from the query
+	filter, opts, err := DecodeListQuery(r.URL.Query())
 	if err != nil {
 		utils.Render(w, r, err)
 		return
 	}
+	if opts.IncludeDeleted && !permission.SeeDeleted {
+		utils.RenderForbidden(w, r, ErrIncludeDeleted)
+		return
+	}
+	//the redacted values could be found back by bisection
+	if permission.Redacted && revealsRedacted(filter, opts) {
+		utils.RenderForbidden(w, r, ErrRedactedQuery)
+		return
+	}
+	//restricted to its own User
+	if permission.OwnOnly {
+		principal, _ := PrincipalFromContext(r.Context())
+		for _, c := range filter.Conditions {
+			if c.Field == "id" && c.Op == OpEq && c.Values[0] != principal.ID {
+				utils.RenderForbidden(w, r, ErrForbiddenOtherUser)
+				return
+			}
+		}
+		filter = filter.Where("id", OpEq, principal.ID)
+	}
 
 	//gets corresponding entries from db
-	usersList, count, err := rs.Store.List(textS, idS, firstNameS, lastNameS, nicknameS, passwordS, emailS, countryS, startDateCreated, endDateCreated, startDateUpdated, endDateUpdated, page, pageSize)
+	usersPage, err := rs.Store.List(filter, opts)
 	if err != nil {
 		utils.Render(w, r, err)
 		return
 	}
 
-	render.Respond(w, r, newUsersListResponse(usersList, count, true))
-}
+	if permission.Redacted {
+		for i := range usersPage.Users {
+			usersPage.Users[i].Redact()
+		}
+	}
 
-//EXample of SendNotification function
-func SendNotification(message string, u User) {
-	// send String and User to other potential services
+	resp := newUsersListResponse(usersPage, opts, r.URL, true)
+	etag, err := listETag(resp)
+	if err != nil {
+		utils.Render(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	setVary(w)
+	//the client already has this page
+	if NotModified(r.Header, etag, time.Time{}) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	render.Respond(w, r, resp)
 }
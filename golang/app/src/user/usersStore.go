@@ -2,21 +2,63 @@ package user
 
 import (
 	"context"
+	"errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"regexp"
+	"strings"
+	errors2 "test/errors"
 	"time"
 )
 
-// UsersStore implements database operations
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// DefaultListTimeout is the time limit of a List on the database, a longer search is stopped.
+const DefaultListTimeout = 5 * time.Second
+
+// UserRepository is implemented by every Users backend.
+// Every change is made by the actor, the id of a User or "" for the service itself, recorded in the audit trail.
+type UserRepository interface {
+	Create(actor string, u *User) error
+	Get(id string) (*User, error)
+	//Update, Patch and Delete change the User only at the version, or at AnyVersion,
+	//ErrVersionMismatch when it was changed since
+	Update(actor, id string, version int64, u *User) error
+	Patch(actor, id string, version int64, patch UserPatch, u *User) error
+	//Delete marks the User deleted, it is hidden until it is restored or purged
+	Delete(actor, id string, version int64) error
+	//Restore brings back a deleted User, the restored User is set in u
+	Restore(actor, id string, u *User) error
+	//Purge removes for good the Users deleted before the time, and returns how many were removed
+	Purge(before time.Time) (int, error)
+	//List returns the page of Users matching the filter, ordered by the sort fields then id, and the total of Users matching the filter
+	List(filter UserFilter, opts ListOptions) (*UsersPage, error)
+	VerifyPassword(login, password string) (*User, error)
+	//Outbox returns the Events saved with the changes of the Users, in the same transaction
+	Outbox() Outbox
+	//Audit returns the audit trail of the changes of the Users, appended in the same transaction
+	Audit() AuditStore
+}
+
+// UsersStore implements database operations on MongoDB
 type UsersStore struct {
-	collection *mongo.Collection
-	ctx        context.Context
+	collection  *mongo.Collection
+	ctx         context.Context
+	hasher      PasswordHasher
+	ListTimeout time.Duration //time limit of a List on the database, 0 for none
+	Fuzzy       FuzzyOptions  //Users returned by a fuzzy lookup
+	outbox      *OutboxMongoStore
+	audit       *AuditMongoStore
+	dummy       string //compared for an unknown login
 }
 
-// NewUsersStore returns a UsersStore
-func NewUsersStore(db *mongo.Database, ctx context.Context) (*UsersStore, error) {
+// NewUsersStore returns a UsersStore, the passwords are saved hashed by the hasher.
+// The changes are saved in transactions with their Events and audit entries, the database has to be a replica set.
+func NewUsersStore(db *mongo.Database, ctx context.Context, hasher PasswordHasher) (*UsersStore, error) {
 	usersCollection := db.Collection("users")
 	//set the uniq constraint for nickname and email
 	_, err := usersCollection.Indexes().CreateMany(
@@ -30,42 +72,114 @@ func NewUsersStore(db *mongo.Database, ctx context.Context) (*UsersStore, error)
 				Keys:    bson.D{{Key: "nickname", Value: 1}},
 				Options: options.Index().SetUnique(true),
 			},
+			//the deleted Users to purge
+			{
+				Keys:    bson.D{{Key: "deleted_at", Value: 1}},
+				Options: options.Index().SetSparse(true),
+			},
+			//the full text search, without stemming nor stop words as the fields are names
+			{
+				Keys:    textIndexKeys(),
+				Options: options.Index().SetName("users_text").SetWeights(SearchWeights).SetDefaultLanguage("none"),
+			},
+			//the candidates of a fuzzy lookup, by the trigrams of their names
+			{
+				Keys: bson.D{{Key: "fuzzy_grams", Value: 1}},
+			},
 		},
 	)
 	if err != nil {
 		return nil, err
 	}
+	outbox, err := NewOutboxMongoStore(db, ctx)
+	if err != nil {
+		return nil, err
+	}
+	audit, err := NewAuditMongoStore(db, ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	return &UsersStore{
-		collection: usersCollection,
-		ctx:        ctx,
+		collection:  usersCollection,
+		ctx:         ctx,
+		hasher:      hasher,
+		ListTimeout: DefaultListTimeout,
+		Fuzzy:       DefaultFuzzy,
+		outbox:      outbox,
+		audit:       audit,
+		dummy:       newDummyHash(hasher),
 	}, nil
 }
 
-// Create creates a new User.
-func (s *UsersStore) Create(u *User) error {
+// Outbox returns the Events saved with the changes of the Users.
+func (s *UsersStore) Outbox() Outbox {
+	return s.outbox
+}
+
+// Audit returns the audit trail of the changes of the Users.
+func (s *UsersStore) Audit() AuditStore {
+	return s.audit
+}
+
+// Create creates a new User, with its UserCreated Event in the outbox and its audit entry.
+func (s *UsersStore) Create(actor string, u *User) error {
 	u.ID = ""
 	u.CreatedAt = time.Now()
 	u.UpdatedAt = time.Now()
+	u.Version = 1
+	if u.Role == "" {
+		u.Role = RoleSelf
+	}
 	err := u.Validate()
 	if err != nil {
 		return err
 	}
-	userInsertOne, err := s.collection.InsertOne(s.ctx, u)
+	u.Password, err = s.hasher.Hash(u.Password)
 	if err != nil {
 		return err
 	}
-	userSingleResult := s.collection.FindOne(s.ctx, bson.M{"_id": userInsertOne.InsertedID})
-	if userSingleResult.Err() != nil {
-		return userSingleResult.Err()
+	return duplicateValue(s.withChange(AuditCreate, actor, u, func(sc mongo.SessionContext) (*User, error) {
+		u.ID = ""
+		userInsertOne, err := s.collection.InsertOne(sc, u)
+		if err != nil {
+			return nil, err
+		}
+		return nil, s.collection.FindOne(sc, bson.M{"_id": userInsertOne.InsertedID}).Decode(u)
+	}))
+}
+
+//duplicateValue returns ErrDuplicateValue for the field of the unique index a duplicate key error violated,
+//instead of the driver message naming the database and the value of the other User
+func duplicateValue(err error) error {
+	if !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+	for _, field := range []string{"email", "nickname"} {
+		if strings.Contains(err.Error(), "index: "+field+"_1 ") {
+			return ErrDuplicateValue(field)
+		}
 	}
+	return errors2.Conflict(errors.New("value already used"))
+}
 
-	err = userSingleResult.Decode(u)
-	return err
+// Get returns the User from its id, mongo.ErrNoDocuments if there is none.
+func (s *UsersStore) Get(id string) (*User, error) {
+	primId, err := ParseID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var u User
+	err = s.collection.FindOne(s.ctx, bson.M{"_id": primId, "deleted_at": nil}).Decode(&u)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
 }
 
-// Update update an existing User.
-func (s *UsersStore) Update(id string, u *User) error {
+// Update update an existing User at the version, with its UserUpdated Event in the outbox and its audit entry.
+func (s *UsersStore) Update(actor, id string, version int64, u *User) error {
 	u.UpdatedAt = time.Now()
 
 	err := u.Validate()
@@ -73,141 +187,522 @@ func (s *UsersStore) Update(id string, u *User) error {
 		return err
 	}
 
-	primId, err := primitive.ObjectIDFromHex(id)
+	primId, err := ParseID(id)
+	if err != nil {
+		return err
+	}
+	u.Password, err = rehashPassword(s.hasher, s.storedPassword(primId), u.Password)
 	if err != nil {
 		return err
 	}
 	//Do not allow to directly modify id, created_at and updated_at
-	updateResult, err := s.collection.UpdateOne(
-		s.ctx,
-		bson.M{"_id": primId},
-		bson.M{"$set": bson.M{
-			"first_name": u.FirstName,
-			"last_name":  u.LastName,
-			"nickname":   u.Nickname,
-			"password":   u.Password,
-			"email":      u.Email,
-			"country":    u.Country,
-			"updated_at": u.UpdatedAt,
-		}},
-	)
+	set := bson.M{
+		"first_name": u.FirstName,
+		"last_name":  u.LastName,
+		"nickname":   u.Nickname,
+		"password":   u.Password,
+		"email":      u.Email,
+		"country":    u.Country,
+		"updated_at": u.UpdatedAt,
+	}
+	//without role the current one is kept
+	if u.Role != "" {
+		set["role"] = u.Role
+	}
+	return duplicateValue(s.withChange(AuditUpdate, actor, u, func(sc mongo.SessionContext) (*User, error) {
+		return s.change(sc, versionDocument(bson.M{"_id": primId, "deleted_at": nil}, version),
+			bson.M{"$set": set, "$inc": bson.M{"version": 1}}, u)
+	}))
+}
+
+// Patch updates only the fields of the patch on an existing User at the version, the updated User is decoded in u.
+// Its UserUpdated Event is saved in the outbox, with its audit entry.
+func (s *UsersStore) Patch(actor, id string, version int64, patch UserPatch, u *User) error {
+	err := patch.Validate()
 	if err != nil {
 		return err
 	}
-	if updateResult.MatchedCount == 0 {
-		return mongo.ErrNoDocuments
+
+	primId, err := ParseID(id)
+	if err != nil {
+		return err
 	}
+	set := patch.set()
+	if patch.Password != nil {
+		set["password"], err = rehashPassword(s.hasher, s.storedPassword(primId), *patch.Password)
+		if err != nil {
+			return err
+		}
+	}
+	set["updated_at"] = time.Now()
+	return duplicateValue(s.withChange(AuditUpdate, actor, u, func(sc mongo.SessionContext) (*User, error) {
+		return s.change(sc, versionDocument(bson.M{"_id": primId, "deleted_at": nil}, version),
+			bson.M{"$set": set, "$inc": bson.M{"version": 1}}, u)
+	}))
+}
+
+// Delete marks a User deleted from its id at the version, it is hidden until it is restored or purged.
+// Its UserDeleted Event is saved in the outbox, with its audit entry.
+func (s *UsersStore) Delete(actor, id string, version int64) error {
+
+	primId, err := ParseID(id)
+	if err != nil {
+		return err
+	}
+
+	var deleted User
+	return s.withChange(AuditDelete, actor, &deleted, func(sc mongo.SessionContext) (*User, error) {
+		return s.change(sc, versionDocument(bson.M{"_id": primId, "deleted_at": nil}, version),
+			bson.M{"$set": bson.M{"deleted_at": time.Now()}, "$inc": bson.M{"version": 1}}, &deleted)
+	})
+}
+
+//storedPassword returns the saved password of the User, "" when it can't be read
+func (s *UsersStore) storedPassword(primId primitive.ObjectID) string {
+	var stored User
+	err := s.collection.FindOne(s.ctx, bson.M{"_id": primId}, options.FindOne().SetProjection(bson.M{"password": 1})).Decode(&stored)
+	if err != nil {
+		return ""
+	}
+	return stored.Password
+}
 
-	userSingleResult := s.collection.FindOne(s.ctx, bson.M{"_id": primId})
-	if userSingleResult.Err() != nil {
-		return userSingleResult.Err()
+//versionDocument restricts the filter to the version, a User saved before versioning has none and is at 0
+func versionDocument(filter bson.M, version int64) bson.M {
+	switch version {
+	case AnyVersion:
+	case 0:
+		filter["version"] = bson.M{"$in": bson.A{int64(0), nil}}
+	default:
+		filter["version"] = version
 	}
+	return filter
+}
 
-	err = userSingleResult.Decode(u)
+//withChange runs the change in a transaction with the insertion of its Event in the outbox and of its audit entry:
+//they are saved if and only if the change is. The change returns the User before it (nil for a creation),
+//and decodes it after in u.
+func (s *UsersStore) withChange(action AuditAction, actor string, u *User, change func(sc mongo.SessionContext) (*User, error)) error {
+	session, err := s.collection.Database().Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(s.ctx)
+
+	_, err = session.WithTransaction(s.ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		before, err := change(sc)
+		if err != nil {
+			return nil, err
+		}
+		//the trigrams of the names select the candidates of a fuzzy lookup, only a creation or an update changes them
+		if action == AuditCreate || action == AuditUpdate {
+			if err := s.setFuzzyGrams(sc, u); err != nil {
+				return nil, err
+			}
+		}
+		if err := s.outbox.insert(sc, newChangeEvent(action, before, u)); err != nil {
+			return nil, err
+		}
+		return nil, s.audit.insert(sc, NewAuditEntry(action, actor, before, u))
+	})
 	return err
 }
 
-// Delete a User from its id.
-func (s *UsersStore) Delete(id string) error {
+//setFuzzyGrams saves the trigrams of the names of the User with it
+func (s *UsersStore) setFuzzyGrams(ctx context.Context, u *User) error {
+	primId, err := primitive.ObjectIDFromHex(u.ID)
+	if err != nil {
+		return err
+	}
+	_, err = s.collection.UpdateOne(ctx, bson.M{"_id": primId}, bson.M{"$set": bson.M{"fuzzy_grams": fuzzyGrams(u)}})
+	return err
+}
 
-	primId, err := primitive.ObjectIDFromHex(id)
+//change updates the User matching the filter in the transaction, decodes it updated in u, and returns it before
+func (s *UsersStore) change(sc mongo.SessionContext, filter bson.M, update bson.M, u *User) (*User, error) {
+	var before User
+	err := s.collection.FindOneAndUpdate(sc, filter, update).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		return nil, s.notMatched(sc, filter["_id"])
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.collection.FindOne(sc, bson.M{"_id": filter["_id"]}).Decode(u); err != nil {
+		return nil, err
+	}
+	return &before, nil
+}
+
+//notMatched returns why a change matched no User: ErrVersionMismatch if it still exists, mongo.ErrNoDocuments otherwise
+func (s *UsersStore) notMatched(ctx context.Context, primId interface{}) error {
+	count, err := s.collection.CountDocuments(ctx, bson.M{"_id": primId, "deleted_at": nil})
 	if err != nil {
 		return err
 	}
+	if count > 0 {
+		return ErrVersionMismatch
+	}
+	return mongo.ErrNoDocuments
+}
 
-	deleteResult, err := s.collection.DeleteOne(
-		s.ctx,
-		bson.M{"_id": primId},
-	)
+// Restore brings back a deleted User, the restored User is decoded in u.
+// Its UserUpdated Event is saved in the outbox, with its audit entry.
+func (s *UsersStore) Restore(actor, id string, u *User) error {
+	primId, err := ParseID(id)
 	if err != nil {
 		return err
 	}
-	if deleteResult.DeletedCount == 0 {
-		return mongo.ErrNoDocuments
+
+	return s.withChange(AuditRestore, actor, u, func(sc mongo.SessionContext) (*User, error) {
+		before, err := s.change(sc, bson.M{"_id": primId, "deleted_at": bson.M{"$ne": nil}},
+			bson.M{"$set": bson.M{"updated_at": time.Now()}, "$unset": bson.M{"deleted_at": ""}, "$inc": bson.M{"version": 1}}, u)
+		//a User not deleted is not restored
+		if err == ErrVersionMismatch {
+			return nil, mongo.ErrNoDocuments
+		}
+		return before, err
+	})
+}
+
+// Purge removes for good the Users deleted before the time, and returns how many were removed.
+func (s *UsersStore) Purge(before time.Time) (int, error) {
+	deleteResult, err := s.collection.DeleteMany(s.ctx, bson.M{"deleted_at": bson.M{"$lte": before}})
+	if err != nil {
+		return 0, err
 	}
-	return nil
+	return int(deleteResult.DeletedCount), nil
 }
 
-// Return a List of User filtered, according to the page and page_size required.
-func (s *UsersStore) List(text, id, firstName, lastname, nickname, password, email, country string, startDateCreated, endDateCreated, startDateUpdated, endDateUpdated time.Time, page, pageSize int64) ([]User, int, error) {
-	//rmq page start at 0
-	skip := pageSize * page
-	opts := options.FindOptions{
-		Skip:  &skip,
-		Limit: &pageSize,
-		Sort:  bson.D{{"created_at", -1}},
+// Return a List of User filtered, according to the page and page_size or the cursor required, with the total of filtered Users.
+func (s *UsersStore) List(filter UserFilter, opts ListOptions) (*UsersPage, error) {
+	if err := checkSearchSort(filter, opts); err != nil {
+		return nil, err
+	}
+	if filter.Fuzzy != "" {
+		return s.listFuzzy(filter, opts)
+	}
+	//one more User tells if there is a next page
+	listSort := opts.sort()
+	findOpts := options.Find().SetSort(sortDocument(listSort))
+	countOpts := options.Count()
+	//an expensive search is stopped by the database
+	if s.ListTimeout > 0 {
+		findOpts.SetMaxTime(s.ListTimeout)
+		countOpts.SetMaxTime(s.ListTimeout)
+	}
+	if opts.PageSize > 0 {
+		findOpts.SetLimit(opts.PageSize + 1)
+		//rmq page start at 0
+		if opts.Cursor == nil {
+			findOpts.SetSkip(opts.PageSize * opts.Page)
+		}
+	}
+	//reads only the selected fields, and those of the sort for the cursor, with the score of a search
+	if fields := opts.projectionFields(); fields != nil || filter.Search != "" {
+		findOpts.SetProjection(projectionDocument(fields, filter.Search != ""))
+	}
+
+	filterDoc, err := filterDocument(filter)
+	if err != nil {
+		return nil, err
 	}
+	filterDoc = visibleDocument(filterDoc, opts)
 
-	var filter []bson.M
-	var textFilter []bson.M
+	//the total ignores the pagination
+	total, err := s.collection.CountDocuments(s.ctx, filterDoc, countOpts)
+	if err != nil {
+		return nil, err
+	}
 
-	if id != "" {
-		primId, err := primitive.ObjectIDFromHex(id)
+	//seeks after the cursor position
+	if opts.Cursor != nil {
+		cursorFilter, err := cursorFilter(opts.Cursor, listSort)
 		if err != nil {
-			return nil, 0, err
-		}
-		filter = append(
-			filter,
-			bson.M{"_id": primId},
-		)
-	}
-
-	addFilterRegex(&filter, "first_name", firstName, &textFilter, text)
-	addFilterRegex(&filter, "last_name", lastname, &textFilter, text)
-	addFilterRegex(&filter, "nickname", nickname, &textFilter, text)
-	addFilterRegex(&filter, "password", password, &textFilter, text)
-	addFilterRegex(&filter, "email", email, &textFilter, text)
-	addFilterRegex(&filter, "country", country, &textFilter, text)
-	addFilterDate(&filter, "created_at", "$gte", startDateCreated)
-	addFilterDate(&filter, "created_at", "$lte", endDateCreated)
-	addFilterDate(&filter, "updated_at", "$gte", startDateUpdated)
-	addFilterDate(&filter, "updated_at", "$lte", endDateUpdated)
-	filter = append(
-		filter,
-		bson.M{
-			"$or": textFilter,
-		},
-	)
+			return nil, err
+		}
+		filterDoc = bson.M{"$and": []bson.M{filterDoc, cursorFilter}}
+	}
 
-	cursor, err := s.collection.Find(
-		s.ctx,
-		bson.M{
-			"$and": filter,
-		},
-		&opts,
-	)
+	cursor, err := s.collection.Find(s.ctx, filterDoc, findOpts)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 	var uList []User
 	err = cursor.All(s.ctx, &uList)
-	return uList, len(uList), err
-}
-
-func addFilterRegex(filter *[]bson.M, field string, value string, textFilter *[]bson.M, text string) {
-	if value != "" {
-		*filter = append(
-			*filter,
-			bson.M{field: bson.D{
-				{"$regex", primitive.Regex{Pattern: ".*(" + value + ").*"}},
-			}},
-		)
-	}
-	*textFilter = append(
-		*textFilter,
-		bson.M{field: bson.D{
-			{"$regex", primitive.Regex{Pattern: ".*(" + text + ").*"}},
-		}},
-	)
+	if err != nil {
+		return nil, err
+	}
+	return newUsersPage(uList, int(total), opts), nil
+}
+
+//listFuzzy selects in the database the Users matching the Conditions which share the most trigrams with the Fuzzy value,
+//at most Fuzzy.Candidates, ranks their names by similarity, then reads the most similar ones to return their page
+func (s *UsersStore) listFuzzy(filter UserFilter, opts ListOptions) (*UsersPage, error) {
+	filterDoc, err := filterDocument(filter)
+	if err != nil {
+		return nil, err
+	}
+	filterDoc = visibleDocument(filterDoc, opts)
+	grams := trigrams(filter.Fuzzy)
+	if len(grams) == 0 {
+		return paginate(nil, opts), nil
+	}
+	project := projectionDocument(FuzzyFields, false)
+	//the trigrams are literal, one starting with $ would be a field path or a variable
+	project["shared"] = bson.M{"$size": bson.M{"$setIntersection": bson.A{"$fuzzy_grams", bson.M{"$literal": grams}}}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"$and": bson.A{filterDoc, bson.M{"fuzzy_grams": bson.M{"$in": grams}}}}}},
+		{{Key: "$project", Value: project}},
+		{{Key: "$sort", Value: bson.D{{Key: "shared", Value: -1}, {Key: "_id", Value: 1}}}},
+	}
+	if s.Fuzzy.Candidates > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: s.Fuzzy.Candidates}})
+	}
+	candidatesOpts := options.Aggregate()
+	if s.ListTimeout > 0 {
+		candidatesOpts.SetMaxTime(s.ListTimeout)
+	}
+	cursor, err := s.collection.Aggregate(s.ctx, pipeline, candidatesOpts)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []User
+	if err := cursor.All(s.ctx, &candidates); err != nil {
+		return nil, err
+	}
+	ranked := s.Fuzzy.rank(candidates, filter.Fuzzy)
+	if len(ranked) == 0 {
+		return paginate(nil, opts), nil
+	}
+
+	ids := make([]primitive.ObjectID, len(ranked))
+	scores := make(map[string]float64, len(ranked))
+	for i, u := range ranked {
+		ids[i], err = primitive.ObjectIDFromHex(u.ID)
+		if err != nil {
+			return nil, err
+		}
+		scores[u.ID] = u.Score
+	}
+	findOpts := options.Find()
+	if fields := opts.projectionFields(); fields != nil {
+		findOpts.SetProjection(projectionDocument(fields, false))
+	}
+	cursor, err = s.collection.Find(s.ctx, bson.M{"_id": bson.M{"$in": ids}}, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	var uList []User
+	if err := cursor.All(s.ctx, &uList); err != nil {
+		return nil, err
+	}
+	for i := range uList {
+		uList[i].Score = scores[uList[i].ID]
+	}
+	return paginate(uList, opts), nil
+}
+
+// VerifyPassword returns the User with this nickname or email if the password matches.
+// An unknown login is compared to a dummy hash, so it takes as long to answer as a wrong password.
+func (s *UsersStore) VerifyPassword(login, password string) (*User, error) {
+	var u User
+	err := s.collection.FindOne(
+		s.ctx,
+		bson.M{"$or": []bson.M{{"nickname": login}, {"email": login}}, "deleted_at": nil},
+	).Decode(&u)
+	if err == mongo.ErrNoDocuments {
+		_ = verifyStoredPassword(s.hasher, s.dummy, password)
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyStoredPassword(s.hasher, u.Password, password); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+//sortDocument returns the mongo sort of s, with the id as tiebreaker
+func sortDocument(s Sort) bson.D {
+	var sortDoc bson.D
+	for _, sortField := range s {
+		if sortField.Field == "score" {
+			sortDoc = append(sortDoc, bson.E{Key: "score", Value: bson.M{"$meta": "textScore"}})
+			continue
+		}
+		sortDoc = append(sortDoc, bson.E{Key: sortField.Field, Value: sortDirection(sortField.Desc)})
+	}
+	return append(sortDoc, bson.E{Key: "_id", Value: sortDirection(s.idDesc())})
+}
+
+//projectionDocument returns the mongo projection of the fields, every field when nil, and of the score of a search
+func projectionDocument(fields []string, score bool) bson.M {
+	projection := bson.M{}
+	if score {
+		projection["score"] = bson.M{"$meta": "textScore"}
+	}
+	for _, field := range fields {
+		switch field {
+		case "id":
+			field = "_id"
+		case "score":
+			//not a saved field, only the textScore
+			continue
+		}
+		projection[field] = 1
+	}
+	return projection
+}
+
+//textIndexKeys returns the keys of the text index on the SearchWeights fields
+func textIndexKeys() bson.D {
+	var keys bson.D
+	for _, field := range searchFields() {
+		keys = append(keys, bson.E{Key: field, Value: "text"})
+	}
+	return keys
+}
+
+func sortDirection(desc bool) int {
+	if desc {
+		return -1
+	}
+	return 1
+}
+
+//cursorFilter matches the Users after the cursor in the list ordered by s:
+//for each field, the previous fields are equal and this one is after the cursor value
+func cursorFilter(c *Cursor, s Sort) (bson.M, error) {
+	var values []interface{}
+	for i, sortField := range s {
+		var value interface{} = c.Values[i]
+		if isTimeField(sortField.Field) {
+			date, err := time.Parse(sortTimeFormat, c.Values[i])
+			if err != nil {
+				return nil, ErrCursor
+			}
+			value = primitive.NewDateTimeFromTime(date)
+		}
+		values = append(values, value)
+	}
+	cursorId, err := primitive.ObjectIDFromHex(c.ID)
+	if err != nil {
+		return nil, ErrCursor
+	}
+	keys := append(append(Sort{}, s...), SortField{Field: "_id", Desc: s.idDesc()})
+	values = append(values, cursorId)
+
+	var or []bson.M
+	for i, sortField := range keys {
+		condition := bson.M{}
+		for j := 0; j < i; j++ {
+			condition[keys[j].Field] = values[j]
+		}
+		operator := "$gt"
+		if sortField.Desc {
+			operator = "$lt"
+		}
+		condition[sortField.Field] = bson.M{operator: values[i]}
+		or = append(or, condition)
+	}
+	return bson.M{"$or": or}, nil
+}
+
+//filterDocument returns the mongo filter matching all the Conditions of f
+func filterDocument(f UserFilter) (bson.M, error) {
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	if len(f.Conditions) == 0 && f.Search == "" {
+		return bson.M{}, nil
+	}
+	conditions := make([]bson.M, 0, len(f.Conditions)+1)
+	if f.Search != "" {
+		conditions = append(conditions, bson.M{"$text": bson.M{"$search": f.Search}})
+	}
+	for _, c := range f.Conditions {
+		conditions = append(conditions, conditionDocument(c))
+	}
+	return bson.M{"$and": conditions}, nil
+}
+
+//visibleDocument excludes the deleted Users from the filter, unless the options include them
+func visibleDocument(filterDoc bson.M, opts ListOptions) bson.M {
+	if opts.IncludeDeleted {
+		return filterDoc
+	}
+	return bson.M{"$and": []bson.M{filterDoc, {"deleted_at": nil}}}
+}
+
+//conditionDocument returns the mongo filter of a validated Condition, on any text field for the TextField
+func conditionDocument(c Condition) bson.M {
+	if c.Field == TextField {
+		textConditions := make([]bson.M, 0, len(TextFields))
+		for _, field := range TextFields {
+			textCondition := c
+			textCondition.Field = field
+			textConditions = append(textConditions, conditionDocument(textCondition))
+		}
+		return bson.M{"$or": textConditions}
+	}
+
+	field := c.Field
+	if field == "id" {
+		field = "_id"
+	}
+	values := make([]interface{}, len(c.Values))
+	for i, value := range c.Values {
+		values[i] = conditionValue(c, value)
+	}
+	switch c.Op {
+	case OpPrefix:
+		return bson.M{field: conditionRegex(c, "^"+regexp.QuoteMeta(c.Values[0]))}
+	case OpContains:
+		return bson.M{field: conditionRegex(c, regexp.QuoteMeta(c.Values[0]))}
+	case OpRegex:
+		return bson.M{field: conditionRegex(c, c.Values[0])}
+	case OpIn:
+		return bson.M{field: bson.M{"$in": values}}
+	case OpNot:
+		if c.CaseInsensitive {
+			return bson.M{field: bson.M{"$not": values[0]}}
+		}
+		return bson.M{field: bson.M{"$ne": values[0]}}
+	case OpRange:
+		bounds := bson.M{}
+		if c.Values[0] != "" {
+			bounds["$gte"] = values[0]
+		}
+		if c.Values[1] != "" {
+			bounds["$lte"] = values[1]
+		}
+		return bson.M{field: bounds}
+	}
+	return bson.M{field: values[0]}
+}
+
+//conditionRegex returns the mongo regex of the pattern, case insensitive as the Condition
+func conditionRegex(c Condition, pattern string) primitive.Regex {
+	if c.CaseInsensitive {
+		return primitive.Regex{Pattern: pattern, Options: "i"}
+	}
+	return primitive.Regex{Pattern: pattern}
 }
 
-func addFilterDate(filter *[]bson.M, field string, operator string, value time.Time) {
-	if !value.IsZero() {
-		*filter = append(
-			*filter,
-			bson.M{field: bson.M{
-				operator: primitive.NewDateTimeFromTime(value),
-			}},
-		)
+//conditionValue converts a validated value to the type of the field in the database,
+//a case insensitive value is matched by a regex of the whole value
+func conditionValue(c Condition, value string) interface{} {
+	switch {
+	case c.Field == "id":
+		primId, _ := primitive.ObjectIDFromHex(value)
+		return primId
+	case isTimeField(c.Field) && value != "":
+		date, _ := time.Parse(time.RFC3339, value)
+		return primitive.NewDateTimeFromTime(date)
+	case c.CaseInsensitive:
+		return conditionRegex(c, "^"+regexp.QuoteMeta(value)+"$")
 	}
+	return value
 }
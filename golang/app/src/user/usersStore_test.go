@@ -2,20 +2,48 @@ package user
 
 import (
 	"context"
+	"errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"log"
+	"net/url"
 	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	errors2 "test/errors"
 	"test/utils"
 	"testing"
 	"time"
 )
 
-var testUsersStore *UsersStore
+var testUsersStore UserRepository
+var testDb *mongo.Database //nil with the in-memory backend
+
+//deleteForGood deletes then purges the User, so its nickname and email can be used again
+func deleteForGood(id string) error {
+	if err := testUsersStore.Delete("", id, AnyVersion); err != nil {
+		return err
+	}
+	_, err := testUsersStore.Purge(time.Now())
+	return err
+}
 
 func TestMain(m *testing.M) {
+	//without a database the tests run against the in-memory backend
+	//the lowest cost keeps the tests fast
+	hasher, err := NewPasswordHasher(HashBcrypt, 4)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if os.Getenv("MONGODB_URI") == "" {
+		testUsersStore = NewUsersMemoryStore(hasher)
+		os.Exit(m.Run())
+	}
+
 	//connect to the database
 	client, err := mongo.NewClient(options.Client().ApplyURI(os.Getenv("MONGODB_URI")))
 	if err != nil {
@@ -25,7 +53,7 @@ func TestMain(m *testing.M) {
 	err = client.Connect(ctx)
 
 	defer client.Disconnect(ctx)
-	testDb := client.Database("testDb")
+	testDb = client.Database("testDb")
 
 	dbConnection := utils.DbConnection{
 		Client:   client,
@@ -33,7 +61,7 @@ func TestMain(m *testing.M) {
 		Ctx:      ctx,
 	}
 
-	testUsersStore, err = NewUsersStore(dbConnection.Database, dbConnection.Ctx)
+	testUsersStore, err = NewUsersStore(dbConnection.Database, dbConnection.Ctx, hasher)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -80,7 +108,7 @@ func TestStoreCreate(t *testing.T) {
 		Email:     "XEmail@email.com",
 		Country:   "XCountry",
 	}
-	resultExistingErr := testUsersStore.Create(&existingUser)
+	resultExistingErr := testUsersStore.Create("", &existingUser)
 	if resultExistingErr != nil {
 		t.Errorf("Create user failled for creating a pre-existing user with err %v", resultExistingErr)
 	}
@@ -219,7 +247,7 @@ func TestStoreCreate(t *testing.T) {
 	}
 
 	for _, item := range storeCreateTests {
-		resultErr := testUsersStore.Create(&item.user)
+		resultErr := testUsersStore.Create("", &item.user)
 		if !item.expectedErr && resultErr != nil {
 			t.Errorf("usersStore.Create for %v output err %v not expected", item.user, resultErr.Error())
 		}
@@ -238,25 +266,14 @@ func TestStoreCreate(t *testing.T) {
 			if err != nil {
 				t.Errorf(err.Error())
 			}
-			var presetIdFoundUser User
-			err = testUsersStore.collection.FindOne(
-				testUsersStore.ctx,
-				bson.M{"_id": primPresetId},
-			).Decode(&presetIdFoundUser)
-			if err != mongo.ErrNoDocuments {
-				t.Errorf("usersStore.Create for %v (expecting that the given id was not used) output err %v but %v was expected.", primPresetId, err, mongo.ErrNoDocuments)
+			presetIdFoundUsers, err := testUsersStore.List(UserFilter{}.Where("id", OpEq, primPresetId.Hex()), ListOptions{})
+			if err != nil || len(presetIdFoundUsers.Users) != 0 {
+				t.Errorf("usersStore.Create for %v (expecting that the given id was not used) output %v with err %v.", primPresetId, presetIdFoundUsers, err)
 			}
 		}
 		//Delete the entry from the db to clean
 		if item.expectedErr == false {
-			primId, err := primitive.ObjectIDFromHex(item.user.ID)
-			if err != nil {
-				t.Errorf(err.Error())
-			}
-			_, err = testUsersStore.collection.DeleteOne(
-				testUsersStore.ctx,
-				bson.M{"_id": primId},
-			)
+			err := deleteForGood(item.user.ID)
 			if err != nil {
 				t.Errorf(err.Error())
 			}
@@ -264,19 +281,59 @@ func TestStoreCreate(t *testing.T) {
 	}
 
 	//delete pre-existing user
-	primId, err := primitive.ObjectIDFromHex(existingUser.ID)
-	if err != nil {
-		t.Errorf(err.Error())
-	}
-	_, err = testUsersStore.collection.DeleteOne(
-		testUsersStore.ctx,
-		bson.M{"_id": primId},
-	)
+	err := deleteForGood(existingUser.ID)
 	if err != nil {
 		t.Errorf("Failled to delete pre-existing user with err %v", err)
 	}
 }
 
+type storeGetTest struct {
+	id          string
+	expectedErr error
+}
+
+func TestStoreGet(t *testing.T) {
+	user := User{
+		FirstName: "FirstName",
+		LastName:  "LastName",
+		Nickname:  "Nickname",
+		Password:  "Password",
+		Email:     "Email@email.com",
+		Country:   "Country",
+	}
+	resultErr := testUsersStore.Create("", &user)
+	if resultErr != nil {
+		t.Errorf("Create user failled for get test of item %v with err %v", user, resultErr)
+	}
+
+	storeGetTests := []storeGetTest{
+		//test normal behavior
+		{id: user.ID, expectedErr: nil},
+		//test unknown id
+		{id: "61e41ed578752c5997718a00", expectedErr: mongo.ErrNoDocuments},
+		//test malformed id
+		{id: "61e41ed5787", expectedErr: ErrInvalidID},
+		//test malformed id of an ObjectID length
+		{id: "zzzzzzzzzzzzzzzzzzzzzzzz", expectedErr: ErrInvalidID},
+	}
+
+	for _, item := range storeGetTests {
+		resultUser, resultErr := testUsersStore.Get(item.id)
+		if resultErr != item.expectedErr {
+			t.Errorf("usersStore.Get for %v output err %v but %v was expected", item.id, resultErr, item.expectedErr)
+		}
+		if resultErr == nil && (!resultUser.IsSoftEqual(&user) || resultUser.ID != user.ID || !resultUser.CreatedAt.Equal(user.CreatedAt)) {
+			t.Errorf("usersStore.Get for %v output %v but expected %v", item.id, resultUser, user)
+		}
+	}
+
+	//delete to clean
+	resultErr = deleteForGood(user.ID)
+	if resultErr != nil {
+		t.Errorf("Failled to delete get user with err %v", resultErr)
+	}
+}
+
 type storeUpdateTest struct {
 	userCreated  []*User //the id of the first user is the one used if present
 	userUpdate   User
@@ -622,7 +679,7 @@ func TestStoreUpdate(t *testing.T) {
 	for itemIndex, item := range storeUpdateTests {
 		var id = "61e41ed578752c5997718aee" //possible but non-existing id
 		for index, itemToCreate := range item.userCreated {
-			resultErr := testUsersStore.Create(itemToCreate)
+			resultErr := testUsersStore.Create("", itemToCreate)
 			if resultErr != nil {
 				t.Errorf("Create user failled for update test of index %v item %v with err %v", itemIndex, item, resultErr)
 			}
@@ -630,7 +687,7 @@ func TestStoreUpdate(t *testing.T) {
 				id = itemToCreate.ID
 			}
 		}
-		resultErr := testUsersStore.Update(id, &item.userUpdate)
+		resultErr := testUsersStore.Update("", id, AnyVersion, &item.userUpdate)
 		if !item.expectedErr {
 			if resultErr != nil {
 				t.Errorf("usersStore.Update for %v output err %v not expected", item.userExpected, resultErr.Error())
@@ -638,6 +695,9 @@ func TestStoreUpdate(t *testing.T) {
 				if !item.userUpdate.IsSoftEqual(&item.userExpected) {
 					t.Errorf("usersStore.Update for %v output %v not expected", item.userExpected, item.userUpdate)
 				}
+				if _, err := testUsersStore.VerifyPassword(item.userExpected.Nickname, item.userExpected.Password); err != nil {
+					t.Errorf("usersStore.Update for %v did not save the password, verification output err %v", item.userExpected, err)
+				}
 			}
 		}
 		if item.expectedErr && resultErr == nil {
@@ -649,25 +709,14 @@ func TestStoreUpdate(t *testing.T) {
 			if err != nil {
 				t.Errorf(err.Error())
 			}
-			var presetIdFoundUser User
-			err = testUsersStore.collection.FindOne(
-				testUsersStore.ctx,
-				bson.M{"_id": primPresetId},
-			).Decode(&presetIdFoundUser)
-			if err != mongo.ErrNoDocuments {
-				t.Errorf("usersStore.Update for %v (expecting that the given id was not used) output err %v but %v was expected.", primPresetId, err, mongo.ErrNoDocuments)
+			presetIdFoundUsers, err := testUsersStore.List(UserFilter{}.Where("id", OpEq, primPresetId.Hex()), ListOptions{})
+			if err != nil || len(presetIdFoundUsers.Users) != 0 {
+				t.Errorf("usersStore.Update for %v (expecting that the given id was not used) output %v with err %v.", primPresetId, presetIdFoundUsers, err)
 			}
 		}
 		//Delete the entry from the db to clean
 		for _, itemToCreate := range item.userCreated {
-			primId, err := primitive.ObjectIDFromHex(itemToCreate.ID)
-			if err != nil {
-				t.Errorf(err.Error())
-			}
-			_, err = testUsersStore.collection.DeleteOne(
-				testUsersStore.ctx,
-				bson.M{"_id": primId},
-			)
+			err := deleteForGood(itemToCreate.ID)
 			if err != nil {
 				t.Errorf(err.Error())
 			}
@@ -685,7 +734,7 @@ func TestStoreDelete(t *testing.T) {
 		Country:   "Country",
 	}
 	//insert the user to be deleted
-	resultErr := testUsersStore.Create(&user)
+	resultErr := testUsersStore.Create("", &user)
 	if resultErr != nil {
 		t.Errorf("Create user failled for delete test of item %v with err %v", user, resultErr)
 	}
@@ -693,26 +742,506 @@ func TestStoreDelete(t *testing.T) {
 	fakeId := "61e41ed578752c5997718aff"
 
 	//normal behavior
-	err := testUsersStore.Delete(user.ID)
+	err := testUsersStore.Delete("", user.ID, AnyVersion)
 	if err != nil {
 		t.Errorf("usersStore.Delete failled with err %v", err)
 	}
 
 	//test non-existing id
-	err = testUsersStore.Delete(fakeId)
+	err = testUsersStore.Delete("", fakeId, AnyVersion)
 	if err == nil {
 		t.Errorf("usersStore.Delete did not fail with fake id.")
 	}
+
+	//the deleted User is hidden
+	if _, err = testUsersStore.Get(user.ID); err != mongo.ErrNoDocuments {
+		t.Errorf("usersStore.Get of a deleted user output err %v but %v was expected", err, mongo.ErrNoDocuments)
+	}
+	if err = testUsersStore.Delete("", user.ID, AnyVersion); err != mongo.ErrNoDocuments {
+		t.Errorf("usersStore.Delete of a deleted user output err %v but %v was expected", err, mongo.ErrNoDocuments)
+	}
+	updated := user
+	if err = testUsersStore.Update("", user.ID, AnyVersion, &updated); err != mongo.ErrNoDocuments {
+		t.Errorf("usersStore.Update of a deleted user output err %v but %v was expected", err, mongo.ErrNoDocuments)
+	}
+	if _, err = testUsersStore.VerifyPassword("Nickname", "Password"); err != ErrInvalidCredentials {
+		t.Errorf("usersStore.VerifyPassword of a deleted user output err %v but %v was expected", err, ErrInvalidCredentials)
+	}
+	filter := UserFilter{}.Where("id", OpEq, user.ID)
+	usersPage, err := testUsersStore.List(filter, ListOptions{})
+	if err != nil || usersPage.Total != 0 {
+		t.Errorf("usersStore.List of a deleted user output %v with err %v", usersPage, err)
+	}
+	usersPage, err = testUsersStore.List(filter, ListOptions{IncludeDeleted: true})
+	if err != nil || usersPage.Total != 1 || usersPage.Users[0].DeletedAt == nil {
+		t.Errorf("usersStore.List including the deleted users output %v with err %v", usersPage, err)
+	}
+	//its nickname and email stay used until it is purged
+	duplicate := User{FirstName: "FirstName", LastName: "LastName", Nickname: "Nickname", Password: "Password", Email: "Email2@email.com", Country: "Country"}
+	if err = testUsersStore.Create("", &duplicate); err == nil {
+		t.Errorf("usersStore.Create with the nickname of a deleted user output no err but one was expected")
+	}
+
+	//the deleted User is restored once
+	var restored User
+	if err = testUsersStore.Restore("", user.ID, &restored); err != nil {
+		t.Errorf("usersStore.Restore failled with err %v", err)
+	}
+	if restored.ID != user.ID || restored.DeletedAt != nil || !restored.IsSoftEqual(&user) {
+		t.Errorf("usersStore.Restore output %v but expected %v", restored, user)
+	}
+	if _, err = testUsersStore.Get(user.ID); err != nil {
+		t.Errorf("usersStore.Get of a restored user output err %v not expected", err)
+	}
+	if err = testUsersStore.Restore("", user.ID, &restored); err != mongo.ErrNoDocuments {
+		t.Errorf("usersStore.Restore of a user not deleted output err %v but %v was expected", err, mongo.ErrNoDocuments)
+	}
+	if err = testUsersStore.Restore("", fakeId, &restored); err != mongo.ErrNoDocuments {
+		t.Errorf("usersStore.Restore with fake id output err %v but %v was expected", err, mongo.ErrNoDocuments)
+	}
+
+	//only the Users deleted before the time are purged
+	if err = testUsersStore.Delete("", user.ID, AnyVersion); err != nil {
+		t.Errorf("usersStore.Delete failled with err %v", err)
+	}
+	purged, err := testUsersStore.Purge(time.Now().Add(-time.Hour))
+	if err != nil || purged != 0 {
+		t.Errorf("usersStore.Purge before the delete output %v with err %v", purged, err)
+	}
+	purged, err = testUsersStore.Purge(time.Now())
+	if err != nil || purged != 1 {
+		t.Errorf("usersStore.Purge after the delete output %v with err %v", purged, err)
+	}
+	if err = testUsersStore.Restore("", user.ID, &restored); err != mongo.ErrNoDocuments {
+		t.Errorf("usersStore.Restore of a purged user output err %v but %v was expected", err, mongo.ErrNoDocuments)
+	}
+}
+
+func TestStoreVersion(t *testing.T) {
+	user := User{
+		FirstName: "FirstName",
+		LastName:  "LastName",
+		Nickname:  "Nickname",
+		Password:  "Password",
+		Email:     "Email@email.com",
+		Country:   "Country",
+	}
+	if err := testUsersStore.Create("", &user); err != nil {
+		t.Fatalf("Create user failled for version test with err %v", err)
+	}
+	defer deleteForGood(user.ID)
+	if user.Version != 1 {
+		t.Errorf("usersStore.Create output the version %v but 1 was expected", user.Version)
+	}
+
+	//every change is made at the current version, and increments it
+	updated := user
+	updated.FirstName = "Updated"
+	if err := testUsersStore.Update("", user.ID, 1, &updated); err != nil || updated.Version != 2 {
+		t.Errorf("usersStore.Update at the current version output the version %v with err %v", updated.Version, err)
+	}
+	if err := testUsersStore.Update("", user.ID, 1, &updated); err != ErrVersionMismatch {
+		t.Errorf("usersStore.Update at a previous version output err %v but %v was expected", err, ErrVersionMismatch)
+	}
+	firstName := "Patched"
+	var patched User
+	if err := testUsersStore.Patch("", user.ID, 2, UserPatch{FirstName: &firstName}, &patched); err != nil || patched.Version != 3 {
+		t.Errorf("usersStore.Patch at the current version output the version %v with err %v", patched.Version, err)
+	}
+	if err := testUsersStore.Patch("", user.ID, 2, UserPatch{FirstName: &firstName}, &patched); err != ErrVersionMismatch {
+		t.Errorf("usersStore.Patch at a previous version output err %v but %v was expected", err, ErrVersionMismatch)
+	}
+	if err := testUsersStore.Patch("", user.ID, AnyVersion, UserPatch{FirstName: &firstName}, &patched); err != nil || patched.Version != 4 {
+		t.Errorf("usersStore.Patch at any version output the version %v with err %v", patched.Version, err)
+	}
+	if err := testUsersStore.Delete("", user.ID, 3); err != ErrVersionMismatch {
+		t.Errorf("usersStore.Delete at a previous version output err %v but %v was expected", err, ErrVersionMismatch)
+	}
+	if err := testUsersStore.Delete("", "61e41ed578752c5997718aff", 4); err != mongo.ErrNoDocuments {
+		t.Errorf("usersStore.Delete with fake id output err %v but %v was expected", err, mongo.ErrNoDocuments)
+	}
+	if err := testUsersStore.Delete("", user.ID, 4); err != nil {
+		t.Errorf("usersStore.Delete at the current version failled with err %v", err)
+	}
+	var restored User
+	if err := testUsersStore.Restore("", user.ID, &restored); err != nil || restored.Version != 6 {
+		t.Errorf("usersStore.Restore output the version %v with err %v", restored.Version, err)
+	}
+}
+
+func TestStoreOutbox(t *testing.T) {
+	//the Events of the previous tests are relayed to no one
+	outbox := testUsersStore.Outbox()
+	if _, err := NewRelay(outbox, NewInProcessPublisher()).RelayOnce(time.Now()); err != nil {
+		t.Fatalf("Relay of the previous events failled with err %v", err)
+	}
+
+	user := User{
+		FirstName: "FirstName",
+		LastName:  "LastName",
+		Nickname:  "Nickname",
+		Password:  "Password",
+		Email:     "Email@email.com",
+		Country:   "Country",
+	}
+	if err := testUsersStore.Create("", &user); err != nil {
+		t.Fatalf("Create user failled for outbox test with err %v", err)
+	}
+	defer deleteForGood(user.ID)
+	//a failed change saves no Event
+	duplicate := user
+	if err := testUsersStore.Create("", &duplicate); err == nil {
+		t.Errorf("usersStore.Create of a duplicate output no err but one was expected")
+	}
+	updated := user
+	if err := testUsersStore.Update("", user.ID, 5, &updated); err != ErrVersionMismatch {
+		t.Errorf("usersStore.Update at another version output err %v but %v was expected", err, ErrVersionMismatch)
+	}
+	country := "Changed"
+	if err := testUsersStore.Patch("", user.ID, AnyVersion, UserPatch{Country: &country}, &updated); err != nil {
+		t.Errorf("usersStore.Patch failled with err %v", err)
+	}
+	if err := testUsersStore.Delete("", user.ID, AnyVersion); err != nil {
+		t.Errorf("usersStore.Delete failled with err %v", err)
+	}
+	var restored User
+	if err := testUsersStore.Restore("", user.ID, &restored); err != nil {
+		t.Errorf("usersStore.Restore failled with err %v", err)
+	}
+
+	messages, err := outbox.Due(time.Now(), 0)
+	if err != nil {
+		t.Fatalf("Outbox.Due failled with err %v", err)
+	}
+	expected := []EventType{EventUserCreated, EventUserUpdated, EventUserDeleted, EventUserUpdated}
+	if len(messages) != len(expected) {
+		t.Fatalf("Outbox.Due output %+v but expected the events %v", messages, expected)
+	}
+	for i, message := range messages {
+		if message.Event.Meta().Type != expected[i] || message.Status != OutboxPending || message.Event.Subject().ID != user.ID {
+			t.Errorf("Outbox.Due output %+v at %v but expected a pending %v of the user", message, i, expected[i])
+		}
+	}
+	patched, ok := messages[1].Event.(UserUpdated)
+	if !ok || !reflect.DeepEqual(patched.Changes, []FieldChange{{Field: "country", From: "Country", To: "Changed"}}) {
+		t.Errorf("Outbox.Due output the patch %+v", messages[1].Event)
+	}
+	if deleted, ok := messages[2].Event.(UserDeleted); !ok || deleted.User.DeletedAt == nil || deleted.User.Password != "" {
+		t.Errorf("Outbox.Due output the delete %+v", messages[2].Event)
+	}
+}
+
+func TestStoreAudit(t *testing.T) {
+	actor := "61e41ed578752c5997718aaa"
+	user := User{
+		FirstName: "FirstName",
+		LastName:  "LastName",
+		Nickname:  "Nickname",
+		Password:  "Password",
+		Email:     "Email@email.com",
+		Country:   "Country",
+	}
+	if err := testUsersStore.Create(actor, &user); err != nil {
+		t.Fatalf("Create user failled for audit test with err %v", err)
+	}
+	defer deleteForGood(user.ID)
+	//a failed change records nothing
+	updated := user
+	if err := testUsersStore.Update(actor, user.ID, 5, &updated); err != ErrVersionMismatch {
+		t.Errorf("usersStore.Update at another version output err %v but %v was expected", err, ErrVersionMismatch)
+	}
+	//each change is diffed from the User it was made on, even at any version
+	for _, country := range []string{"First", "Second"} {
+		country := country
+		if err := testUsersStore.Patch(actor, user.ID, AnyVersion, UserPatch{Country: &country}, &updated); err != nil {
+			t.Errorf("usersStore.Patch failled with err %v", err)
+		}
+	}
+	if err := testUsersStore.Delete(actor, user.ID, AnyVersion); err != nil {
+		t.Errorf("usersStore.Delete failled with err %v", err)
+	}
+	var restored User
+	if err := testUsersStore.Restore(actor, user.ID, &restored); err != nil {
+		t.Errorf("usersStore.Restore failled with err %v", err)
+	}
+
+	auditPage, err := testUsersStore.Audit().List(AuditQuery{UserID: user.ID})
+	if err != nil {
+		t.Fatalf("AuditStore.List failled with err %v", err)
+	}
+	expected := []AuditAction{AuditRestore, AuditDelete, AuditUpdate, AuditUpdate, AuditCreate}
+	if len(auditPage.Entries) != len(expected) {
+		t.Fatalf("AuditStore.List output %+v but expected the actions %v", auditPage.Entries, expected)
+	}
+	for i, entry := range auditPage.Entries {
+		if entry.Action != expected[i] || entry.Actor != actor {
+			t.Errorf("AuditStore.List output %+v at %v but expected a %v by the actor", entry, i, expected[i])
+		}
+	}
+	if changes := auditPage.Entries[2].Changes; !reflect.DeepEqual(changes, []FieldChange{{Field: "country", From: "First", To: "Second"}}) {
+		t.Errorf("AuditStore.List output the changes %+v of the second patch", changes)
+	}
+
+	//an update with the same password doesn't change it, a new one does
+	for _, item := range []struct {
+		password        string
+		expectedChanges []FieldChange
+	}{
+		{password: "Password", expectedChanges: []FieldChange{{Field: "first_name", From: "FirstName", To: "Updated"}}},
+		{password: "NewPassword", expectedChanges: []FieldChange{{Field: "password", From: RedactedValue, To: RedactedValue}}},
+	} {
+		updated = restored
+		updated.FirstName, updated.Password = "Updated", item.password
+		if err := testUsersStore.Update(actor, user.ID, AnyVersion, &updated); err != nil {
+			t.Errorf("usersStore.Update with the password %v failled with err %v", item.password, err)
+		}
+		auditPage, err := testUsersStore.Audit().List(AuditQuery{UserID: user.ID, PageSize: 1})
+		if err != nil || len(auditPage.Entries) != 1 || !reflect.DeepEqual(auditPage.Entries[0].Changes, item.expectedChanges) {
+			t.Errorf("AuditStore.List after an update with the password %v output %+v with err %v", item.password, auditPage, err)
+		}
+	}
+}
+
+type storeVerifyPasswordTest struct {
+	login, password string
+	expectedErr     bool
+}
+
+func TestStoreVerifyPassword(t *testing.T) {
+	user := User{
+		FirstName: "FirstName",
+		LastName:  "LastName",
+		Nickname:  "Nickname",
+		Password:  "Password",
+		Email:     "Email@email.com",
+		Country:   "Country",
+	}
+	resultErr := testUsersStore.Create("", &user)
+	if resultErr != nil {
+		t.Errorf("Create user failled for verify password test of item %v with err %v", user, resultErr)
+	}
+	if user.Password == "Password" || !IsPasswordHash(user.Password) {
+		t.Errorf("usersStore.Create saved the password %v without hashing it", user.Password)
+	}
+
+	storeVerifyPasswordTests := []storeVerifyPasswordTest{
+		//test normal behaviors
+		{login: "Nickname", password: "Password", expectedErr: false},
+		{login: "Email@email.com", password: "Password", expectedErr: false},
+		//test wrong password
+		{login: "Nickname", password: "password", expectedErr: true},
+		{login: "Nickname", password: "", expectedErr: true},
+		//test unknown login
+		{login: "Nickname2", password: "Password", expectedErr: true},
+	}
+
+	for _, item := range storeVerifyPasswordTests {
+		resultUser, resultErr := testUsersStore.VerifyPassword(item.login, item.password)
+		if !item.expectedErr {
+			if resultErr != nil {
+				t.Errorf("usersStore.VerifyPassword for %v output err %v not expected", item.login, resultErr.Error())
+			} else if resultUser.ID != user.ID {
+				t.Errorf("usersStore.VerifyPassword for %v output %v but expected %v", item.login, resultUser, user)
+			}
+		}
+		if item.expectedErr && resultErr != ErrInvalidCredentials {
+			t.Errorf("usersStore.VerifyPassword for %v output err %v but %v was expected", item.login, resultErr, ErrInvalidCredentials)
+		}
+	}
+
+	//delete to clean
+	resultErr = deleteForGood(user.ID)
+	if resultErr != nil {
+		t.Errorf("Failled to delete verify password user with err %v", resultErr)
+	}
+}
+
+//countingHasher counts the Compare calls
+type countingHasher struct {
+	PasswordHasher
+	compares *int32
+}
+
+func (h countingHasher) Compare(hash, password string) error {
+	atomic.AddInt32(h.compares, 1)
+	return h.PasswordHasher.Compare(hash, password)
+}
+
+func TestVerifyPasswordUnknownLogin(t *testing.T) {
+	bcryptHasher, _ := NewPasswordHasher(HashBcrypt, 4)
+	var compares int32
+	hasher := countingHasher{PasswordHasher: bcryptHasher, compares: &compares}
+	var store UserRepository = NewUsersMemoryStore(hasher)
+	if testDb != nil {
+		var err error
+		store, err = NewUsersStore(testDb, context.TODO(), hasher)
+		if err != nil {
+			t.Fatalf("NewUsersStore failled with err %v", err)
+		}
+	}
+	//an unknown login is compared as a wrong password, to take as long
+	for _, login := range []string{"UnknownNickname", "Unknown@email.com"} {
+		before := atomic.LoadInt32(&compares)
+		if _, err := store.VerifyPassword(login, "Password"); err != ErrInvalidCredentials {
+			t.Errorf("VerifyPassword for %v output err %v but %v was expected", login, err, ErrInvalidCredentials)
+		}
+		if atomic.LoadInt32(&compares) != before+1 {
+			t.Errorf("VerifyPassword for %v compared no hash", login)
+		}
+	}
+}
+
+//blockingHasher signals each Compare then waits to be released
+type blockingHasher struct {
+	PasswordHasher
+	comparing chan bool
+	release   chan bool
+}
+
+func (h blockingHasher) Compare(hash, password string) error {
+	h.comparing <- true
+	<-h.release
+	return h.PasswordHasher.Compare(hash, password)
+}
+
+func TestMemoryVerifyPasswordUnlocked(t *testing.T) {
+	bcryptHasher, _ := NewPasswordHasher(HashBcrypt, 4)
+	hasher := blockingHasher{PasswordHasher: bcryptHasher, comparing: make(chan bool), release: make(chan bool)}
+	store := NewUsersMemoryStore(hasher)
+	user := User{FirstName: "FirstName", LastName: "LastName", Nickname: "Nickname", Password: "Password", Email: "Email@email.com", Country: "Country"}
+	if err := store.Create("", &user); err != nil {
+		t.Fatalf("Create user failled with err %v", err)
+	}
+
+	verified := make(chan error)
+	go func() {
+		_, err := store.VerifyPassword("Nickname", "Password")
+		verified <- err
+	}()
+	<-hasher.comparing
+	//the store is not locked during the comparison
+	created := make(chan error)
+	go func() {
+		created <- store.Create("", &User{FirstName: "Other", LastName: "LastName", Nickname: "Other", Password: "Password", Email: "Other@email.com", Country: "Country"})
+	}()
+	select {
+	case err := <-created:
+		if err != nil {
+			t.Errorf("Create during a VerifyPassword output err %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Create waited for the VerifyPassword comparison")
+	}
+	close(hasher.release)
+	if err := <-verified; err != nil {
+		t.Errorf("VerifyPassword output err %v not expected", err)
+	}
+}
+
+type storePatchTest struct {
+	id          string
+	patch       string
+	expected    User
+	expectedErr bool
 }
 
-type listParameters struct {
-	text, id, firstName, lastname, nickname, password, email, country  string
-	startDateCreated, endDateCreated, startDateUpdated, endDateUpdated time.Time
-	page, pageSize                                                     int64
+func TestStorePatch(t *testing.T) {
+	user := User{
+		FirstName: "FirstName",
+		LastName:  "LastName",
+		Nickname:  "Nickname",
+		Password:  "Password",
+		Email:     "Email@email.com",
+		Country:   "Country",
+	}
+	otherUser := User{
+		FirstName: "OFirstName",
+		LastName:  "OLastName",
+		Nickname:  "ONickname",
+		Password:  "OPassword",
+		Email:     "OEmail@email.com",
+		Country:   "OCountry",
+	}
+	for _, u := range []*User{&user, &otherUser} {
+		resultErr := testUsersStore.Create("", u)
+		if resultErr != nil {
+			t.Errorf("Create user failled for patch test of item %v with err %v", u, resultErr)
+		}
+	}
+
+	storePatchTests := []storePatchTest{
+		//test normal behaviors, only the patched fields are modified
+		{
+			id:    user.ID,
+			patch: `{"last_name":"Longbow"}`,
+			expected: User{
+				FirstName: "FirstName",
+				LastName:  "Longbow",
+				Nickname:  "Nickname",
+				Email:     "Email@email.com",
+				Country:   "Country",
+			},
+			expectedErr: false,
+		},
+		{
+			id:    user.ID,
+			patch: `{"country":"UK","password":"NewPassword"}`,
+			expected: User{
+				FirstName: "FirstName",
+				LastName:  "Longbow",
+				Nickname:  "Nickname",
+				Email:     "Email@email.com",
+				Country:   "UK",
+			},
+			expectedErr: false,
+		},
+		//test invalid values
+		{id: user.ID, patch: `{"email":"Emailemailcom"}`, expectedErr: true},
+		{id: user.ID, patch: `{"first_name":""}`, expectedErr: true},
+		//test duplicate nickname
+		{id: user.ID, patch: `{"nickname":"ONickname"}`, expectedErr: true},
+		//test unknown id
+		{id: "61e41ed578752c5997718a00", patch: `{"last_name":"Longbow"}`, expectedErr: true},
+	}
+
+	for _, item := range storePatchTests {
+		patch, err := ParseMergePatch([]byte(item.patch))
+		if err != nil {
+			t.Errorf("ParseMergePatch for %v output err %v not expected", item.patch, err.Error())
+			continue
+		}
+		var resultUser User
+		resultErr := testUsersStore.Patch("", item.id, AnyVersion, patch, &resultUser)
+		if !item.expectedErr {
+			if resultErr != nil {
+				t.Errorf("usersStore.Patch for %v output err %v not expected", item.patch, resultErr.Error())
+			} else if !resultUser.IsSoftEqual(&item.expected) || resultUser.ID != user.ID || !resultUser.CreatedAt.Equal(user.CreatedAt) {
+				t.Errorf("usersStore.Patch for %v output %v but expected %v", item.patch, resultUser, item.expected)
+			}
+		}
+		if item.expectedErr && resultErr == nil {
+			t.Errorf("usersStore.Patch for %v output no err but one was expected", item.patch)
+		}
+	}
+
+	//the patched password is hashed
+	_, resultErr := testUsersStore.VerifyPassword("Nickname", "NewPassword")
+	if resultErr != nil {
+		t.Errorf("usersStore.VerifyPassword after patch output err %v not expected", resultErr)
+	}
+
+	//delete to clean
+	for _, u := range []*User{&user, &otherUser} {
+		resultErr = deleteForGood(u.ID)
+		if resultErr != nil {
+			t.Errorf("Failled to delete patch user with err %v", resultErr)
+		}
+	}
 }
 
 type storeListTest struct {
-	parameters    listParameters
+	filter        UserFilter
+	opts          ListOptions
 	usersExpected []*User
 	expectedErr   bool
 }
@@ -779,7 +1308,7 @@ func TestStoreList(t *testing.T) {
 
 	//insert for test
 	for index, itemToCreate := range usersInit {
-		resultErr := testUsersStore.Create(itemToCreate)
+		resultErr := testUsersStore.Create("", itemToCreate)
 		if resultErr != nil {
 			t.Errorf("List user failled to create for test of index %v item %v with err %v", index, itemToCreate, resultErr)
 		}
@@ -789,13 +1318,14 @@ func TestStoreList(t *testing.T) {
 	storeListTests := []storeListTest{
 		//normal return all
 		{
-			parameters:    listParameters{},
+			filter:        UserFilter{},
 			usersExpected: usersInit,
 			expectedErr:   false,
 		},
 		//normal with page and page_size
 		{
-			parameters: listParameters{page: 1, pageSize: 2},
+			filter: UserFilter{},
+			opts:   ListOptions{Page: 1, PageSize: 2},
 			usersExpected: []*User{
 				usersInit[len(usersInit)-3],
 				usersInit[len(usersInit)-4],
@@ -804,7 +1334,8 @@ func TestStoreList(t *testing.T) {
 		},
 		//partial page
 		{
-			parameters: listParameters{page: 2, pageSize: 3},
+			filter: UserFilter{},
+			opts:   ListOptions{Page: 2, PageSize: 3},
 			usersExpected: []*User{
 				usersInit[len(usersInit)-7],
 			},
@@ -812,13 +1343,15 @@ func TestStoreList(t *testing.T) {
 		},
 		//no result page too far
 		{
-			parameters:    listParameters{page: 3, pageSize: 3},
+			filter:        UserFilter{},
+			opts:          ListOptions{Page: 3, PageSize: 3},
 			usersExpected: []*User{},
 			expectedErr:   false,
 		},
 		//text in multiple field
 		{
-			parameters: listParameters{text: "2", page: 0, pageSize: 3},
+			filter: UserFilter{}.Where(TextField, OpRegex, "2"),
+			opts:   ListOptions{Page: 0, PageSize: 3},
 			usersExpected: []*User{
 				usersInit[len(usersInit)-3],
 				usersInit[len(usersInit)-2],
@@ -826,7 +1359,8 @@ func TestStoreList(t *testing.T) {
 			expectedErr: false,
 		},
 		{
-			parameters: listParameters{text: "0P", page: 0, pageSize: 3},
+			filter: UserFilter{}.Where(TextField, OpRegex, "0N"),
+			opts:   ListOptions{Page: 0, PageSize: 3},
 			usersExpected: []*User{
 				usersInit[len(usersInit)-1],
 			},
@@ -834,42 +1368,40 @@ func TestStoreList(t *testing.T) {
 		},
 		//various parameters
 		{
-			parameters: listParameters{country: "0Country", page: 0, pageSize: 3},
+			filter: UserFilter{}.Where("country", OpRegex, "0Country"),
+			opts:   ListOptions{Page: 0, PageSize: 3},
 			usersExpected: []*User{
 				usersInit[len(usersInit)-1],
 			},
 			expectedErr: false,
 		},
 		{
-			parameters: listParameters{email: "1Email@email.com", page: 0, pageSize: 3},
+			filter: UserFilter{}.Where("email", OpRegex, "1Email@email.com"),
+			opts:   ListOptions{Page: 0, PageSize: 3},
 			usersExpected: []*User{
 				usersInit[len(usersInit)-2],
 			},
 			expectedErr: false,
 		},
 		{
-			parameters: listParameters{password: "2Password", page: 0, pageSize: 3},
-			usersExpected: []*User{
-				usersInit[len(usersInit)-3],
-			},
-			expectedErr: false,
-		},
-		{
-			parameters: listParameters{nickname: "3Nickname", page: 0, pageSize: 3},
+			filter: UserFilter{}.Where("nickname", OpRegex, "3Nickname"),
+			opts:   ListOptions{Page: 0, PageSize: 3},
 			usersExpected: []*User{
 				usersInit[len(usersInit)-4],
 			},
 			expectedErr: false,
 		},
 		{
-			parameters: listParameters{lastname: "4LastName", page: 0, pageSize: 3},
+			filter: UserFilter{}.Where("last_name", OpRegex, "4LastName"),
+			opts:   ListOptions{Page: 0, PageSize: 3},
 			usersExpected: []*User{
 				usersInit[len(usersInit)-5],
 			},
 			expectedErr: false,
 		},
 		{
-			parameters: listParameters{firstName: "5FirstName", page: 0, pageSize: 3},
+			filter: UserFilter{}.Where("first_name", OpRegex, "5FirstName"),
+			opts:   ListOptions{Page: 0, PageSize: 3},
 			usersExpected: []*User{
 				usersInit[len(usersInit)-6],
 			},
@@ -877,7 +1409,8 @@ func TestStoreList(t *testing.T) {
 		},
 		//dates
 		{
-			parameters: listParameters{startDateCreated: usersInit[len(usersInit)-2].CreatedAt, page: 0, pageSize: 3},
+			filter: UserFilter{}.Where("created_at", OpRange, usersInit[len(usersInit)-2].CreatedAt.Format(time.RFC3339Nano), ""),
+			opts:   ListOptions{Page: 0, PageSize: 3},
 			usersExpected: []*User{
 				usersInit[len(usersInit)-2],
 				usersInit[len(usersInit)-1],
@@ -885,7 +1418,8 @@ func TestStoreList(t *testing.T) {
 			expectedErr: false,
 		},
 		{
-			parameters: listParameters{endDateCreated: usersInit[1].CreatedAt, page: 0, pageSize: 3},
+			filter: UserFilter{}.Where("created_at", OpRange, "", usersInit[1].CreatedAt.Format(time.RFC3339Nano)),
+			opts:   ListOptions{Page: 0, PageSize: 3},
 			usersExpected: []*User{
 				usersInit[1],
 				usersInit[0],
@@ -893,7 +1427,8 @@ func TestStoreList(t *testing.T) {
 			expectedErr: false,
 		},
 		{
-			parameters: listParameters{startDateUpdated: usersInit[len(usersInit)-2].UpdatedAt, page: 0, pageSize: 3},
+			filter: UserFilter{}.Where("updated_at", OpRange, usersInit[len(usersInit)-2].UpdatedAt.Format(time.RFC3339Nano), ""),
+			opts:   ListOptions{Page: 0, PageSize: 3},
 			usersExpected: []*User{
 				usersInit[len(usersInit)-2],
 				usersInit[len(usersInit)-1],
@@ -901,7 +1436,8 @@ func TestStoreList(t *testing.T) {
 			expectedErr: false,
 		},
 		{
-			parameters: listParameters{endDateUpdated: usersInit[1].UpdatedAt, page: 0, pageSize: 3},
+			filter: UserFilter{}.Where("updated_at", OpRange, "", usersInit[1].UpdatedAt.Format(time.RFC3339Nano)),
+			opts:   ListOptions{Page: 0, PageSize: 3},
 			usersExpected: []*User{
 				usersInit[1],
 				usersInit[0],
@@ -910,58 +1446,170 @@ func TestStoreList(t *testing.T) {
 		},
 		// complex query
 		{
-			parameters: listParameters{startDateUpdated: usersInit[1].UpdatedAt, text: "12", country: "oun", page: 0, pageSize: 3},
+			filter: UserFilter{}.Where("updated_at", OpRange, usersInit[1].UpdatedAt.Format(time.RFC3339Nano), "").Where(TextField, OpRegex, "12").Where("country", OpRegex, "oun"),
+			opts:   ListOptions{Page: 0, PageSize: 3},
 			usersExpected: []*User{
 				usersInit[len(usersInit)-2],
 			},
 			expectedErr: false,
 		},
 		{
-			parameters:    listParameters{startDateUpdated: usersInit[1].UpdatedAt, text: "12", country: "oun", firstName: "boris", page: 0, pageSize: 3},
+			filter:        UserFilter{}.Where("updated_at", OpRange, usersInit[1].UpdatedAt.Format(time.RFC3339Nano), "").Where(TextField, OpRegex, "12").Where("country", OpRegex, "oun").Where("first_name", OpRegex, "boris"),
+			opts:          ListOptions{Page: 0, PageSize: 3},
 			usersExpected: []*User{},
 			expectedErr:   false,
 		},
-	}
-
-	for _, item := range storeListTests {
-		resultUsers, _, resultErr := testUsersStore.List(
-			item.parameters.text,
-			item.parameters.id,
-			item.parameters.firstName,
-			item.parameters.lastname,
-			item.parameters.nickname,
-			item.parameters.password,
-			item.parameters.email,
-			item.parameters.country,
-			item.parameters.startDateCreated,
-			item.parameters.endDateCreated,
-			item.parameters.startDateUpdated,
-			item.parameters.endDateUpdated,
-			item.parameters.page,
-			item.parameters.pageSize,
-		)
-		if !item.expectedErr {
-			if resultErr != nil {
-				t.Errorf("usersStore.List for %v output err %v not expected", item.parameters, resultErr.Error())
-			} else {
-				if !areSameUsers(item.usersExpected, resultUsers) {
-					var usersExpected []User
-					for _, expected := range item.usersExpected {
-						usersExpected = append(usersExpected, *expected)
-					}
-					t.Errorf("usersStore.List for %v output %v but expected %v", item.parameters, resultUsers, usersExpected)
-
+		//operators
+		{
+			filter: UserFilter{}.Where("nickname", OpPrefix, "1N"),
+			usersExpected: []*User{
+				usersInit[len(usersInit)-2],
+			},
+			expectedErr: false,
+		},
+		{
+			filter: UserFilter{}.Where("last_name", OpContains, "2L"),
+			usersExpected: []*User{
+				usersInit[len(usersInit)-2],
+				usersInit[len(usersInit)-3],
+			},
+			expectedErr: false,
+		},
+		{
+			filter: UserFilter{}.Where("country", OpIn, "0Country", "6Country"),
+			usersExpected: []*User{
+				usersInit[0],
+				usersInit[len(usersInit)-1],
+			},
+			expectedErr: false,
+		},
+		{
+			filter: UserFilter{}.Where("country", OpRange, "3Country", "").Where("country", OpNot, "5Country"),
+			usersExpected: []*User{
+				usersInit[0],
+				usersInit[2],
+				usersInit[3],
+			},
+			expectedErr: false,
+		},
+		{
+			filter: UserFilter{}.Where("first_name", OpEq, "4FirstName").And(UserFilter{}.Where("email", OpEq, "4Email@email.com")),
+			usersExpected: []*User{
+				usersInit[2],
+			},
+			expectedErr: false,
+		},
+		//the values are literal, a regex is opt-in
+		{
+			filter:        UserFilter{}.Where("nickname", OpContains, ".*"),
+			usersExpected: []*User{},
+			expectedErr:   false,
+		},
+		{
+			filter: UserFilter{}.Where("nickname", OpRegex, "^[01]N"),
+			usersExpected: []*User{
+				usersInit[len(usersInit)-1],
+				usersInit[len(usersInit)-2],
+			},
+			expectedErr: false,
+		},
+		//case insensitive
+		{
+			filter: UserFilter{}.WhereFold("nickname", OpEq, "0NICKNAME"),
+			usersExpected: []*User{
+				usersInit[len(usersInit)-1],
+			},
+			expectedErr: false,
+		},
+		{
+			filter: UserFilter{}.WhereFold(TextField, OpContains, "12lastn"),
+			usersExpected: []*User{
+				usersInit[len(usersInit)-2],
+			},
+			expectedErr: false,
+		},
+		{
+			filter: UserFilter{}.WhereFold("country", OpIn, "0COUNTRY", "6country").WhereFold("nickname", OpNot, "6NICKNAME"),
+			usersExpected: []*User{
+				usersInit[len(usersInit)-1],
+			},
+			expectedErr: false,
+		},
+		{
+			filter: UserFilter{}.WhereFold("first_name", OpRegex, "^0first"),
+			usersExpected: []*User{
+				usersInit[len(usersInit)-1],
+			},
+			expectedErr: false,
+		},
+		//invalid conditions
+		{
+			filter:        UserFilter{}.Where("password", OpEq, "0Password"),
+			usersExpected: nil,
+			expectedErr:   true,
+		},
+		{
+			filter:        UserFilter{}.Where("id", OpPrefix, "61"),
+			usersExpected: nil,
+			expectedErr:   true,
+		},
+		{
+			filter:        UserFilter{}.Where("country", OpRange, "0Country"),
+			usersExpected: nil,
+			expectedErr:   true,
+		},
+		{
+			filter:        UserFilter{}.Where("created_at", OpRange, "yesterday", ""),
+			usersExpected: nil,
+			expectedErr:   true,
+		},
+		{
+			filter:        UserFilter{}.Where("nickname", OpRegex, "(0N"),
+			usersExpected: nil,
+			expectedErr:   true,
+		},
+		{
+			filter:        UserFilter{}.Where("nickname", OpRegex, strings.Repeat("a", MaxRegexLength+1)),
+			usersExpected: nil,
+			expectedErr:   true,
+		},
+		{
+			filter:        UserFilter{}.WhereFold("country", OpRange, "0country", ""),
+			usersExpected: nil,
+			expectedErr:   true,
+		},
+		{
+			filter:        UserFilter{}.WhereFold("role", OpEq, "ADMIN"),
+			usersExpected: nil,
+			expectedErr:   true,
+		},
+	}
+
+	for _, item := range storeListTests {
+		resultPage, resultErr := testUsersStore.List(item.filter, item.opts)
+		if !item.expectedErr {
+			if resultErr != nil {
+				t.Errorf("usersStore.List for %v output err %v not expected", item.filter, resultErr.Error())
+			} else {
+				resultUsers := resultPage.Users
+				if !areSameUsers(item.usersExpected, resultUsers) {
+					var usersExpected []User
+					for _, expected := range item.usersExpected {
+						usersExpected = append(usersExpected, *expected)
+					}
+					t.Errorf("usersStore.List for %v output %v but expected %v", item.filter, resultUsers, usersExpected)
+
 				}
 			}
 		}
 		if item.expectedErr && resultErr == nil {
-			t.Errorf("usersStore.List for %v output err expected but not found", item.parameters)
+			t.Errorf("usersStore.List for %v output err expected but not found", item.filter)
 		}
 	}
 
 	//delete to clean
 	for index, itemToCreate := range usersInit {
-		resultErr := testUsersStore.Delete(itemToCreate.ID)
+		resultErr := deleteForGood(itemToCreate.ID)
 		if resultErr != nil {
 			t.Errorf("List user failled to delete for test of index %v item %v with err %v", index, itemToCreate, resultErr)
 		}
@@ -986,3 +1634,728 @@ func areSameUsers(usersA []*User, usersB []User) bool {
 	}
 	return true
 }
+
+type storeListTotalTest struct {
+	page, pageSize  int64
+	expectedLen     int
+	expectedHasNext bool
+}
+
+func TestStoreListTotal(t *testing.T) {
+	var usersInit []User
+	for _, suffix := range []string{"1", "2", "3", "4", "5"} {
+		user := User{
+			FirstName: "FirstName",
+			LastName:  "LastName",
+			Nickname:  "TotalNickname" + suffix,
+			Password:  "Password",
+			Email:     "TotalEmail" + suffix + "@email.com",
+			Country:   "TotalCountry",
+		}
+		resultErr := testUsersStore.Create("", &user)
+		if resultErr != nil {
+			t.Errorf("Create user failled for list total test of item %v with err %v", user, resultErr)
+		}
+		usersInit = append(usersInit, user)
+	}
+
+	storeListTotalTests := []storeListTotalTest{
+		//test without pagination
+		{page: 0, pageSize: 0, expectedLen: 5},
+		//test full and partial pages
+		{page: 0, pageSize: 2, expectedLen: 2, expectedHasNext: true},
+		{page: 1, pageSize: 2, expectedLen: 2, expectedHasNext: true},
+		{page: 2, pageSize: 2, expectedLen: 1},
+		{page: 0, pageSize: 5, expectedLen: 5},
+		//test a page too far
+		{page: 3, pageSize: 2, expectedLen: 0},
+	}
+
+	for _, item := range storeListTotalTests {
+		resultPage, resultErr := testUsersStore.List(UserFilter{}.Where("country", OpEq, "TotalCountry"), ListOptions{Page: item.page, PageSize: item.pageSize})
+		if resultErr != nil {
+			t.Errorf("usersStore.List for page %v of %v output err %v not expected", item.page, item.pageSize, resultErr.Error())
+			continue
+		}
+		if len(resultPage.Users) != item.expectedLen || resultPage.Total != len(usersInit) || resultPage.HasNext != item.expectedHasNext {
+			t.Errorf("usersStore.List for page %v of %v output %v users, a total of %v and has next %v but expected %v, %v and %v", item.page, item.pageSize, len(resultPage.Users), resultPage.Total, resultPage.HasNext, item.expectedLen, len(usersInit), item.expectedHasNext)
+		}
+	}
+
+	//delete to clean
+	for _, user := range usersInit {
+		resultErr := deleteForGood(user.ID)
+		if resultErr != nil {
+			t.Errorf("Failled to delete list total user with err %v", resultErr)
+		}
+	}
+}
+
+func TestStoreListCursor(t *testing.T) {
+	var usersInit []User
+	for _, suffix := range []string{"1", "2", "3", "4", "5"} {
+		user := User{
+			FirstName: "FirstName",
+			LastName:  "LastName",
+			Nickname:  "CursorNickname" + suffix,
+			Password:  "Password",
+			Email:     "CursorEmail" + suffix + "@email.com",
+			Country:   "CursorCountry",
+		}
+		resultErr := testUsersStore.Create("", &user)
+		if resultErr != nil {
+			t.Errorf("Create user failled for list cursor test of item %v with err %v", user, resultErr)
+		}
+		usersInit = append(usersInit, user)
+	}
+
+	//walks the pages with the cursor, a User created meanwhile is neither skipped nor repeated
+	var resultUsers []User
+	opts := ListOptions{PageSize: 2}
+	for pages := 0; pages < 5; pages++ {
+		resultPage, resultErr := testUsersStore.List(UserFilter{}.Where("country", OpEq, "CursorCountry"), opts)
+		if resultErr != nil {
+			t.Fatalf("usersStore.List with cursor %v output err %v not expected", opts.Cursor, resultErr.Error())
+		}
+		resultUsers = append(resultUsers, resultPage.Users...)
+		if pages == 0 {
+			user := User{
+				FirstName: "FirstName",
+				LastName:  "LastName",
+				Nickname:  "CursorNickname6",
+				Password:  "Password",
+				Email:     "CursorEmail6@email.com",
+				Country:   "CursorCountry",
+			}
+			resultErr = testUsersStore.Create("", &user)
+			if resultErr != nil {
+				t.Errorf("Create user failled for list cursor test of item %v with err %v", user, resultErr)
+			}
+			defer deleteForGood(user.ID)
+		}
+		if !resultPage.HasNext {
+			break
+		}
+		opts.Cursor, resultErr = DecodeCursor(resultPage.NextCursor, DefaultSort)
+		if resultErr != nil {
+			t.Fatalf("DecodeCursor for %v output err %v not expected", resultPage.NextCursor, resultErr.Error())
+		}
+	}
+	var usersExpected []*User
+	for i := len(usersInit) - 1; i >= 0; i-- {
+		usersExpected = append(usersExpected, &usersInit[i])
+	}
+	if !areSameUsers(usersExpected, resultUsers) {
+		t.Errorf("usersStore.List with cursor output %v but expected %v", resultUsers, usersInit)
+	}
+
+	//delete to clean
+	for _, user := range usersInit {
+		resultErr := deleteForGood(user.ID)
+		if resultErr != nil {
+			t.Errorf("Failled to delete list cursor user with err %v", resultErr)
+		}
+	}
+}
+
+type decodeCursorTest struct {
+	value       string
+	sort        Sort
+	expectedErr bool
+}
+
+func TestDecodeCursor(t *testing.T) {
+	user := User{ID: "61e41ed578752c5997718aff", LastName: "LastName", CreatedAt: time.Now()}
+	lastNameSort := Sort{{Field: "last_name"}}
+	decodeCursorTests := []decodeCursorTest{
+		//test normal behaviors
+		{value: NewCursor(&user, DefaultSort).Encode(), sort: DefaultSort, expectedErr: false},
+		{value: NewCursor(&user, lastNameSort).Encode(), sort: lastNameSort, expectedErr: false},
+		//test a cursor of another sort
+		{value: NewCursor(&user, DefaultSort).Encode(), sort: lastNameSort, expectedErr: true},
+		//test invalid cursors
+		{value: "61e41ed578752c5997718aff", sort: DefaultSort, expectedErr: true},
+		{value: "e30", sort: DefaultSort, expectedErr: true},
+		{value: (&Cursor{Sort: "-created_at", Values: []string{"yesterday"}, ID: user.ID}).Encode(), sort: DefaultSort, expectedErr: true},
+		{value: NewCursor(&User{ID: "61e41ed5787", CreatedAt: user.CreatedAt}, DefaultSort).Encode(), sort: DefaultSort, expectedErr: true},
+	}
+
+	for _, item := range decodeCursorTests {
+		result, resultErr := DecodeCursor(item.value, item.sort)
+		if !item.expectedErr {
+			if resultErr != nil {
+				t.Errorf("DecodeCursor for %v output err %v not expected", item.value, resultErr.Error())
+			} else if result.ID != user.ID || result.Sort != item.sort.String() {
+				t.Errorf("DecodeCursor for %v output %v", item.value, result)
+			}
+		}
+		if item.expectedErr && resultErr == nil {
+			t.Errorf("DecodeCursor for %v output no err but one was expected", item.value)
+		}
+	}
+}
+
+type parseSortTest struct {
+	value        string
+	expectedSort Sort
+	expectedErr  bool
+}
+
+func TestParseSort(t *testing.T) {
+	parseSortTests := []parseSortTest{
+		//test normal behaviors
+		{value: "last_name", expectedSort: Sort{{Field: "last_name"}}, expectedErr: false},
+		{value: "last_name,-updated_at", expectedSort: Sort{{Field: "last_name"}, {Field: "updated_at", Desc: true}}, expectedErr: false},
+		{value: "-score,last_name", expectedSort: Sort{{Field: "score", Desc: true}, {Field: "last_name"}}, expectedErr: false},
+		//test the score only descending
+		{value: "score", expectedErr: true},
+		//test fields not sortable
+		{value: "password", expectedErr: true},
+		{value: "last_name,-_id", expectedErr: true},
+		{value: "last_name,", expectedErr: true},
+	}
+
+	for _, item := range parseSortTests {
+		result, resultErr := ParseSort(item.value)
+		if !item.expectedErr {
+			if resultErr != nil {
+				t.Errorf("ParseSort for %v output err %v not expected", item.value, resultErr.Error())
+			} else if result.String() != item.expectedSort.String() || result.String() != item.value {
+				t.Errorf("ParseSort for %v output %v but expected %v", item.value, result, item.expectedSort)
+			}
+		}
+		if item.expectedErr && resultErr == nil {
+			t.Errorf("ParseSort for %v output no err but one was expected", item.value)
+		}
+	}
+}
+
+func TestStoreListSort(t *testing.T) {
+	var usersInit []User
+	for _, lastName := range []string{"B", "A", "C", "A", "B"} {
+		user := User{
+			FirstName: "FirstName",
+			LastName:  lastName,
+			Nickname:  "SortNickname" + strconv.Itoa(len(usersInit)),
+			Password:  "Password",
+			Email:     "SortEmail" + strconv.Itoa(len(usersInit)) + "@email.com",
+			Country:   "SortCountry",
+		}
+		resultErr := testUsersStore.Create("", &user)
+		if resultErr != nil {
+			t.Errorf("Create user failled for list sort test of item %v with err %v", user, resultErr)
+		}
+		usersInit = append(usersInit, user)
+	}
+	//by last_name, then the most recently updated, then the id
+	usersExpected := []*User{&usersInit[3], &usersInit[1], &usersInit[4], &usersInit[0], &usersInit[2]}
+	listSort := Sort{{Field: "last_name"}, {Field: "updated_at", Desc: true}}
+
+	resultPage, resultErr := testUsersStore.List(UserFilter{}.Where("country", OpEq, "SortCountry"), ListOptions{Sort: listSort})
+	if resultErr != nil {
+		t.Fatalf("usersStore.List sorted by %v output err %v not expected", listSort, resultErr.Error())
+	}
+	if !areSameUsers(usersExpected, resultPage.Users) {
+		t.Errorf("usersStore.List sorted by %v output %v", listSort, resultPage.Users)
+	}
+
+	//the cursor follows the sort
+	var resultUsers []User
+	opts := ListOptions{Sort: listSort, PageSize: 2}
+	for pages := 0; pages < 5; pages++ {
+		resultPage, resultErr = testUsersStore.List(UserFilter{}.Where("country", OpEq, "SortCountry"), opts)
+		if resultErr != nil {
+			t.Fatalf("usersStore.List sorted by %v with cursor %v output err %v not expected", listSort, opts.Cursor, resultErr.Error())
+		}
+		resultUsers = append(resultUsers, resultPage.Users...)
+		if !resultPage.HasNext {
+			break
+		}
+		opts.Cursor, resultErr = DecodeCursor(resultPage.NextCursor, listSort)
+		if resultErr != nil {
+			t.Fatalf("DecodeCursor for %v output err %v not expected", resultPage.NextCursor, resultErr.Error())
+		}
+	}
+	if !areSameUsers(usersExpected, resultUsers) {
+		t.Errorf("usersStore.List sorted by %v with cursor output %v", listSort, resultUsers)
+	}
+
+	//delete to clean
+	for _, user := range usersInit {
+		resultErr := deleteForGood(user.ID)
+		if resultErr != nil {
+			t.Errorf("Failled to delete list sort user with err %v", resultErr)
+		}
+	}
+}
+
+type parseFieldsTest struct {
+	value          string
+	expectedFields []string
+	expectedErr    bool
+}
+
+func TestParseFields(t *testing.T) {
+	parseFieldsTests := []parseFieldsTest{
+		//test normal behaviors
+		{value: "id", expectedFields: []string{"id"}, expectedErr: false},
+		{value: "first_name, email", expectedFields: []string{"first_name", "email"}, expectedErr: false},
+		//test fields not selectable
+		{value: "password", expectedErr: true},
+		{value: "id,_id", expectedErr: true},
+		{value: "id,", expectedErr: true},
+	}
+
+	for _, item := range parseFieldsTests {
+		result, resultErr := ParseFields(item.value)
+		if !item.expectedErr {
+			if resultErr != nil {
+				t.Errorf("ParseFields for %v output err %v not expected", item.value, resultErr.Error())
+			} else if strings.Join(result, ",") != strings.Join(item.expectedFields, ",") {
+				t.Errorf("ParseFields for %v output %v but expected %v", item.value, result, item.expectedFields)
+			}
+		}
+		if item.expectedErr && resultErr == nil {
+			t.Errorf("ParseFields for %v output no err but one was expected", item.value)
+		}
+	}
+}
+
+func TestStoreListFields(t *testing.T) {
+	var usersInit []User
+	for _, suffix := range []string{"1", "2", "3"} {
+		user := User{
+			FirstName: "FirstName",
+			LastName:  "LastName" + suffix,
+			Nickname:  "FieldsNickname" + suffix,
+			Password:  "Password",
+			Email:     "FieldsEmail" + suffix + "@email.com",
+			Country:   "FieldsCountry",
+		}
+		resultErr := testUsersStore.Create("", &user)
+		if resultErr != nil {
+			t.Errorf("Create user failled for list fields test of item %v with err %v", user, resultErr)
+		}
+		usersInit = append(usersInit, user)
+	}
+
+	//only the selected fields, the id and the sort fields are read, the cursor still works
+	var resultUsers []User
+	opts := ListOptions{Sort: Sort{{Field: "last_name"}}, PageSize: 2, Fields: []string{"nickname"}}
+	for pages := 0; pages < 3; pages++ {
+		resultPage, resultErr := testUsersStore.List(UserFilter{}.Where("country", OpEq, "FieldsCountry"), opts)
+		if resultErr != nil {
+			t.Fatalf("usersStore.List with fields %v output err %v not expected", opts.Fields, resultErr.Error())
+		}
+		resultUsers = append(resultUsers, resultPage.Users...)
+		if !resultPage.HasNext {
+			break
+		}
+		opts.Cursor, resultErr = DecodeCursor(resultPage.NextCursor, opts.Sort)
+		if resultErr != nil {
+			t.Fatalf("DecodeCursor for %v output err %v not expected", resultPage.NextCursor, resultErr.Error())
+		}
+	}
+	if len(resultUsers) != len(usersInit) {
+		t.Fatalf("usersStore.List with fields %v output %v users but expected %v", opts.Fields, len(resultUsers), len(usersInit))
+	}
+	for i, user := range resultUsers {
+		expected := User{ID: usersInit[i].ID, LastName: usersInit[i].LastName, Nickname: usersInit[i].Nickname}
+		if user != expected {
+			t.Errorf("usersStore.List with fields %v output %v but expected %v", opts.Fields, user, expected)
+		}
+	}
+
+	//delete to clean
+	for _, user := range usersInit {
+		resultErr := deleteForGood(user.ID)
+		if resultErr != nil {
+			t.Errorf("Failled to delete list fields user with err %v", resultErr)
+		}
+	}
+}
+
+type decodeListQueryTest struct {
+	query          string
+	expectedFilter UserFilter
+	expectedErr    bool
+}
+
+func TestDecodeListQuery(t *testing.T) {
+	decodeListQueryTests := []decodeListQueryTest{
+		//test normal behaviors
+		{query: "", expectedFilter: UserFilter{}, expectedErr: false},
+		{query: "first_name=Jo&page=1&page_size=2&sort=last_name&fields=id", expectedFilter: UserFilter{}.Where("first_name", OpContains, "Jo"), expectedErr: false},
+		{query: "nickname[prefix]=Jo%20B&country[in]=FR,US&id=61e41ed578752c5997718aff", expectedFilter: UserFilter{}.Where("country", OpIn, "FR", "US").Where("id", OpEq, "61e41ed578752c5997718aff").Where("nickname", OpPrefix, "Jo B"), expectedErr: false},
+		{query: "first_name=Jose%CC%81", expectedFilter: UserFilter{}.Where("first_name", OpContains, "Jos\u00e9"), expectedErr: false},
+		{query: "email[eq]=a@b.c&text=a.b", expectedFilter: UserFilter{}.Where("email", OpEq, "a@b.c").Where(TextField, OpContains, "a.b"), expectedErr: false},
+		//test the modes and the options of the text filters
+		{query: "first_name=J(o&mode=regex", expectedErr: true},
+		{query: "first_name=^Jo.*&mode=regex", expectedFilter: UserFilter{}.Where("first_name", OpRegex, "^Jo.*"), expectedErr: false},
+		{query: "first_name=Jo&last_name[contains]=B&mode=prefix", expectedFilter: UserFilter{}.Where("first_name", OpPrefix, "Jo").Where("last_name", OpContains, "B"), expectedErr: false},
+		{query: "text=Jo&mode=exact&options=i&id=61e41ed578752c5997718aff", expectedFilter: UserFilter{}.Where("id", OpEq, "61e41ed578752c5997718aff").WhereFold(TextField, OpEq, "Jo"), expectedErr: false},
+		{query: "first_name=Jo&mode=like", expectedErr: true},
+		{query: "first_name=Jo&options=x", expectedErr: true},
+		{query: "created_at[range]=2022-01-01T00:00:00Z,&startdupdated=2022-01-01T00:00:00Z", expectedFilter: UserFilter{}.Where("created_at", OpRange, "2022-01-01T00:00:00Z", "").Where("updated_at", OpRange, "2022-01-01T00:00:00Z", ""), expectedErr: false},
+		{query: "unknown=value", expectedFilter: UserFilter{}, expectedErr: false},
+		{query: "text=+Jonh+&mode=fuzzy&nickname=Jo", expectedFilter: UserFilter{}.Where("nickname", OpContains, "Jo").Resembling("Jonh"), expectedErr: false},
+		{query: "text=Jonh&mode=fuzzy&q=John", expectedErr: true},
+		{query: "q=+Jose%CC%81+Bob&country=FR", expectedFilter: UserFilter{}.Matching("Jos\u00e9 Bob").Where("country", OpContains, "FR"), expectedErr: false},
+		//test invalid filters and options
+		{query: "password[eq]=value", expectedErr: true},
+		{query: "id[prefix]=61", expectedErr: true},
+		{query: "id=61e41ed5787", expectedErr: true},
+		{query: "id=zzzzzzzzzzzzzzzzzzzzzzzz", expectedErr: true},
+		{query: "startdcreated=yesterday", expectedErr: true},
+		{query: "country[range]=FR", expectedErr: true},
+		{query: "page=-1", expectedErr: true},
+		{query: "sort=password", expectedErr: true},
+	}
+
+	for _, item := range decodeListQueryTests {
+		query, err := url.ParseQuery(item.query)
+		if err != nil {
+			t.Fatalf("url.ParseQuery for %v output err %v not expected", item.query, err)
+		}
+		result, _, resultErr := DecodeListQuery(query)
+		if !item.expectedErr {
+			if resultErr != nil {
+				t.Errorf("DecodeListQuery for %v output err %v not expected", item.query, resultErr.Error())
+			} else if (!reflect.DeepEqual(result.Conditions, item.expectedFilter.Conditions) && len(result.Conditions)+len(item.expectedFilter.Conditions) > 0) || result.Search != item.expectedFilter.Search || result.Fuzzy != item.expectedFilter.Fuzzy {
+				t.Errorf("DecodeListQuery for %v output %v but expected %v", item.query, result, item.expectedFilter)
+			}
+		}
+		if item.expectedErr && resultErr == nil {
+			t.Errorf("DecodeListQuery for %v output no err but one was expected", item.query)
+		}
+	}
+}
+
+type unescapedFieldsTest struct {
+	user                 User
+	expectedUser         User
+	expectedChangedCount int
+	expectedErr          bool
+}
+
+func TestUnescapedFields(t *testing.T) {
+	unescapedFieldsTests := []unescapedFieldsTest{
+		//test normal behaviors
+		{
+			user:                 User{FirstName: "FirstName", LastName: "LastName", Nickname: "Nickname", Email: "Email@email.com", Country: "Country"},
+			expectedUser:         User{FirstName: "FirstName", LastName: "LastName", Nickname: "Nickname", Email: "Email@email.com", Country: "Country"},
+			expectedChangedCount: 0,
+			expectedErr:          false,
+		},
+		{
+			user:                 User{FirstName: "FirstName%C2%A3", LastName: "Last%20Name", Nickname: "Myki%20mike", Email: "E%C2%B5mail@email.com", Country: "Jose%CC%81"},
+			expectedUser:         User{FirstName: "FirstName£", LastName: "Last Name", Nickname: "Myki mike", Email: "Eµmail@email.com", Country: "Jos\u00e9"},
+			expectedChangedCount: 5,
+			expectedErr:          false,
+		},
+		//test an escaped percent is unescaped once
+		{
+			user:                 User{FirstName: "FirstName", LastName: "LastName", Nickname: "100%2520", Email: "Email@email.com", Country: "Country"},
+			expectedUser:         User{FirstName: "FirstName", LastName: "LastName", Nickname: "100%20", Email: "Email@email.com", Country: "Country"},
+			expectedChangedCount: 1,
+			expectedErr:          false,
+		},
+		//test a value not escaped
+		{
+			user:        User{FirstName: "100%", LastName: "LastName", Nickname: "Nickname", Email: "Email@email.com", Country: "Country"},
+			expectedErr: true,
+		},
+	}
+
+	for _, item := range unescapedFieldsTests {
+		user := item.user
+		result, resultErr := unescapedFields(&user)
+		if !item.expectedErr {
+			if resultErr != nil {
+				t.Errorf("unescapedFields for %v output err %v not expected", item.user, resultErr.Error())
+			} else if len(result) != item.expectedChangedCount || !user.IsSoftEqual(&item.expectedUser) {
+				t.Errorf("unescapedFields for %v output %v changed in %v but expected %v", item.user, result, user, item.expectedUser)
+			}
+		}
+		if item.expectedErr && resultErr == nil {
+			t.Errorf("unescapedFields for %v output no err but one was expected", item.user)
+		}
+	}
+}
+
+func TestDuplicateValue(t *testing.T) {
+	duplicate := func(index string) error {
+		return mongo.WriteException{WriteErrors: mongo.WriteErrors{{
+			Code:    11000,
+			Message: "E11000 duplicate key error collection: test.users index: " + index + " dup key: { value: \"Other\" }",
+		}}}
+	}
+	duplicateValueTests := []struct {
+		err           error
+		expectedField string
+	}{
+		{err: duplicate("email_1"), expectedField: "email"},
+		{err: duplicate("nickname_1"), expectedField: "nickname"},
+		{err: duplicate("other_1"), expectedField: ""},
+	}
+	for _, item := range duplicateValueTests {
+		result := errors2.Classify(duplicateValue(item.err))
+		var fieldErrors errors2.FieldErrors
+		hasField := errors.As(result, &fieldErrors) && fieldErrors[item.expectedField] != ""
+		if result.Code != errors2.CodeConflict || strings.Contains(result.Error(), "E11000") || hasField != (item.expectedField != "") {
+			t.Errorf("duplicateValue for %v output %v", item.err, result)
+		}
+	}
+	if err := duplicateValue(mongo.ErrNoDocuments); err != mongo.ErrNoDocuments {
+		t.Errorf("duplicateValue of another err output %v", err)
+	}
+}
+
+func TestMigrateUnescape(t *testing.T) {
+	if testDb == nil {
+		t.Skip("the migration needs a database")
+	}
+	ctx := context.TODO()
+	//saved escaped as by the first versions
+	primId := primitive.NewObjectID()
+	_, err := testDb.Collection("users").InsertOne(ctx, bson.M{
+		"_id":        primId,
+		"first_name": "FirstName%C2%A3",
+		"last_name":  "LastName",
+		"nickname":   "Myki%20mike%2520",
+		"email":      "MigrateEmail@email.com",
+		"country":    "Country",
+	})
+	if err != nil {
+		t.Fatalf("Insert escaped user failled with err %v", err)
+	}
+	defer deleteForGood(primId.Hex())
+
+	for _, expectedChanged := range []int{1, 0} {
+		changed, resultErr := MigrateUnescape(ctx, testDb)
+		if resultErr != nil || changed != expectedChanged {
+			t.Errorf("MigrateUnescape output %v changed and err %v but expected %v", changed, resultErr, expectedChanged)
+		}
+	}
+	resultUser, resultErr := testUsersStore.Get(primId.Hex())
+	if resultErr != nil {
+		t.Fatalf("usersStore.Get for the migrated user output err %v not expected", resultErr.Error())
+	}
+	if resultUser.FirstName != "FirstName£" || resultUser.Nickname != "Myki mike%20" {
+		t.Errorf("MigrateUnescape output %v", resultUser)
+	}
+}
+
+func TestMigrateHashPasswords(t *testing.T) {
+	if testDb == nil {
+		t.Skip("the migration needs a database")
+	}
+	ctx := context.TODO()
+	//saved escaped in plain text as by the first versions
+	primId := primitive.NewObjectID()
+	_, err := testDb.Collection("users").InsertOne(ctx, bson.M{
+		"_id":        primId,
+		"first_name": "FirstName",
+		"last_name":  "LastName",
+		"nickname":   "PlainNickname",
+		"password":   "Pass%20word%2B",
+		"email":      "PlainEmail@email.com",
+		"country":    "Country",
+	})
+	if err != nil {
+		t.Fatalf("Insert user with a plain text password failled with err %v", err)
+	}
+	defer deleteForGood(primId.Hex())
+
+	hasher, _ := NewPasswordHasher(HashBcrypt, 4)
+	for _, expectedChanged := range []int{1, 0} {
+		changed, resultErr := MigrateHashPasswords(ctx, testDb, hasher)
+		if resultErr != nil || changed != expectedChanged {
+			t.Errorf("MigrateHashPasswords output %v changed and err %v but expected %v", changed, resultErr, expectedChanged)
+		}
+	}
+	var stored User
+	if err := testDb.Collection("users").FindOne(ctx, bson.M{"_id": primId}).Decode(&stored); err != nil || !IsPasswordHash(stored.Password) {
+		t.Errorf("MigrateHashPasswords saved the password %v with err %v", stored.Password, err)
+	}
+	if _, err := testUsersStore.VerifyPassword("PlainNickname", "Pass word+"); err != nil {
+		t.Errorf("usersStore.VerifyPassword of the migrated password output err %v", err)
+	}
+}
+
+func TestMigrateFuzzyGrams(t *testing.T) {
+	if testDb == nil {
+		t.Skip("the migration needs a database")
+	}
+	ctx := context.TODO()
+	//saved without trigrams as by the previous versions
+	primId := primitive.NewObjectID()
+	_, err := testDb.Collection("users").InsertOne(ctx, bson.M{
+		"_id":        primId,
+		"first_name": "Grams",
+		"last_name":  "LastName",
+		"nickname":   "GramsNickname",
+		"email":      "GramsEmail@email.com",
+		"country":    "Country",
+	})
+	if err != nil {
+		t.Fatalf("Insert user without trigrams failled with err %v", err)
+	}
+	defer deleteForGood(primId.Hex())
+
+	for _, expectedChanged := range []int{1, 0} {
+		changed, resultErr := MigrateFuzzyGrams(ctx, testDb)
+		if resultErr != nil || changed != expectedChanged {
+			t.Errorf("MigrateFuzzyGrams output %v changed and err %v but expected %v", changed, resultErr, expectedChanged)
+		}
+	}
+	result, resultErr := testUsersStore.List(UserFilter{}.Resembling("Gramz"), ListOptions{})
+	if resultErr != nil || len(result.Users) != 1 || result.Users[0].ID != primId.Hex() {
+		t.Errorf("fuzzy List of the migrated user output %v and err %v", result, resultErr)
+	}
+}
+
+func TestStoreListSearch(t *testing.T) {
+	usersInit := []User{
+		{FirstName: "Alpha", LastName: "Bravo", Nickname: "xray", Email: "xray@search.com"},
+		{FirstName: "Xray", LastName: "Charlie", Nickname: "SearchNickname1", Email: "SearchEmail1@email.com"},
+		{FirstName: "xray", LastName: "Xray Delta", Nickname: "SearchNickname2", Email: "SearchEmail2@email.com"},
+		{FirstName: "Echo", LastName: "Foxtrot", Nickname: "SearchNickname3", Email: "SearchEmail3@email.com"},
+	}
+	for i := range usersInit {
+		usersInit[i].Password = "Password"
+		usersInit[i].Country = "SearchCountry"
+		resultErr := testUsersStore.Create("", &usersInit[i])
+		if resultErr != nil {
+			t.Errorf("Create user failled for list search test of item %v with err %v", usersInit[i], resultErr)
+		}
+	}
+	//the nickname weights more than the names
+	usersExpected := []User{usersInit[0], usersInit[2], usersInit[1]}
+	filter := UserFilter{}.Where("country", OpEq, "SearchCountry").Matching("XRAY")
+
+	resultPage, resultErr := testUsersStore.List(filter, ListOptions{Sort: RelevanceSort, WithScore: true})
+	if resultErr != nil {
+		t.Fatalf("usersStore.List searching %v output err %v not expected", filter.Search, resultErr.Error())
+	}
+	if len(resultPage.Users) != len(usersExpected) || resultPage.Total != len(usersExpected) {
+		t.Fatalf("usersStore.List searching %v output %v", filter.Search, resultPage.Users)
+	}
+	for i, user := range resultPage.Users {
+		if user.ID != usersExpected[i].ID || user.Score <= 0 || (i > 0 && user.Score > resultPage.Users[i-1].Score) {
+			t.Errorf("usersStore.List searching %v output %v at %v but expected %v", filter.Search, user, i, usersExpected[i])
+		}
+	}
+
+	//the score is only returned when asked, the pages have no cursor
+	resultPage, resultErr = testUsersStore.List(filter, ListOptions{Sort: RelevanceSort, PageSize: 2})
+	if resultErr != nil {
+		t.Fatalf("usersStore.List searching %v output err %v not expected", filter.Search, resultErr.Error())
+	}
+	if len(resultPage.Users) != 2 || resultPage.Users[0].Score != 0 || !resultPage.HasNext || resultPage.NextCursor != "" {
+		t.Errorf("usersStore.List searching %v by page output %+v", filter.Search, resultPage)
+	}
+
+	//the score needs a search and no cursor
+	_, resultErr = testUsersStore.List(UserFilter{}, ListOptions{Sort: RelevanceSort})
+	if resultErr == nil {
+		t.Errorf("usersStore.List sorted by score without search output no err but one was expected")
+	}
+	_, resultErr = testUsersStore.List(filter, ListOptions{Sort: RelevanceSort, Cursor: NewCursor(&usersInit[0], DefaultSort)})
+	if resultErr == nil {
+		t.Errorf("usersStore.List sorted by score with a cursor output no err but one was expected")
+	}
+
+	//delete to clean
+	for _, user := range usersInit {
+		resultErr := deleteForGood(user.ID)
+		if resultErr != nil {
+			t.Errorf("Failled to delete list search user with err %v", resultErr)
+		}
+	}
+}
+
+func TestStoreListFuzzy(t *testing.T) {
+	usersInit := []User{
+		{FirstName: "John", LastName: "Smith", Nickname: "jsmith"},
+		{FirstName: "Jon", LastName: "Smyth", Nickname: "jsmyth"},
+		{FirstName: "Johanna", LastName: "Lee", Nickname: "jlee"},
+		{FirstName: "Alice", LastName: "Brown", Nickname: "abrown"},
+	}
+	for i := range usersInit {
+		usersInit[i].Password = "Password"
+		usersInit[i].Email = usersInit[i].Nickname + "@fuzzy.com"
+		usersInit[i].Country = "FuzzyCountry"
+		resultErr := testUsersStore.Create("", &usersInit[i])
+		if resultErr != nil {
+			t.Errorf("Create user failled for list fuzzy test of item %v with err %v", usersInit[i], resultErr)
+		}
+	}
+	filter := UserFilter{}.Where("country", OpEq, "FuzzyCountry")
+
+	listFuzzyTests := []struct {
+		value         string
+		opts          ListOptions
+		expectedUsers []User
+	}{
+		//the most similar first, the full name is compared too
+		{value: "jonh smith", opts: ListOptions{Sort: RelevanceSort}, expectedUsers: []User{usersInit[0], usersInit[1]}},
+		{value: "Johana", opts: ListOptions{Sort: RelevanceSort}, expectedUsers: []User{usersInit[2], usersInit[0]}},
+		{value: "abrwn", opts: ListOptions{Sort: RelevanceSort}, expectedUsers: []User{usersInit[3]}},
+		//the similar Users in another order
+		{value: "Johana", opts: ListOptions{Sort: Sort{{Field: "last_name", Desc: true}}}, expectedUsers: []User{usersInit[0], usersInit[2]}},
+		{value: "Robert", opts: ListOptions{Sort: RelevanceSort}, expectedUsers: []User{}},
+		//the value is never read as a field path nor a variable
+		{value: "$$first_name $last_name", opts: ListOptions{Sort: RelevanceSort}, expectedUsers: []User{}},
+	}
+	for _, item := range listFuzzyTests {
+		resultPage, resultErr := testUsersStore.List(filter.Resembling(item.value), item.opts)
+		if resultErr != nil {
+			t.Errorf("usersStore.List fuzzy %v output err %v not expected", item.value, resultErr.Error())
+			continue
+		}
+		if len(resultPage.Users) != len(item.expectedUsers) || resultPage.Total != len(item.expectedUsers) {
+			t.Errorf("usersStore.List fuzzy %v output %v but expected %v", item.value, resultPage.Users, item.expectedUsers)
+			continue
+		}
+		for i, user := range resultPage.Users {
+			if user.ID != item.expectedUsers[i].ID || user.Score != 0 {
+				t.Errorf("usersStore.List fuzzy %v output %v at %v but expected %v", item.value, user, i, item.expectedUsers[i])
+			}
+		}
+	}
+
+	//the threshold and the limit are set on the store
+	var fuzzy *FuzzyOptions
+	switch store := testUsersStore.(type) {
+	case *UsersStore:
+		fuzzy = &store.Fuzzy
+	case *UsersMemoryStore:
+		fuzzy = &store.Fuzzy
+	}
+	for _, options := range []FuzzyOptions{{Threshold: 0.85}, {Threshold: 0.6, Limit: 1}} {
+		*fuzzy = options
+		resultPage, resultErr := testUsersStore.List(filter.Resembling("jonh smith"), ListOptions{Sort: RelevanceSort, WithScore: true})
+		*fuzzy = DefaultFuzzy
+		if resultErr != nil {
+			t.Fatalf("usersStore.List fuzzy with %+v output err %v not expected", options, resultErr.Error())
+		}
+		if len(resultPage.Users) != 1 || resultPage.Users[0].ID != usersInit[0].ID || resultPage.Users[0].Score != 0.9 {
+			t.Errorf("usersStore.List fuzzy with %+v output %v", options, resultPage.Users)
+		}
+	}
+
+	//a fuzzy lookup doesn't rank with a search
+	_, resultErr := testUsersStore.List(filter.Resembling("jonh smith").Matching("John"), ListOptions{})
+	if resultErr == nil {
+		t.Errorf("usersStore.List fuzzy with a search output no err but one was expected")
+	}
+
+	//delete to clean
+	for _, user := range usersInit {
+		resultErr := deleteForGood(user.ID)
+		if resultErr != nil {
+			t.Errorf("Failled to delete list fuzzy user with err %v", resultErr)
+		}
+	}
+}
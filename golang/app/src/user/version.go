@@ -0,0 +1,36 @@
+package user
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	errors2 "test/errors"
+)
+
+//Implements the optimistic concurrency of the User changes, through their version
+
+// AnyVersion skips the version check of a change.
+const AnyVersion int64 = -1
+
+var ErrVersionMismatch = errors2.PreconditionFailed(errors.New("user modified since this version"))
+
+// ETag returns the entity tag of a User version, like "3".
+func ETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// MatchIfMatch returns the version a change is made on from the If-Match header and the current version of the User.
+// Without the header, or with "*", the change is made on AnyVersion; otherwise one of its entity tags has to be
+// the current one, weak tags never match.
+func MatchIfMatch(header string, current int64) (int64, error) {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return AnyVersion, nil
+	}
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimSpace(tag) == ETag(current) {
+			return current, nil
+		}
+	}
+	return 0, ErrVersionMismatch
+}
@@ -0,0 +1,24 @@
+package user
+
+import "testing"
+
+func TestMatchIfMatch(t *testing.T) {
+	for _, item := range []struct {
+		header   string
+		expected int64
+		err      error
+	}{
+		{"", AnyVersion, nil},
+		{"*", AnyVersion, nil},
+		{`"3"`, 3, nil},
+		{` "1", "3" `, 3, nil},
+		{`"2"`, 0, ErrVersionMismatch},
+		{`W/"3"`, 0, ErrVersionMismatch},
+		{"3", 0, ErrVersionMismatch},
+	} {
+		version, err := MatchIfMatch(item.header, 3)
+		if version != item.expected || err != item.err {
+			t.Errorf("MatchIfMatch of %q output %v with err %v but expected %v with err %v", item.header, version, err, item.expected, item.err)
+		}
+	}
+}
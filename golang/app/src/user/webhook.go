@@ -0,0 +1,207 @@
+package user
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	errors2 "test/errors"
+	"time"
+)
+
+//Implements the webhooks: the Events are POSTed to the URLs subscribed to their type
+
+// Headers of a webhook delivery request.
+const (
+	HeaderWebhookEventID    = "X-Webhook-Event-Id"
+	HeaderWebhookEventType  = "X-Webhook-Event-Type"
+	HeaderWebhookDeliveryID = "X-Webhook-Delivery-Id"
+	HeaderWebhookTimestamp  = "X-Webhook-Timestamp" //unix seconds, signed with the body
+	HeaderWebhookSignature  = "X-Webhook-Signature" //see SignWebhook
+)
+
+//webhookSecretLength is the number of random bytes of a generated secret
+const webhookSecretLength = 32
+
+var eventTypes = []EventType{EventUserCreated, EventUserUpdated, EventUserDeleted}
+
+// WebhookSubscription registers a URL to receive the Events of some types, signed with its secret.
+type WebhookSubscription struct {
+	ID         string      `bson:"_id,omitempty" json:"id"`
+	URL        string      `bson:"url" json:"url"`
+	EventTypes []EventType `bson:"event_types" json:"event_types"`
+	Secret     string      `bson:"secret" json:"secret,omitempty"` //only returned by the creation
+	CreatedAt  time.Time   `bson:"created_at" json:"created_at"`
+	UpdatedAt  time.Time   `bson:"updated_at" json:"updated_at"`
+}
+
+// Validate validates the subscription fields and returns all their errors as errors.FieldErrors.
+// The URL must be absolute http or https, the event types known.
+func (s *WebhookSubscription) Validate() error {
+	fieldErrors := errors2.FieldErrors{}
+	if s.URL == "" {
+		fieldErrors["url"] = ErrMessageRequired
+	} else if u, err := url.Parse(s.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		fieldErrors["url"] = "must be an absolute http or https URL"
+	}
+	if len(s.EventTypes) == 0 {
+		fieldErrors["event_types"] = ErrMessageRequired
+	}
+	for _, eventType := range s.EventTypes {
+		if !knownEventType(eventType) {
+			fieldErrors["event_types"] = "unknown event type " + string(eventType)
+		}
+	}
+	if len(fieldErrors) > 0 {
+		return errors2.Validation(fieldErrors)
+	}
+	return nil
+}
+
+func knownEventType(eventType EventType) bool {
+	for _, known := range eventTypes {
+		if eventType == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Accepts reports if the subscription receives the Events of the type.
+func (s *WebhookSubscription) Accepts(eventType EventType) bool {
+	for _, subscribed := range s.EventTypes {
+		if subscribed == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+//newWebhookSecret returns a random secret, hex encoded
+func newWebhookSecret() (string, error) {
+	secret := make([]byte, webhookSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// SignWebhook returns the signature of the body sent at the timestamp (unix seconds) with the secret:
+// "sha256=" followed by the hex HMAC-SHA256 of "<timestamp>.<body>".
+// The timestamp is signed so a receiver can reject the old requests replayed.
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook reports if the signature is the one of the body sent at the timestamp with the secret,
+// in constant time.
+func VerifyWebhook(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(SignWebhook(secret, timestamp, body)), []byte(signature))
+}
+
+// DeliveryStatus is the state of a WebhookDelivery.
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"   //waiting for its next attempt
+	DeliverySucceeded DeliveryStatus = "succeeded" //acknowledged with a 2xx response
+	DeliveryFailed    DeliveryStatus = "failed"    //given up after too many failed attempts
+)
+
+// WebhookDelivery is the delivery of an Event to a WebhookSubscription, kept in its delivery log.
+type WebhookDelivery struct {
+	ID             string         `bson:"_id,omitempty" json:"id"`
+	SubscriptionID string         `bson:"subscription_id" json:"subscription_id"`
+	EventID        string         `bson:"event_id" json:"event_id"`
+	EventType      EventType      `bson:"event_type" json:"event_type"`
+	Payload        string         `bson:"payload" json:"payload"` //the JSON Event, as POSTed
+	RedeliveryOf   string         `bson:"redelivery_of,omitempty" json:"redelivery_of,omitempty"`
+	Status         DeliveryStatus `bson:"status" json:"status"`
+	Attempts       int            `bson:"attempts" json:"attempts"`
+	NextAttempt    time.Time      `bson:"next_attempt" json:"next_attempt"`
+	ResponseStatus int            `bson:"response_status,omitempty" json:"response_status,omitempty"` //of the last attempt
+	LastError      string         `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	CreatedAt      time.Time      `bson:"created_at" json:"created_at"`
+	DeliveredAt    *time.Time     `bson:"delivered_at,omitempty" json:"delivered_at,omitempty"`
+	//Key identifies the first delivery of an Event to a subscription, so an Event published twice is delivered once.
+	//A redelivery has none.
+	Key string `bson:"key,omitempty" json:"-"`
+}
+
+// NewWebhookDelivery returns the pending delivery of the Event, with its JSON payload, to the subscription.
+func NewWebhookDelivery(subscriptionID string, meta EventMeta, payload []byte) *WebhookDelivery {
+	now := time.Now().Truncate(time.Millisecond)
+	return &WebhookDelivery{
+		SubscriptionID: subscriptionID,
+		EventID:        meta.ID,
+		EventType:      meta.Type,
+		Payload:        string(payload),
+		Status:         DeliveryPending,
+		NextAttempt:    now,
+		CreatedAt:      now,
+		Key:            subscriptionID + ":" + meta.ID,
+	}
+}
+
+// Redelivery returns a new pending delivery of the same payload.
+func (d *WebhookDelivery) Redelivery() *WebhookDelivery {
+	now := time.Now().Truncate(time.Millisecond)
+	return &WebhookDelivery{
+		SubscriptionID: d.SubscriptionID,
+		EventID:        d.EventID,
+		EventType:      d.EventType,
+		Payload:        d.Payload,
+		RedeliveryOf:   d.ID,
+		Status:         DeliveryPending,
+		NextAttempt:    now,
+		CreatedAt:      now,
+	}
+}
+
+// DeliveryPage is a page of the delivery log of a subscription, the most recent first.
+type DeliveryPage struct {
+	Deliveries []WebhookDelivery
+	Total      int  //number of deliveries of the subscription, whatever the page
+	HasNext    bool //more deliveries follow this page
+}
+
+//newDeliveryPage trims the deliveries fetched with one extra to know if a next page exists
+func newDeliveryPage(deliveries []WebhookDelivery, total int, page, pageSize int64) *DeliveryPage {
+	deliveryPage := &DeliveryPage{Deliveries: deliveries, Total: total}
+	if pageSize > 0 && int64(len(deliveries)) > pageSize {
+		deliveryPage.Deliveries = deliveries[:pageSize]
+		deliveryPage.HasNext = true
+	}
+	return deliveryPage
+}
+
+// WebhookStore keeps the webhook subscriptions and their delivery logs.
+// The ids are ObjectIDs: an invalid one is ErrInvalidID, an unknown one mongo.ErrNoDocuments.
+type WebhookStore interface {
+	//CreateSubscription saves the subscription, its id and dates are set
+	CreateSubscription(s *WebhookSubscription) error
+	GetSubscription(id string) (*WebhookSubscription, error)
+	//ListSubscriptions returns every subscription, the oldest first
+	ListSubscriptions() ([]WebhookSubscription, error)
+	//UpdateSubscription replaces the URL, event types and secret of the subscription, s is set to the saved one
+	UpdateSubscription(id string, s *WebhookSubscription) error
+	//DeleteSubscription removes the subscription and its delivery log
+	DeleteSubscription(id string) error
+
+	//AddDelivery saves the delivery and sets its id, or reports false when its Key is already saved
+	AddDelivery(d *WebhookDelivery) (bool, error)
+	GetDelivery(subscriptionID, id string) (*WebhookDelivery, error)
+	//ListDeliveries returns the page of the delivery log of the subscription, the most recent first.
+	//A pageSize of 0 means no limit, the pages start at 0
+	ListDeliveries(subscriptionID string, page, pageSize int64) (*DeliveryPage, error)
+	//DueDeliveries returns at most limit pending deliveries to attempt at the time, the oldest first
+	DueDeliveries(now time.Time, limit int) ([]WebhookDelivery, error)
+	//SaveAttempt saves the status, attempts and result of the last attempt of the delivery.
+	//A delivery removed with its subscription is ignored
+	SaveAttempt(d *WebhookDelivery) error
+}
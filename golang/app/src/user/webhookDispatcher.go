@@ -0,0 +1,291 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+//Implements the dispatcher of the Events to the webhook subscriptions
+
+const (
+	DefaultWebhookInterval    = time.Second
+	DefaultWebhookBatchSize   = 100
+	DefaultWebhookMaxAttempts = 8
+	DefaultWebhookBackoff     = 10 * time.Second
+	DefaultWebhookMaxBackoff  = time.Hour
+	DefaultWebhookTimeout     = 10 * time.Second
+	DefaultWebhookWorkers     = 10
+)
+
+//webhookResponseLimit is the part of a response read, so the connection can be reused
+const webhookResponseLimit = 64 << 10
+
+// ErrWebhookAddress is the error of a delivery to an address which is not public.
+var ErrWebhookAddress = errors.New("webhook address is not public")
+
+//nonPublicNetworks are the private, shared and reserved networks not covered by the net.IP methods
+var nonPublicNetworks = parseNetworks("0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "172.16.0.0/12", "192.168.0.0/16",
+	"198.18.0.0/15", "240.0.0.0/4", "fc00::/7")
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, networks[i], _ = net.ParseCIDR(cidr)
+	}
+	return networks
+}
+
+//isPublicIP reports if the ip is reachable on the internet: neither loopback, private, link-local, multicast nor reserved
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// NewWebhookClient returns the client of the deliveries, timing out after the timeout. It never follows a redirect,
+// answered as a failed attempt, and only connects to public addresses: they are checked once resolved, so a receiver
+// can't point the dispatcher to an internal service by its name.
+func NewWebhookClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return ErrWebhookAddress
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	//a proxy would be dialed instead of the receiver
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// WebhookDispatcher is the Publisher queuing a delivery of each Event to the subscriptions to its type.
+// The deliveries are POSTed every Interval, a failed one is retried after a backoff doubled at each attempt,
+// and failed after MaxAttempts. A 2xx response acknowledges a delivery, a receiver recognizes a duplicate by the Event id.
+// The subscriptions are delivered concurrently by up to Workers, so a slow receiver only delays its own deliveries,
+// the deliveries of a subscription are attempted in order.
+type WebhookDispatcher struct {
+	Store       WebhookStore
+	Client      *http.Client  //NewWebhookClient, only reaching the public addresses
+	Interval    time.Duration //time between two checks of the deliveries due
+	BatchSize   int           //deliveries read at once from the store
+	MaxAttempts int           //failed attempts before a delivery is failed
+	Backoff     time.Duration //delay before the first retry
+	MaxBackoff  time.Duration //longest delay between two retries
+	Workers     int           //subscriptions delivered at once, 1 when lower
+}
+
+// NewWebhookDispatcher returns a WebhookDispatcher of the subscriptions of the store, with the default settings.
+func NewWebhookDispatcher(store WebhookStore) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		Store:       store,
+		Client:      NewWebhookClient(DefaultWebhookTimeout),
+		Interval:    DefaultWebhookInterval,
+		BatchSize:   DefaultWebhookBatchSize,
+		MaxAttempts: DefaultWebhookMaxAttempts,
+		Backoff:     DefaultWebhookBackoff,
+		MaxBackoff:  DefaultWebhookMaxBackoff,
+		Workers:     DefaultWebhookWorkers,
+	}
+}
+
+// Publish queues a delivery of the Event to each subscription to its type.
+// An Event published again is not queued twice to the same subscription.
+func (d *WebhookDispatcher) Publish(e Event) error {
+	subscriptions, err := d.Store.ListSubscriptions()
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	for _, subscription := range subscriptions {
+		if !subscription.Accepts(e.Meta().Type) {
+			continue
+		}
+		if _, err := d.Store.AddDelivery(NewWebhookDelivery(subscription.ID, e.Meta(), payload)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeliverOnce attempts the deliveries due at the time, until none is left, and returns how many succeeded.
+func (d *WebhookDispatcher) DeliverOnce(now time.Time) (int, error) {
+	succeeded := 0
+	for {
+		deliveries, err := d.Store.DueDeliveries(now, d.BatchSize)
+		if err != nil {
+			return succeeded, err
+		}
+		batchSucceeded, err := d.deliverBatch(deliveries, now)
+		succeeded += batchSucceeded
+		if err != nil {
+			return succeeded, err
+		}
+		if d.BatchSize <= 0 || len(deliveries) < d.BatchSize {
+			return succeeded, nil
+		}
+	}
+}
+
+//deliverBatch attempts the deliveries by subscription, up to Workers subscriptions at once, and returns how many succeeded.
+//The attempts of a subscription stop at the first which can't be saved, its error is returned once all are done
+func (d *WebhookDispatcher) deliverBatch(deliveries []WebhookDelivery, now time.Time) (int, error) {
+	var subscriptionIDs []string
+	bySubscription := map[string][]*WebhookDelivery{}
+	for i := range deliveries {
+		id := deliveries[i].SubscriptionID
+		if _, ok := bySubscription[id]; !ok {
+			subscriptionIDs = append(subscriptionIDs, id)
+		}
+		bySubscription[id] = append(bySubscription[id], &deliveries[i])
+	}
+
+	workers := d.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	slots := make(chan bool, workers)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded := 0
+	var firstErr error
+	for _, id := range subscriptionIDs {
+		slots <- true
+		wg.Add(1)
+		go func(subscriptionDeliveries []*WebhookDelivery) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			for _, delivery := range subscriptionDeliveries {
+				d.attempt(delivery, now)
+				err := d.Store.SaveAttempt(delivery)
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				if err == nil && delivery.Status == DeliverySucceeded {
+					succeeded++
+				}
+				mu.Unlock()
+				if err != nil {
+					return
+				}
+			}
+		}(bySubscription[id])
+	}
+	wg.Wait()
+	return succeeded, firstErr
+}
+
+//attempt POSTs the delivery to its subscription and sets the result
+func (d *WebhookDispatcher) attempt(delivery *WebhookDelivery, now time.Time) {
+	delivery.Attempts++
+	subscription, err := d.Store.GetSubscription(delivery.SubscriptionID)
+	if err != nil {
+		//deleted meanwhile, or the store is down: retried like a failed request
+		d.fail(delivery, 0, err, now)
+		return
+	}
+	statusCode, err := d.post(subscription, delivery, now)
+	if err != nil {
+		d.fail(delivery, statusCode, err, now)
+		return
+	}
+	deliveredAt := now
+	delivery.Status = DeliverySucceeded
+	delivery.ResponseStatus = statusCode
+	delivery.LastError = ""
+	delivery.DeliveredAt = &deliveredAt
+}
+
+//fail records a failed attempt, the delivery is retried after a backoff or failed
+func (d *WebhookDispatcher) fail(delivery *WebhookDelivery, statusCode int, cause error, now time.Time) {
+	delivery.ResponseStatus = statusCode
+	delivery.LastError = cause.Error()
+	delivery.NextAttempt = now.Add(backoffDelay(d.Backoff, d.MaxBackoff, delivery.Attempts))
+	if delivery.Attempts >= d.MaxAttempts {
+		log.Println("webhook delivery", delivery.ID, "given up after", delivery.Attempts, "attempts:", cause)
+		delivery.Status = DeliveryFailed
+	}
+}
+
+//post sends the signed payload of the delivery to the subscription URL, and returns the response status code
+func (d *WebhookDispatcher) post(subscription *WebhookSubscription, delivery *WebhookDelivery, now time.Time) (int, error) {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequest(http.MethodPost, subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := now.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderWebhookEventID, delivery.EventID)
+	req.Header.Set(HeaderWebhookEventType, string(delivery.EventType))
+	req.Header.Set(HeaderWebhookDeliveryID, delivery.ID)
+	req.Header.Set(HeaderWebhookTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderWebhookSignature, SignWebhook(subscription.Secret, timestamp, body))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, webhookResponseLimit))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, &webhookStatusError{statusCode: resp.StatusCode}
+	}
+	return resp.StatusCode, nil
+}
+
+//webhookStatusError is a response of a receiver which is not a 2xx
+type webhookStatusError struct {
+	statusCode int
+}
+
+func (e *webhookStatusError) Error() string {
+	return "webhook receiver responded " + strconv.Itoa(e.statusCode) + " " + http.StatusText(e.statusCode)
+}
+
+// Run delivers at once then every Interval, until the context is done. The errors are logged, the next check retries.
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	runEvery(ctx, d.Interval, func(now time.Time) {
+		if _, err := d.DeliverOnce(now); err != nil {
+			log.Println("delivery of the webhooks failed:", err)
+		}
+	})
+}
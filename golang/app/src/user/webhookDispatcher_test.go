@@ -0,0 +1,256 @@
+package user
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//webhookReceiver records the deliveries with a valid signature, and responds status
+type webhookReceiver struct {
+	mu       sync.Mutex
+	secret   string
+	status   int
+	received []*http.Request
+	bodies   [][]byte
+	invalid  int //requests with a wrong signature
+}
+
+func (rc *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	body, _ := ioutil.ReadAll(r.Body)
+	timestamp, _ := strconv.ParseInt(r.Header.Get(HeaderWebhookTimestamp), 10, 64)
+	if !VerifyWebhook(rc.secret, timestamp, body, r.Header.Get(HeaderWebhookSignature)) {
+		rc.invalid++
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	rc.received = append(rc.received, r)
+	rc.bodies = append(rc.bodies, body)
+	w.WriteHeader(rc.status)
+}
+
+func TestWebhookDispatcher(t *testing.T) {
+	receiver := &webhookReceiver{secret: "secret", status: http.StatusNoContent}
+	receiverServer := httptest.NewServer(receiver)
+	defer receiverServer.Close()
+
+	store := newTestWebhookStore(t)
+	subscription := &WebhookSubscription{URL: receiverServer.URL, EventTypes: []EventType{EventUserCreated}, Secret: "secret"}
+	if err := store.CreateSubscription(subscription); err != nil {
+		t.Fatalf("WebhookStore.CreateSubscription output err %v", err)
+	}
+	dispatcher := NewWebhookDispatcher(store)
+	//the test receiver listens on the loopback
+	dispatcher.Client = receiverServer.Client()
+
+	//only the subscribed types are delivered, once even when published twice
+	created := NewUserCreated(&User{ID: "61e41ed578752c5997718aff", Nickname: "Nickname", Password: "hash"})
+	for _, e := range []Event{created, created, NewUserDeleted(&User{ID: "61e41ed578752c5997718aff"})} {
+		if err := dispatcher.Publish(e); err != nil {
+			t.Fatalf("WebhookDispatcher.Publish output err %v", err)
+		}
+	}
+	now := time.Now()
+	if succeeded, err := dispatcher.DeliverOnce(now); err != nil || succeeded != 1 {
+		t.Fatalf("WebhookDispatcher.DeliverOnce output %v with err %v", succeeded, err)
+	}
+	if len(receiver.received) != 1 || receiver.invalid != 0 {
+		t.Fatalf("webhook receiver got %v requests and %v invalid", len(receiver.received), receiver.invalid)
+	}
+	req := receiver.received[0]
+	if req.Method != http.MethodPost || req.Header.Get("Content-Type") != "application/json" ||
+		req.Header.Get(HeaderWebhookEventID) != created.ID || req.Header.Get(HeaderWebhookEventType) != string(EventUserCreated) {
+		t.Errorf("webhook receiver got the request %v %v", req.Method, req.Header)
+	}
+	var body UserCreated
+	if err := json.Unmarshal(receiver.bodies[0], &body); err != nil || body.ID != created.ID || body.User.Nickname != "Nickname" || body.User.Password != "" {
+		t.Errorf("webhook receiver got the body %v with err %v", string(receiver.bodies[0]), err)
+	}
+
+	deliveryPage, err := store.ListDeliveries(subscription.ID, 0, 0)
+	if err != nil || len(deliveryPage.Deliveries) != 1 {
+		t.Fatalf("WebhookStore.ListDeliveries output %+v with err %v", deliveryPage, err)
+	}
+	delivery := deliveryPage.Deliveries[0]
+	if delivery.Status != DeliverySucceeded || delivery.Attempts != 1 || delivery.ResponseStatus != http.StatusNoContent ||
+		delivery.DeliveredAt == nil || req.Header.Get(HeaderWebhookDeliveryID) != delivery.ID {
+		t.Errorf("webhook delivery saved as %+v", delivery)
+	}
+
+	//a redelivery sends the same payload again
+	redelivery := delivery.Redelivery()
+	if _, err := store.AddDelivery(redelivery); err != nil {
+		t.Fatalf("WebhookStore.AddDelivery of the redelivery output err %v", err)
+	}
+	if succeeded, err := dispatcher.DeliverOnce(time.Now()); err != nil || succeeded != 1 {
+		t.Errorf("WebhookDispatcher.DeliverOnce of the redelivery output %v with err %v", succeeded, err)
+	}
+	if len(receiver.bodies) != 2 || string(receiver.bodies[1]) != string(receiver.bodies[0]) ||
+		receiver.received[1].Header.Get(HeaderWebhookEventID) != created.ID {
+		t.Errorf("webhook receiver got %v requests for the redelivery", len(receiver.received))
+	}
+}
+
+func TestWebhookDispatcherSlowReceiver(t *testing.T) {
+	//the slow receiver answers once released, the fast one signals its deliveries
+	release := make(chan bool)
+	slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer slowServer.Close()
+	receiver := &webhookReceiver{secret: "secret", status: http.StatusNoContent}
+	fastDelivered := make(chan bool, 1)
+	fastServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receiver.ServeHTTP(w, r)
+		fastDelivered <- true
+	}))
+	defer fastServer.Close()
+
+	store := newTestWebhookStore(t)
+	for _, url := range []string{slowServer.URL, fastServer.URL} {
+		if err := store.CreateSubscription(&WebhookSubscription{URL: url, EventTypes: eventTypes, Secret: "secret"}); err != nil {
+			t.Fatalf("WebhookStore.CreateSubscription output err %v", err)
+		}
+	}
+	dispatcher := NewWebhookDispatcher(store)
+	dispatcher.Client = &http.Client{Timeout: DefaultWebhookTimeout}
+	if err := dispatcher.Publish(NewUserCreated(&User{ID: "61e41ed578752c5997718aff"})); err != nil {
+		t.Fatalf("WebhookDispatcher.Publish output err %v", err)
+	}
+
+	type deliverResult struct {
+		succeeded int
+		err       error
+	}
+	done := make(chan deliverResult)
+	go func() {
+		succeeded, err := dispatcher.DeliverOnce(time.Now())
+		done <- deliverResult{succeeded, err}
+	}()
+	//the fast receiver doesn't wait for the slow one
+	select {
+	case <-fastDelivered:
+	case <-time.After(time.Second):
+		t.Errorf("webhook fast receiver waited for the slow one")
+	}
+	close(release)
+	if result := <-done; result.err != nil || result.succeeded != 2 {
+		t.Errorf("WebhookDispatcher.DeliverOnce output %v with err %v", result.succeeded, result.err)
+	}
+}
+
+func TestWebhookDispatcherRetries(t *testing.T) {
+	receiver := &webhookReceiver{secret: "secret", status: http.StatusInternalServerError}
+	receiverServer := httptest.NewServer(receiver)
+	defer receiverServer.Close()
+
+	store := newTestWebhookStore(t)
+	subscription := &WebhookSubscription{URL: receiverServer.URL, EventTypes: eventTypes, Secret: "secret"}
+	if err := store.CreateSubscription(subscription); err != nil {
+		t.Fatalf("WebhookStore.CreateSubscription output err %v", err)
+	}
+	dispatcher := NewWebhookDispatcher(store)
+	dispatcher.Client = receiverServer.Client()
+	dispatcher.MaxAttempts = 3
+	dispatcher.Backoff = time.Second
+	if err := dispatcher.Publish(NewUserCreated(&User{ID: "61e41ed578752c5997718aff"})); err != nil {
+		t.Fatalf("WebhookDispatcher.Publish output err %v", err)
+	}
+	now := time.Now()
+
+	//every failure delays the next attempt twice more
+	for attempt, delay := range []time.Duration{0, time.Second, 2 * time.Second} {
+		now = now.Add(delay)
+		if succeeded, err := dispatcher.DeliverOnce(now); err != nil || succeeded != 0 {
+			t.Errorf("WebhookDispatcher.DeliverOnce attempt %v output %v with err %v", attempt, succeeded, err)
+		}
+		if len(receiver.received) != attempt+1 {
+			t.Errorf("webhook receiver got %v requests at the attempt %v", len(receiver.received), attempt)
+		}
+		dispatcher.DeliverOnce(now.Add(backoffDelay(dispatcher.Backoff, dispatcher.MaxBackoff, attempt+1) - time.Millisecond))
+		if len(receiver.received) != attempt+1 {
+			t.Errorf("webhook receiver got %v requests before the backoff of the attempt %v", len(receiver.received), attempt)
+		}
+	}
+
+	//given up after the attempts, the failed delivery is kept in the log
+	receiver.status = http.StatusOK
+	dispatcher.DeliverOnce(now.Add(time.Hour))
+	deliveryPage, err := store.ListDeliveries(subscription.ID, 0, 0)
+	if err != nil || len(deliveryPage.Deliveries) != 1 {
+		t.Fatalf("WebhookStore.ListDeliveries output %+v with err %v", deliveryPage, err)
+	}
+	delivery := deliveryPage.Deliveries[0]
+	if delivery.Status != DeliveryFailed || delivery.Attempts != 3 || delivery.ResponseStatus != http.StatusInternalServerError ||
+		delivery.LastError == "" || delivery.DeliveredAt != nil || len(receiver.received) != 3 {
+		t.Errorf("webhook delivery given up saved as %+v", delivery)
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	for _, item := range []struct {
+		ip       string
+		expected bool
+	}{
+		{"8.8.8.8", true},
+		{"2001:4860:4860::8888", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.20.0.1", false},
+		{"192.168.1.1", false},
+		{"100.64.0.1", false},
+		{"169.254.169.254", false},
+		{"0.0.0.0", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"::ffff:127.0.0.1", false},
+	} {
+		if result := isPublicIP(net.ParseIP(item.ip)); result != item.expected {
+			t.Errorf("isPublicIP of %v output %v but expected %v", item.ip, result, item.expected)
+		}
+	}
+}
+
+func TestWebhookClient(t *testing.T) {
+	receiver := &webhookReceiver{secret: "secret", status: http.StatusNoContent}
+	receiverServer := httptest.NewServer(receiver)
+	defer receiverServer.Close()
+
+	//the default client refuses the loopback receiver
+	store := newTestWebhookStore(t)
+	subscription := &WebhookSubscription{URL: receiverServer.URL, EventTypes: eventTypes, Secret: "secret"}
+	if err := store.CreateSubscription(subscription); err != nil {
+		t.Fatalf("WebhookStore.CreateSubscription output err %v", err)
+	}
+	dispatcher := NewWebhookDispatcher(store)
+	if err := dispatcher.Publish(NewUserCreated(&User{ID: "61e41ed578752c5997718aff"})); err != nil {
+		t.Fatalf("WebhookDispatcher.Publish output err %v", err)
+	}
+	if succeeded, err := dispatcher.DeliverOnce(time.Now()); err != nil || succeeded != 0 {
+		t.Fatalf("WebhookDispatcher.DeliverOnce output %v with err %v", succeeded, err)
+	}
+	deliveryPage, err := store.ListDeliveries(subscription.ID, 0, 0)
+	if err != nil || len(deliveryPage.Deliveries) != 1 ||
+		!strings.Contains(deliveryPage.Deliveries[0].LastError, ErrWebhookAddress.Error()) {
+		t.Errorf("webhook delivery to the loopback saved as %+v with err %v", deliveryPage, err)
+	}
+	if len(receiver.received) != 0 {
+		t.Errorf("webhook loopback receiver got %v requests", len(receiver.received))
+	}
+
+	//a redirect is the response of the attempt
+	if err := NewWebhookClient(time.Second).CheckRedirect(nil, nil); err != http.ErrUseLastResponse {
+		t.Errorf("webhook client CheckRedirect output %v but expected %v", err, http.ErrUseLastResponse)
+	}
+}
@@ -0,0 +1,218 @@
+package user
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"sync"
+	"time"
+)
+
+// WebhookMemoryStore implements the WebhookStore in memory.
+// It is safe for concurrent use.
+type WebhookMemoryStore struct {
+	mu            sync.RWMutex
+	subscriptions []WebhookSubscription //in the order they were created
+	deliveries    []WebhookDelivery     //in the order they were added
+	keys          map[string]bool       //of the deliveries added
+}
+
+// NewWebhookMemoryStore returns an empty WebhookMemoryStore
+func NewWebhookMemoryStore() *WebhookMemoryStore {
+	return &WebhookMemoryStore{keys: make(map[string]bool)}
+}
+
+//copySubscription returns a copy of the subscription not sharing its event types
+func copySubscription(s *WebhookSubscription) WebhookSubscription {
+	copied := *s
+	copied.EventTypes = append([]EventType{}, s.EventTypes...)
+	return copied
+}
+
+//subscriptionIndex returns the index of the subscription, or mongo.ErrNoDocuments, called with the lock held
+func (s *WebhookMemoryStore) subscriptionIndex(id string) (int, error) {
+	if _, err := ParseID(id); err != nil {
+		return 0, err
+	}
+	for i := range s.subscriptions {
+		if s.subscriptions[i].ID == id {
+			return i, nil
+		}
+	}
+	return 0, mongo.ErrNoDocuments
+}
+
+// CreateSubscription saves a copy of the subscription, its id and dates are set.
+func (s *WebhookMemoryStore) CreateSubscription(subscription *WebhookSubscription) error {
+	subscription.ID = primitive.NewObjectID().Hex()
+	subscription.CreatedAt = time.Now().Truncate(time.Millisecond)
+	subscription.UpdatedAt = subscription.CreatedAt
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscriptions = append(s.subscriptions, copySubscription(subscription))
+	return nil
+}
+
+// GetSubscription returns the subscription from its id, mongo.ErrNoDocuments if there is none.
+func (s *WebhookMemoryStore) GetSubscription(id string) (*WebhookSubscription, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i, err := s.subscriptionIndex(id)
+	if err != nil {
+		return nil, err
+	}
+	subscription := copySubscription(&s.subscriptions[i])
+	return &subscription, nil
+}
+
+// ListSubscriptions returns every subscription, the oldest first.
+func (s *WebhookMemoryStore) ListSubscriptions() ([]WebhookSubscription, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	subscriptions := make([]WebhookSubscription, 0, len(s.subscriptions))
+	for i := range s.subscriptions {
+		subscriptions = append(subscriptions, copySubscription(&s.subscriptions[i]))
+	}
+	return subscriptions, nil
+}
+
+// UpdateSubscription replaces the URL, event types and secret of the subscription, s is set to the saved one.
+func (s *WebhookMemoryStore) UpdateSubscription(id string, subscription *WebhookSubscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.subscriptionIndex(id)
+	if err != nil {
+		return err
+	}
+	saved := &s.subscriptions[i]
+	saved.URL = subscription.URL
+	saved.EventTypes = append([]EventType{}, subscription.EventTypes...)
+	saved.Secret = subscription.Secret
+	saved.UpdatedAt = time.Now().Truncate(time.Millisecond)
+	*subscription = copySubscription(saved)
+	return nil
+}
+
+// DeleteSubscription removes the subscription and its delivery log.
+func (s *WebhookMemoryStore) DeleteSubscription(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.subscriptionIndex(id)
+	if err != nil {
+		return err
+	}
+	s.subscriptions = append(s.subscriptions[:i], s.subscriptions[i+1:]...)
+
+	kept := s.deliveries[:0]
+	for _, delivery := range s.deliveries {
+		if delivery.SubscriptionID != id {
+			kept = append(kept, delivery)
+			continue
+		}
+		delete(s.keys, delivery.Key)
+	}
+	s.deliveries = kept
+	return nil
+}
+
+// AddDelivery saves a copy of the delivery and sets its id, or reports false when its Key is already saved.
+func (s *WebhookMemoryStore) AddDelivery(d *WebhookDelivery) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d.Key != "" {
+		if s.keys[d.Key] {
+			return false, nil
+		}
+		s.keys[d.Key] = true
+	}
+	d.ID = primitive.NewObjectID().Hex()
+	s.deliveries = append(s.deliveries, *d)
+	return true, nil
+}
+
+// GetDelivery returns the delivery of the subscription from its id, mongo.ErrNoDocuments if there is none.
+func (s *WebhookMemoryStore) GetDelivery(subscriptionID, id string) (*WebhookDelivery, error) {
+	for _, hex := range []string{subscriptionID, id} {
+		if _, err := ParseID(hex); err != nil {
+			return nil, err
+		}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, delivery := range s.deliveries {
+		if delivery.ID == id && delivery.SubscriptionID == subscriptionID {
+			return &delivery, nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+// ListDeliveries returns the page of the delivery log of the subscription, the most recent first.
+func (s *WebhookMemoryStore) ListDeliveries(subscriptionID string, page, pageSize int64) (*DeliveryPage, error) {
+	s.mu.RLock()
+	if _, err := s.subscriptionIndex(subscriptionID); err != nil {
+		s.mu.RUnlock()
+		return nil, err
+	}
+	var deliveries []WebhookDelivery
+	//the most recent were added last
+	for i := len(s.deliveries) - 1; i >= 0; i-- {
+		if s.deliveries[i].SubscriptionID == subscriptionID {
+			deliveries = append(deliveries, s.deliveries[i])
+		}
+	}
+	s.mu.RUnlock()
+
+	//the total ignores the pagination
+	total := len(deliveries)
+	if pageSize > 0 {
+		//rmq page start at 0
+		skip := pageSize * page
+		if skip >= int64(len(deliveries)) {
+			skip = int64(len(deliveries))
+		}
+		deliveries = deliveries[skip:]
+		//one more delivery tells if there is a next page
+		if int64(len(deliveries)) > pageSize+1 {
+			deliveries = deliveries[:pageSize+1]
+		}
+	}
+	return newDeliveryPage(deliveries, total, page, pageSize), nil
+}
+
+// DueDeliveries returns at most limit pending deliveries to attempt at the time, the oldest first.
+func (s *WebhookMemoryStore) DueDeliveries(now time.Time, limit int) ([]WebhookDelivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var due []WebhookDelivery
+	//the oldest were added first
+	for _, delivery := range s.deliveries {
+		if limit > 0 && len(due) >= limit {
+			break
+		}
+		if delivery.Status == DeliveryPending && !delivery.NextAttempt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+	return due, nil
+}
+
+// SaveAttempt saves the status, attempts and result of the last attempt of the delivery.
+// A delivery removed with its subscription is ignored.
+func (s *WebhookMemoryStore) SaveAttempt(d *WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.deliveries {
+		if s.deliveries[i].ID == d.ID {
+			saved := &s.deliveries[i]
+			saved.Status = d.Status
+			saved.Attempts = d.Attempts
+			saved.NextAttempt = d.NextAttempt
+			saved.ResponseStatus = d.ResponseStatus
+			saved.LastError = d.LastError
+			saved.DeliveredAt = d.DeliveredAt
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+package user
+
+import (
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"net/http"
+	"net/url"
+	"strconv"
+	errors2 "test/errors"
+	"test/utils"
+)
+
+//Implements the webhook subscriptions handler
+
+// WebhookResource implements the management of the webhook subscriptions and of their delivery logs.
+type WebhookResource struct {
+	Store  WebhookStore
+	Policy Policy
+}
+
+// NewWebhookResource creates and returns a webhook resource backed by any WebhookStore, the access is decided by the Policy.
+func NewWebhookResource(store WebhookStore, policy Policy) *WebhookResource {
+	return &WebhookResource{
+		Store:  store,
+		Policy: policy,
+	}
+}
+
+// Router for the webhook subscriptions
+func (rs *WebhookResource) Router() *chi.Mux {
+	r := chi.NewRouter()
+	r.Get("/", rs.list)
+	r.Post("/", rs.create)
+	r.Route("/{webhookID}", func(r chi.Router) {
+		r.Get("/", rs.get)
+		r.Put("/", rs.update)
+		r.Delete("/", rs.delete)
+		r.Get("/deliveries", rs.deliveries)
+		r.Post("/deliveries/{deliveryID}/redeliver", rs.redeliver)
+	})
+	return r
+}
+
+// Request expected
+type webhookRequest struct {
+	URL        string      `json:"url"`
+	EventTypes []EventType `json:"event_types"`
+	Secret     string      `json:"secret"` //generated at the creation when empty, kept by an update when empty
+}
+
+//Binding of the http request to the webhookRequest
+func (wr *webhookRequest) Bind(r *http.Request) error {
+	return nil
+}
+
+//Response model for one subscription
+type webhookResponse struct {
+	success bool
+	*WebhookSubscription
+}
+
+//Response model for every subscription
+type webhookListResponse struct {
+	success  bool
+	Webhooks []WebhookSubscription `json:"webhooks"`
+	Count    int                   `json:"count"`
+}
+
+//Response model for a page of the delivery log
+type deliveryListResponse struct {
+	success    bool
+	Deliveries []WebhookDelivery `json:"deliveries"`
+	Count      int               `json:"count"` //number of deliveries in the page
+	Total      int               `json:"total"` //number of deliveries of the subscription
+	Page       int64             `json:"page"`
+	PageSize   int64             `json:"page_size"`
+	HasNext    bool              `json:"has_next"`
+	Links      pageLinks         `json:"links"`
+}
+
+//newWebhookResponse returns the subscription, its secret only when shown
+func newWebhookResponse(s *WebhookSubscription, showSecret bool, success bool) *webhookResponse {
+	if !showSecret {
+		s.Secret = ""
+	}
+	return &webhookResponse{success: success, WebhookSubscription: s}
+}
+
+func newDeliveryListResponse(deliveryPage *DeliveryPage, page, pageSize int64, requestURL *url.URL, success bool) *deliveryListResponse {
+	//an empty page is an empty array
+	deliveries := deliveryPage.Deliveries
+	if deliveries == nil {
+		deliveries = []WebhookDelivery{}
+	}
+	resp := &deliveryListResponse{
+		success:    success,
+		Deliveries: deliveries,
+		Count:      len(deliveries),
+		Total:      deliveryPage.Total,
+		Page:       page,
+		PageSize:   pageSize,
+		HasNext:    deliveryPage.HasNext,
+	}
+	if resp.HasNext {
+		resp.Links.Next = pageURL(requestURL, "page", strconv.FormatInt(page+1, 10))
+	}
+	if pageSize > 0 && page > 0 {
+		resp.Links.Prev = pageURL(requestURL, "page", strconv.FormatInt(page-1, 10))
+	}
+	return resp
+}
+
+//authorize renders the refusal and reports false when the principal may not manage the webhooks
+func (rs *WebhookResource) authorize(w http.ResponseWriter, r *http.Request) bool {
+	principal, _ := PrincipalFromContext(r.Context())
+	if _, err := rs.Policy.Authorize(principal, ActionWebhooks); err != nil {
+		utils.RenderForbidden(w, r, err)
+		return false
+	}
+	return true
+}
+
+// Returns every subscription, without their secrets
+func (rs *WebhookResource) list(w http.ResponseWriter, r *http.Request) {
+	if !rs.authorize(w, r) {
+		return
+	}
+	subscriptions, err := rs.Store.ListSubscriptions()
+	if err != nil {
+		utils.Render(w, r, err)
+		return
+	}
+	for i := range subscriptions {
+		subscriptions[i].Secret = ""
+	}
+	render.Respond(w, r, &webhookListResponse{success: true, Webhooks: subscriptions, Count: len(subscriptions)})
+}
+
+// Registers a new subscription, the only response with its secret
+func (rs *WebhookResource) create(w http.ResponseWriter, r *http.Request) {
+	if !rs.authorize(w, r) {
+		return
+	}
+
+	//binds body request to the subscription
+	wR := &webhookRequest{}
+	if err := render.Bind(r, wR); err != nil {
+		utils.Render(w, r, errors2.BadRequest(err))
+		return
+	}
+	subscription := &WebhookSubscription{URL: wR.URL, EventTypes: wR.EventTypes, Secret: wR.Secret}
+	if err := subscription.Validate(); err != nil {
+		utils.Render(w, r, err)
+		return
+	}
+	if subscription.Secret == "" {
+		secret, err := newWebhookSecret()
+		if err != nil {
+			utils.Render(w, r, err)
+			return
+		}
+		subscription.Secret = secret
+	}
+	if err := rs.Store.CreateSubscription(subscription); err != nil {
+		utils.Render(w, r, err)
+		return
+	}
+	render.Respond(w, r, newWebhookResponse(subscription, true, true))
+}
+
+// Returns one subscription, without its secret
+func (rs *WebhookResource) get(w http.ResponseWriter, r *http.Request) {
+	if !rs.authorize(w, r) {
+		return
+	}
+	subscription, err := rs.Store.GetSubscription(chi.URLParam(r, "webhookID"))
+	if err != nil {
+		utils.Render(w, r, err)
+		return
+	}
+	render.Respond(w, r, newWebhookResponse(subscription, false, true))
+}
+
+// Replaces the URL and event types of a subscription, and its secret when given
+func (rs *WebhookResource) update(w http.ResponseWriter, r *http.Request) {
+	if !rs.authorize(w, r) {
+		return
+	}
+	id := chi.URLParam(r, "webhookID")
+
+	//binds body request to the subscription
+	wR := &webhookRequest{}
+	if err := render.Bind(r, wR); err != nil {
+		utils.Render(w, r, errors2.BadRequest(err))
+		return
+	}
+	subscription := &WebhookSubscription{URL: wR.URL, EventTypes: wR.EventTypes, Secret: wR.Secret}
+	if err := subscription.Validate(); err != nil {
+		utils.Render(w, r, err)
+		return
+	}
+	if subscription.Secret == "" {
+		current, err := rs.Store.GetSubscription(id)
+		if err != nil {
+			utils.Render(w, r, err)
+			return
+		}
+		subscription.Secret = current.Secret
+	}
+	if err := rs.Store.UpdateSubscription(id, subscription); err != nil {
+		utils.Render(w, r, err)
+		return
+	}
+	render.Respond(w, r, newWebhookResponse(subscription, false, true))
+}
+
+// Deletes a subscription with its delivery log
+func (rs *WebhookResource) delete(w http.ResponseWriter, r *http.Request) {
+	if !rs.authorize(w, r) {
+		return
+	}
+	id := chi.URLParam(r, "webhookID")
+	if err := rs.Store.DeleteSubscription(id); err != nil {
+		utils.Render(w, r, err)
+		return
+	}
+	render.Respond(w, r, newWebhookResponse(&WebhookSubscription{ID: id}, false, true))
+}
+
+// Returns the delivery log of a subscription, the most recent first
+func (rs *WebhookResource) deliveries(w http.ResponseWriter, r *http.Request) {
+	if !rs.authorize(w, r) {
+		return
+	}
+	page, err := utils.Int64FromQuery("page", r.URL.Query())
+	if err != nil {
+		utils.Render(w, r, errors2.BadRequest(err))
+		return
+	}
+	pageSize, err := utils.Int64FromQuery("page_size", r.URL.Query())
+	if err != nil {
+		utils.Render(w, r, errors2.BadRequest(err))
+		return
+	}
+	if page < 0 || pageSize < 0 {
+		utils.Render(w, r, ErrParamPage)
+		return
+	}
+	deliveryPage, err := rs.Store.ListDeliveries(chi.URLParam(r, "webhookID"), page, pageSize)
+	if err != nil {
+		utils.Render(w, r, err)
+		return
+	}
+	render.Respond(w, r, newDeliveryListResponse(deliveryPage, page, pageSize, r.URL, true))
+}
+
+// Queues a new delivery of the payload of a previous one, attempted by the WebhookDispatcher
+func (rs *WebhookResource) redeliver(w http.ResponseWriter, r *http.Request) {
+	if !rs.authorize(w, r) {
+		return
+	}
+	previous, err := rs.Store.GetDelivery(chi.URLParam(r, "webhookID"), chi.URLParam(r, "deliveryID"))
+	if err != nil {
+		utils.Render(w, r, err)
+		return
+	}
+	delivery := previous.Redelivery()
+	if _, err := rs.Store.AddDelivery(delivery); err != nil {
+		utils.Render(w, r, err)
+		return
+	}
+	render.Status(r, http.StatusAccepted)
+	render.Respond(w, r, delivery)
+}
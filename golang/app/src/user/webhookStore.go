@@ -0,0 +1,209 @@
+package user
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// WebhookMongoStore implements the WebhookStore on MongoDB, the subscriptions and the deliveries in their own collections.
+type WebhookMongoStore struct {
+	subscriptions *mongo.Collection
+	deliveries    *mongo.Collection
+	ctx           context.Context
+}
+
+// NewWebhookMongoStore returns a WebhookMongoStore, indexed for the delivery logs and the deliveries due.
+// The key of the deliveries is unique, a redelivery has none.
+func NewWebhookMongoStore(db *mongo.Database, ctx context.Context) (*WebhookMongoStore, error) {
+	deliveriesCollection := db.Collection("webhook_deliveries")
+	_, err := deliveriesCollection.Indexes().CreateMany(
+		context.Background(),
+		[]mongo.IndexModel{
+			{Keys: bson.D{{Key: "subscription_id", Value: 1}, {Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt", Value: 1}}},
+			{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WebhookMongoStore{
+		subscriptions: db.Collection("webhooks"),
+		deliveries:    deliveriesCollection,
+		ctx:           ctx,
+	}, nil
+}
+
+// CreateSubscription inserts the subscription, its id and dates are set.
+func (s *WebhookMongoStore) CreateSubscription(subscription *WebhookSubscription) error {
+	subscription.ID = ""
+	subscription.CreatedAt = time.Now().Truncate(time.Millisecond)
+	subscription.UpdatedAt = subscription.CreatedAt
+	insertResult, err := s.subscriptions.InsertOne(s.ctx, subscription)
+	if err != nil {
+		return err
+	}
+	return s.subscriptions.FindOne(s.ctx, bson.M{"_id": insertResult.InsertedID}).Decode(subscription)
+}
+
+// GetSubscription returns the subscription from its id, mongo.ErrNoDocuments if there is none.
+func (s *WebhookMongoStore) GetSubscription(id string) (*WebhookSubscription, error) {
+	primId, err := ParseID(id)
+	if err != nil {
+		return nil, err
+	}
+	subscription := &WebhookSubscription{}
+	if err := s.subscriptions.FindOne(s.ctx, bson.M{"_id": primId}).Decode(subscription); err != nil {
+		return nil, err
+	}
+	return subscription, nil
+}
+
+// ListSubscriptions returns every subscription, the oldest first.
+func (s *WebhookMongoStore) ListSubscriptions() ([]WebhookSubscription, error) {
+	findOpts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := s.subscriptions.Find(s.ctx, bson.M{}, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	subscriptions := []WebhookSubscription{}
+	if err := cursor.All(s.ctx, &subscriptions); err != nil {
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+// UpdateSubscription replaces the URL, event types and secret of the subscription, s is set to the saved one.
+func (s *WebhookMongoStore) UpdateSubscription(id string, subscription *WebhookSubscription) error {
+	primId, err := ParseID(id)
+	if err != nil {
+		return err
+	}
+	update := bson.M{"$set": bson.M{
+		"url":         subscription.URL,
+		"event_types": subscription.EventTypes,
+		"secret":      subscription.Secret,
+		"updated_at":  time.Now().Truncate(time.Millisecond),
+	}}
+	updateOpts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	return s.subscriptions.FindOneAndUpdate(s.ctx, bson.M{"_id": primId}, update, updateOpts).Decode(subscription)
+}
+
+// DeleteSubscription removes the subscription and its delivery log.
+func (s *WebhookMongoStore) DeleteSubscription(id string) error {
+	primId, err := ParseID(id)
+	if err != nil {
+		return err
+	}
+	deleteResult, err := s.subscriptions.DeleteOne(s.ctx, bson.M{"_id": primId})
+	if err != nil {
+		return err
+	}
+	if deleteResult.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	//a delivery in flight is given up by the WebhookDispatcher without its subscription
+	_, err = s.deliveries.DeleteMany(s.ctx, bson.M{"subscription_id": id})
+	return err
+}
+
+// AddDelivery inserts the delivery and sets its id, or reports false when its Key is already saved.
+func (s *WebhookMongoStore) AddDelivery(d *WebhookDelivery) (bool, error) {
+	d.ID = ""
+	insertResult, err := s.deliveries.InsertOne(s.ctx, d)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	d.ID = insertResult.InsertedID.(primitive.ObjectID).Hex()
+	return true, nil
+}
+
+// GetDelivery returns the delivery of the subscription from its id, mongo.ErrNoDocuments if there is none.
+func (s *WebhookMongoStore) GetDelivery(subscriptionID, id string) (*WebhookDelivery, error) {
+	if _, err := ParseID(subscriptionID); err != nil {
+		return nil, err
+	}
+	primId, err := ParseID(id)
+	if err != nil {
+		return nil, err
+	}
+	delivery := &WebhookDelivery{}
+	err = s.deliveries.FindOne(s.ctx, bson.M{"_id": primId, "subscription_id": subscriptionID}).Decode(delivery)
+	if err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// ListDeliveries returns the page of the delivery log of the subscription, the most recent first.
+func (s *WebhookMongoStore) ListDeliveries(subscriptionID string, page, pageSize int64) (*DeliveryPage, error) {
+	if _, err := s.GetSubscription(subscriptionID); err != nil {
+		return nil, err
+	}
+	filter := bson.M{"subscription_id": subscriptionID}
+
+	//the total ignores the pagination
+	total, err := s.deliveries.CountDocuments(s.ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	findOpts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}})
+	if pageSize > 0 {
+		//one more delivery tells if there is a next page, rmq page start at 0
+		findOpts.SetLimit(pageSize + 1).SetSkip(pageSize * page)
+	}
+	cursor, err := s.deliveries.Find(s.ctx, filter, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	var deliveries []WebhookDelivery
+	if err := cursor.All(s.ctx, &deliveries); err != nil {
+		return nil, err
+	}
+	return newDeliveryPage(deliveries, int(total), page, pageSize), nil
+}
+
+// DueDeliveries returns at most limit pending deliveries to attempt at the time, the oldest first.
+func (s *WebhookMongoStore) DueDeliveries(now time.Time, limit int) ([]WebhookDelivery, error) {
+	findOpts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+	if limit > 0 {
+		findOpts.SetLimit(int64(limit))
+	}
+	cursor, err := s.deliveries.Find(s.ctx, bson.M{"status": DeliveryPending, "next_attempt": bson.M{"$lte": now}}, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	var deliveries []WebhookDelivery
+	if err := cursor.All(s.ctx, &deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// SaveAttempt saves the status, attempts and result of the last attempt of the delivery.
+// A delivery removed with its subscription is ignored.
+func (s *WebhookMongoStore) SaveAttempt(d *WebhookDelivery) error {
+	primId, err := ParseID(d.ID)
+	if err != nil {
+		return err
+	}
+	update := bson.M{"$set": bson.M{
+		"status":          d.Status,
+		"attempts":        d.Attempts,
+		"next_attempt":    d.NextAttempt,
+		"response_status": d.ResponseStatus,
+		"last_error":      d.LastError,
+		"delivered_at":    d.DeliveredAt,
+	}}
+	_, err = s.deliveries.UpdateOne(s.ctx, bson.M{"_id": primId}, update)
+	return err
+}
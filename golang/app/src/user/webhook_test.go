@@ -0,0 +1,180 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	errors2 "test/errors"
+	"testing"
+	"time"
+)
+
+//newTestWebhookStore returns an empty WebhookStore of the test backend
+func newTestWebhookStore(t *testing.T) WebhookStore {
+	if testDb == nil {
+		return NewWebhookMemoryStore()
+	}
+	for _, name := range []string{"webhooks", "webhook_deliveries"} {
+		if err := testDb.Collection(name).Drop(context.TODO()); err != nil {
+			t.Fatalf("Drop of %v output err %v", name, err)
+		}
+	}
+	store, err := NewWebhookMongoStore(testDb, context.TODO())
+	if err != nil {
+		t.Fatalf("NewWebhookMongoStore output err %v", err)
+	}
+	return store
+}
+
+func TestSignWebhook(t *testing.T) {
+	body := []byte(`{"id":"61e41ed578752c5997718aff"}`)
+	//the HMAC-SHA256 of "1642340000.<body>" with the key "secret"
+	expected := "sha256=a5a6fa7e1330c8487db3bea4bfdd051ec072b1061991a2e19026d769f0c477d3"
+	signature := SignWebhook("secret", 1642340000, body)
+	if signature != expected {
+		t.Errorf("SignWebhook output %v but expected %v", signature, expected)
+	}
+
+	for _, item := range []struct {
+		secret    string
+		timestamp int64
+		body      []byte
+		signature string
+		expected  bool
+	}{
+		{secret: "secret", timestamp: 1642340000, body: body, signature: signature, expected: true},
+		{secret: "other", timestamp: 1642340000, body: body, signature: signature, expected: false},
+		{secret: "secret", timestamp: 1642340001, body: body, signature: signature, expected: false},
+		{secret: "secret", timestamp: 1642340000, body: []byte(`{"id":"61e41ed578752c5997718b00"}`), signature: signature, expected: false},
+		{secret: "secret", timestamp: 1642340000, body: body, signature: "", expected: false},
+	} {
+		if result := VerifyWebhook(item.secret, item.timestamp, item.body, item.signature); result != item.expected {
+			t.Errorf("VerifyWebhook of %v at %v with %v output %v but expected %v", string(item.body), item.timestamp, item.secret, result, item.expected)
+		}
+	}
+}
+
+func TestWebhookSubscriptionValidate(t *testing.T) {
+	for _, item := range []struct {
+		subscription   WebhookSubscription
+		expectedFields []string
+	}{
+		{subscription: WebhookSubscription{URL: "https://example.com/hook", EventTypes: []EventType{EventUserCreated}}},
+		{subscription: WebhookSubscription{URL: "http://localhost:8080/hook", EventTypes: eventTypes}},
+		{subscription: WebhookSubscription{}, expectedFields: []string{"url", "event_types"}},
+		{subscription: WebhookSubscription{URL: "ftp://example.com", EventTypes: []EventType{EventUserCreated}}, expectedFields: []string{"url"}},
+		{subscription: WebhookSubscription{URL: "/hook", EventTypes: []EventType{EventUserCreated}}, expectedFields: []string{"url"}},
+		{subscription: WebhookSubscription{URL: "https://example.com/hook", EventTypes: []EventType{"user.renamed"}}, expectedFields: []string{"event_types"}},
+	} {
+		err := item.subscription.Validate()
+		if len(item.expectedFields) == 0 {
+			if err != nil {
+				t.Errorf("WebhookSubscription.Validate of %+v output err %v not expected", item.subscription, err)
+			}
+			continue
+		}
+		var fieldErrors errors2.FieldErrors
+		if !errors.As(err, &fieldErrors) || len(fieldErrors) != len(item.expectedFields) {
+			t.Errorf("WebhookSubscription.Validate of %+v output err %v but expected errors on %v", item.subscription, err, item.expectedFields)
+			continue
+		}
+		for _, field := range item.expectedFields {
+			if _, ok := fieldErrors[field]; !ok {
+				t.Errorf("WebhookSubscription.Validate of %+v output no error on %v", item.subscription, field)
+			}
+		}
+	}
+}
+
+func TestWebhookStore(t *testing.T) {
+	store := newTestWebhookStore(t)
+
+	subscription := &WebhookSubscription{URL: "https://example.com/hook", EventTypes: []EventType{EventUserCreated}, Secret: "secret"}
+	if err := store.CreateSubscription(subscription); err != nil || subscription.ID == "" || subscription.CreatedAt.IsZero() {
+		t.Fatalf("WebhookStore.CreateSubscription output %+v with err %v", subscription, err)
+	}
+	other := &WebhookSubscription{URL: "https://example.com/other", EventTypes: eventTypes, Secret: "other"}
+	if err := store.CreateSubscription(other); err != nil {
+		t.Fatalf("WebhookStore.CreateSubscription output err %v", err)
+	}
+	if subscriptions, err := store.ListSubscriptions(); err != nil || len(subscriptions) != 2 || subscriptions[0].ID != subscription.ID {
+		t.Errorf("WebhookStore.ListSubscriptions output %+v with err %v", subscriptions, err)
+	}
+
+	update := &WebhookSubscription{URL: "https://example.com/updated", EventTypes: []EventType{EventUserDeleted}, Secret: "updated"}
+	if err := store.UpdateSubscription(subscription.ID, update); err != nil || update.ID != subscription.ID || !update.CreatedAt.Equal(subscription.CreatedAt) {
+		t.Errorf("WebhookStore.UpdateSubscription output %+v with err %v", update, err)
+	}
+	if got, err := store.GetSubscription(subscription.ID); err != nil || got.URL != update.URL || got.Secret != "updated" || !got.Accepts(EventUserDeleted) || got.Accepts(EventUserCreated) {
+		t.Errorf("WebhookStore.GetSubscription after the update output %+v with err %v", got, err)
+	}
+
+	//an Event is delivered once to each subscription, a redelivery is a new delivery
+	e := NewUserDeleted(&User{ID: "61e41ed578752c5997718aff"})
+	for i := 0; i < 2; i++ {
+		added, err := store.AddDelivery(NewWebhookDelivery(subscription.ID, e.Meta(), []byte(`{}`)))
+		if err != nil || added != (i == 0) {
+			t.Errorf("WebhookStore.AddDelivery %v of the event output %v with err %v", i, added, err)
+		}
+	}
+	delivery := NewWebhookDelivery(other.ID, e.Meta(), []byte(`{}`))
+	if added, err := store.AddDelivery(delivery); err != nil || !added || delivery.ID == "" {
+		t.Errorf("WebhookStore.AddDelivery to another subscription output %v with err %v", added, err)
+	}
+	redelivery := delivery.Redelivery()
+	if added, err := store.AddDelivery(redelivery); err != nil || !added || redelivery.ID == delivery.ID || redelivery.RedeliveryOf != delivery.ID {
+		t.Errorf("WebhookStore.AddDelivery of a redelivery output %+v with err %v", redelivery, err)
+	}
+
+	//the delivery log is paginated, the most recent first
+	deliveryPage, err := store.ListDeliveries(other.ID, 0, 1)
+	if err != nil || deliveryPage.Total != 2 || !deliveryPage.HasNext || len(deliveryPage.Deliveries) != 1 || deliveryPage.Deliveries[0].ID != redelivery.ID {
+		t.Errorf("WebhookStore.ListDeliveries page 0 output %+v with err %v", deliveryPage, err)
+	}
+	deliveryPage, err = store.ListDeliveries(other.ID, 1, 1)
+	if err != nil || deliveryPage.HasNext || len(deliveryPage.Deliveries) != 1 || deliveryPage.Deliveries[0].ID != delivery.ID {
+		t.Errorf("WebhookStore.ListDeliveries page 1 output %+v with err %v", deliveryPage, err)
+	}
+
+	//the attempts are saved, only the pending deliveries are due
+	now := time.Now()
+	if due, err := store.DueDeliveries(now, 0); err != nil || len(due) != 3 {
+		t.Errorf("WebhookStore.DueDeliveries output %+v with err %v", due, err)
+	}
+	delivery.Attempts, delivery.NextAttempt, delivery.ResponseStatus, delivery.LastError = 1, now.Add(time.Minute), 500, "down"
+	if err := store.SaveAttempt(delivery); err != nil {
+		t.Errorf("WebhookStore.SaveAttempt output err %v", err)
+	}
+	redelivery.Status, redelivery.Attempts = DeliverySucceeded, 1
+	if err := store.SaveAttempt(redelivery); err != nil {
+		t.Errorf("WebhookStore.SaveAttempt output err %v", err)
+	}
+	if due, err := store.DueDeliveries(now, 0); err != nil || len(due) != 1 || due[0].SubscriptionID != subscription.ID {
+		t.Errorf("WebhookStore.DueDeliveries after the attempts output %+v with err %v", due, err)
+	}
+	if got, err := store.GetDelivery(other.ID, delivery.ID); err != nil || got.Attempts != 1 || got.ResponseStatus != 500 || got.LastError != "down" {
+		t.Errorf("WebhookStore.GetDelivery output %+v with err %v", got, err)
+	}
+	if _, err := store.GetDelivery(subscription.ID, delivery.ID); err != mongo.ErrNoDocuments {
+		t.Errorf("WebhookStore.GetDelivery from another subscription output err %v", err)
+	}
+
+	//a deleted subscription takes its delivery log with it
+	if err := store.DeleteSubscription(other.ID); err != nil {
+		t.Errorf("WebhookStore.DeleteSubscription output err %v", err)
+	}
+	if _, err := store.GetSubscription(other.ID); err != mongo.ErrNoDocuments {
+		t.Errorf("WebhookStore.GetSubscription of a deleted subscription output err %v", err)
+	}
+	if _, err := store.GetDelivery(other.ID, delivery.ID); err != mongo.ErrNoDocuments {
+		t.Errorf("WebhookStore.GetDelivery of a deleted subscription output err %v", err)
+	}
+	if err := store.DeleteSubscription(other.ID); err != mongo.ErrNoDocuments {
+		t.Errorf("WebhookStore.DeleteSubscription twice output err %v", err)
+	}
+	for _, id := range []string{"invalid", "zzzzzzzzzzzzzzzzzzzzzzzz"} {
+		if _, err := store.GetSubscription(id); err != ErrInvalidID {
+			t.Errorf("WebhookStore.GetSubscription of the invalid id %v output err %v", id, err)
+		}
+	}
+}
@@ -2,40 +2,14 @@ package utils
 
 import (
 	"context"
-	"github.com/go-chi/render"
 	"go.mongodb.org/mongo-driver/mongo"
 	"log"
 	"net/http"
 	"net/url"
 	"strconv"
-	"strings"
 	errors2 "test/errors"
-	"time"
 )
 
-//Collect a date from query
-func DateFromQuery(dateQueryKey string, query url.Values) (time.Time, error) {
-	dateQuery, ok := query[dateQueryKey]
-	if ok && len(dateQuery[0]) > 0 {
-		return time.Parse(time.RFC3339, dateQuery[0])
-	} else {
-		return time.Time{}, nil
-	}
-}
-
-//Collect a string from query then escape it for safety and comparaison to db
-func StringFromQuery(queryKey string, query url.Values) string {
-	stringQuery, ok := query[queryKey]
-	if ok && len(stringQuery[0]) > 0 {
-		tmp := stringQuery[0]
-
-		tmp = strings.ReplaceAll(url.QueryEscape(tmp), "+", "%20")
-		return strings.ReplaceAll(tmp, ".", "\\.")
-	} else {
-		return ""
-	}
-}
-
 //Collect a int from query
 func Int64FromQuery(queryKey string, query url.Values) (int64, error) {
 	intQuery, ok := query[queryKey]
@@ -49,9 +23,22 @@ func Int64FromQuery(queryKey string, query url.Values) (int64, error) {
 	return 0, nil
 }
 
+// Render logs then writes err in the format of the request, see errors.Respond
 func Render(w http.ResponseWriter, r *http.Request, err error) {
 	log.Println(err)
-	_ = render.Render(w, r, errors2.ErrRender(err))
+	errors2.Respond(w, r, err)
+}
+
+func RenderUnauthorized(w http.ResponseWriter, r *http.Request, err error) {
+	Render(w, r, errors2.Unauthorized(err))
+}
+
+func RenderForbidden(w http.ResponseWriter, r *http.Request, err error) {
+	Render(w, r, errors2.Forbidden(err))
+}
+
+func RenderUnsupportedMediaType(w http.ResponseWriter, r *http.Request, err error) {
+	Render(w, r, errors2.UnsupportedMediaType(err))
 }
 
 // DbConnection represents the connection to pass around